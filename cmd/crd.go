@@ -18,7 +18,6 @@ import (
 	"fmt"
 
 	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/client"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -27,7 +26,7 @@ var format string
 // managecmd represents the manage command
 var crdCmd = &cobra.Command{
 	Use:   "crd",
-	Short: "Dump the custom resource definition to JSON or YAML",
+	Short: "Dump the custom resource definitions to JSON or YAML",
 	Run: func(cmd *cobra.Command, args []string) {
 		c := &client.Client{}
 		if format != "json" && format != "yaml" {
@@ -39,7 +38,6 @@ var crdCmd = &cobra.Command{
 }
 
 func init() {
-	log.SetLevel(log.DebugLevel)
 	crdCmd.Flags().StringVar(&format, "format", "yaml", "CRD output format (\"json\" or \"yaml\")")
 	RootCmd.AddCommand(crdCmd)
 
@@ -21,6 +21,7 @@ import (
 	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
 	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/controller"
 	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/healthz"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/redact"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -37,16 +38,22 @@ var manageCmd = &cobra.Command{
 			log.Fatalf("Failed to get config: %v", err)
 		}
 
+		// Mask secret material in every log line.
+		log.AddHook(&redact.Hook{})
+		if chartmgrconfig.DebugMode {
+			log.SetLevel(log.DebugLevel)
+		}
+
 		// Instantiate the Chart Manager controller.
 		chartmgrcontroller, err := controller.New(chartmgrconfig)
 		if err != nil {
 			log.Fatalf("Failed to create Chart Manager controller: %v", err)
 		}
 
-		// Create the CRD if it does not already exist.
-		_, err = chartmgrcontroller.CreateCustomResourceDefinition()
+		// Create the CRDs if they do not already exist.
+		err = chartmgrcontroller.CreateCustomResourceDefinitions()
 		if err != nil && !apierrors.IsAlreadyExists(err) {
-			log.Fatalf("Failed to create CRD: %v", err)
+			log.Fatalf("Failed to create CRDs: %v", err)
 		}
 
 		// Start the Chart Manager controller.
@@ -56,12 +63,13 @@ var manageCmd = &cobra.Command{
 
 		// Health check.
 		http.HandleFunc("/healthz", healthz.HandleFunc)
+		// Readiness check, failing while the release backend is unreachable.
+		http.HandleFunc("/readyz", healthz.ReadyFunc(chartmgrcontroller.HelmClient.Ready))
 		log.Fatal(http.ListenAndServe(":8080", nil))
 	},
 }
 
 func init() {
-	log.SetLevel(log.DebugLevel)
 	RootCmd.AddCommand(manageCmd)
 
 	// Here you will define your flags and configuration settings.
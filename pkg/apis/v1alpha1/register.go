@@ -29,6 +29,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&ChartManager{},
 		&ChartManagerList{},
+		&ChartRepository{},
+		&ChartRepositoryList{},
+		&ChartValuesProfile{},
+		&ChartValuesProfileList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -14,6 +14,18 @@ const (
 	ChartMgrResourceShortNameSingular = "chartmgr"
 	// ChartMgrResourceShortNamePlural is the short name for multiple CRDs.
 	ChartMgrResourceShortNamePlural = "chartmgrs"
+	// ChartRepositoryResourcePlural is the plural for the chart repository CRD.
+	ChartRepositoryResourcePlural = "chartrepositories"
+	// ChartRepositoryResourceShortNameSingular is the short name for the chart repository CRD.
+	ChartRepositoryResourceShortNameSingular = "chartrepo"
+	// ChartRepositoryResourceShortNamePlural is the short name for multiple chart repository CRDs.
+	ChartRepositoryResourceShortNamePlural = "chartrepos"
+	// ChartValuesProfileResourcePlural is the plural for the values profile CRD.
+	ChartValuesProfileResourcePlural = "chartvaluesprofiles"
+	// ChartValuesProfileResourceShortNameSingular is the short name for the values profile CRD.
+	ChartValuesProfileResourceShortNameSingular = "valuesprofile"
+	// ChartValuesProfileResourceShortNamePlural is the short name for multiple values profile CRDs.
+	ChartValuesProfileResourceShortNamePlural = "valuesprofiles"
 	// ChartMgrStateUnknown indicates that a release is in an uncertain state.
 	ChartMgrStateUnknown ChartMgrState = "Unknown"
 	// ChartMgrStateDeployed indicates that the release has been pushed to Kubernetes.
@@ -34,6 +46,51 @@ const (
 	ChartMgrStatePendingRollback ChartMgrState = "PendingRollback"
 )
 
+// ChartMgrSecretKeyRef represents a key of a Secret in the chartmgr's
+// namespace
+type ChartMgrSecretKeyRef struct {
+	Name string `json:"name,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+// ChartRepositoryState is the chart repository's index state string.
+type ChartRepositoryState string
+
+const (
+	// ChartRepositoryStateUnknown indicates that the index has not been refreshed yet.
+	ChartRepositoryStateUnknown ChartRepositoryState = "Unknown"
+	// ChartRepositoryStateHealthy indicates that the last index refresh succeeded.
+	ChartRepositoryStateHealthy ChartRepositoryState = "Healthy"
+	// ChartRepositoryStateUnhealthy indicates that the last index refresh failed.
+	ChartRepositoryStateUnhealthy ChartRepositoryState = "Unhealthy"
+)
+
+// ChartMgrValueType is the parsing mode of a chartmgr value pair.
+type ChartMgrValueType string
+
+const (
+	// ChartMgrValueTypeAuto infers the type of the value, the same as '--set'.
+	ChartMgrValueTypeAuto ChartMgrValueType = "auto"
+	// ChartMgrValueTypeString always treats the value as a string, the same as '--set-string'.
+	ChartMgrValueTypeString ChartMgrValueType = "string"
+	// ChartMgrValueTypeJSON parses the value as a JSON document, the same as '--set-json'.
+	ChartMgrValueTypeJSON ChartMgrValueType = "json"
+	// ChartMgrValueTypeFile reads the value from a ConfigMap or Secret key, the same as '--set-file'.
+	ChartMgrValueTypeFile ChartMgrValueType = "file"
+)
+
+// ChartMgrVerifyMode is the provenance verification mode of a chart.
+type ChartMgrVerifyMode string
+
+const (
+	// ChartMgrVerifyModeNone skips verification.
+	ChartMgrVerifyModeNone ChartMgrVerifyMode = "none"
+	// ChartMgrVerifyModeIfPossible verifies the chart if it is signed and a keyring is configured.
+	ChartMgrVerifyModeIfPossible ChartMgrVerifyMode = "ifPossible"
+	// ChartMgrVerifyModeAlways refuses charts that are not signed by a key of the keyring.
+	ChartMgrVerifyModeAlways ChartMgrVerifyMode = "always"
+)
+
 // ChartManager represents the chartmgr in Kubernetes.
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -50,11 +107,13 @@ type ChartMgrSpec struct {
 	Options *ChartMgrOptions     `json:"options,omitempty"`
 	Release *ChartMgrRelease     `json:"release,omitempty"`
 	Values  []*ChartMgrValuePair `json:"values,omitempty"`
+	Tiller  *ChartMgrTiller      `json:"tiller,omitempty"`
 }
 
 // ChartMgrOptions represents the chartmgr configuration options
 type ChartMgrOptions struct {
-	CreateOnly bool `json:"createOnly,omitempty"`
+	CreateOnly   bool `json:"createOnly,omitempty"`
+	StrictValues bool `json:"strictValues,omitempty"`
 }
 
 // ChartMgrRelease represents the chartmgr controller's helm release definition
@@ -62,31 +121,123 @@ type ChartMgrRelease struct {
 	Name string `json:"name,omitempty"`
 }
 
+// ChartMgrTiller selects the Tiller managing the release, by the namespace
+// it runs in or its host
+type ChartMgrTiller struct {
+	Namespace string `json:"namespace,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
+
 // ChartMgrChart represents the chartmgr controller's chart definition
 type ChartMgrChart struct {
-	Name       string                   `json:"name,omitempty"`
-	Version    string                   `json:"version,omitempty"`
-	Repository *ChartMgrChartRepository `json:"repository,omitempty"`
+	Name          string                   `json:"name,omitempty"`
+	Version       string                   `json:"version,omitempty"`
+	Repository    *ChartMgrChartRepository `json:"repository,omitempty"`
+	RepositoryRef *ChartMgrRepositoryRef   `json:"repositoryRef,omitempty"`
+	Git           *ChartMgrChartGit        `json:"git,omitempty"`
+	OCI           *ChartMgrChartOCI        `json:"oci,omitempty"`
+	ConfigMapRef  *ChartMgrConfigMapKeyRef `json:"configMapRef,omitempty"`
+	Path          string                   `json:"path,omitempty"`
+	Verify        *ChartMgrChartVerify     `json:"verify,omitempty"`
+	Digest        string                   `json:"digest,omitempty"`
+}
+
+// ChartMgrChartVerify represents the provenance verification policy of a
+// chart. it can only make the policy of the chart's repository stricter.
+type ChartMgrChartVerify struct {
+	Mode             ChartMgrVerifyMode    `json:"mode,omitempty"`
+	KeyringSecretRef *ChartMgrSecretKeyRef `json:"keyringSecretRef,omitempty"`
+}
+
+// ChartMgrChartGit represents a chart stored in a git repository
+type ChartMgrChartGit struct {
+	URL       string                       `json:"url,omitempty"`
+	Ref       string                       `json:"ref,omitempty"`
+	Path      string                       `json:"path,omitempty"`
+	SecretRef *ChartMgrRepositorySecretRef `json:"secretRef,omitempty"`
+	// InsecureSkipHostKeyVerification allows SSH connections without
+	// known_hosts in the secret, which don't verify the host key
+	InsecureSkipHostKeyVerification bool `json:"insecureSkipHostKeyVerification,omitempty"`
+}
+
+// ChartMgrChartOCI represents a chart stored in an OCI registry
+type ChartMgrChartOCI struct {
+	Reference string                       `json:"reference,omitempty"`
+	Tag       string                       `json:"tag,omitempty"`
+	Digest    string                       `json:"digest,omitempty"`
+	PlainHTTP bool                         `json:"plainHTTP,omitempty"`
+	SecretRef *ChartMgrRepositorySecretRef `json:"secretRef,omitempty"`
+}
+
+// ChartMgrRepositoryRef represents a reference to a ChartRepository by name
+type ChartMgrRepositoryRef struct {
+	Name string `json:"name,omitempty"`
 }
 
 // ChartMgrChartRepository represents the chartmgr controller's
 // chart repository definition
 type ChartMgrChartRepository struct {
+	Name      string                       `json:"name,omitempty"`
+	URL       string                       `json:"url,omitempty"`
+	SecretRef *ChartMgrRepositorySecretRef `json:"secretRef,omitempty"`
+}
+
+// ChartMgrRepositorySecretRef represents a Secret in the chartmgr's
+// namespace holding the credentials of a chart repository
+type ChartMgrRepositorySecretRef struct {
 	Name string `json:"name,omitempty"`
-	URL  string `json:"url,omitempty"`
 }
 
 // ChartMgrValuePair represents an chartmgr controller name/value pair
 type ChartMgrValuePair struct {
-	Name  string `json:"name,omitempty"`
-	Value string `json:"value,omitempty"`
+	Name      string               `json:"name,omitempty"`
+	Value     string               `json:"value,omitempty"`
+	Type      ChartMgrValueType    `json:"type,omitempty"`
+	ValueFrom *ChartMgrValueSource `json:"valueFrom,omitempty"`
+	Sensitive bool                 `json:"sensitive,omitempty"`
+}
+
+// ChartMgrValueSource represents the source of a chartmgr controller
+// value that is not specified inline
+type ChartMgrValueSource struct {
+	ConfigMapKeyRef *ChartMgrConfigMapKeyRef `json:"configMapKeyRef,omitempty"`
+	SecretKeyRef    *ChartMgrSecretKeyRef    `json:"secretKeyRef,omitempty"`
+}
+
+// ChartMgrConfigMapKeyRef represents a key of a ConfigMap in the
+// chartmgr's namespace
+type ChartMgrConfigMapKeyRef struct {
+	Name string `json:"name,omitempty"`
+	Key  string `json:"key,omitempty"`
 }
 
 // ChartMgrStatus is the ChartMgr controller's status.
 type ChartMgrStatus struct {
-	State       ChartMgrState `json:"state,omitempty"`
-	ReleaseName string        `json:"release,omitempty"`
-	Message     string        `json:"message,omitempty"`
+	State           ChartMgrState        `json:"state,omitempty"`
+	ReleaseName     string               `json:"release,omitempty"`
+	Message         string               `json:"message,omitempty"`
+	InvalidValues   []string             `json:"invalidValues,omitempty"`
+	AppliedProfiles []string             `json:"appliedProfiles,omitempty"`
+	GitCommit       string               `json:"gitCommit,omitempty"`
+	ChartDigest     string               `json:"chartDigest,omitempty"`
+	Provenance      *ChartMgrProvenance  `json:"provenance,omitempty"`
+	Dependencies    []ChartMgrDependency `json:"dependencies,omitempty"`
+}
+
+// ChartMgrDependency is a dependency of the chart and its resolved version.
+type ChartMgrDependency struct {
+	Name       string `json:"name"`
+	Version    string `json:"version,omitempty"`
+	Repository string `json:"repository,omitempty"`
+}
+
+// ChartMgrProvenance is the result of the provenance verification of the
+// chart.
+type ChartMgrProvenance struct {
+	Verified    bool   `json:"verified"`
+	SignedBy    string `json:"signedBy,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Reason      string `json:"reason,omitempty"`
 }
 
 // ChartManagerList represents a list of chartmgrs.
@@ -96,3 +247,113 @@ type ChartManagerList struct {
 	metav1.ListMeta `json:"metadata"`
 	Items           []ChartManager `json:"items"`
 }
+
+// ChartValuesProfile represents a cluster-wide set of default values that
+// are merged into every matching chartmgr.
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ChartValuesProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              ChartValuesProfileSpec `json:"spec,omitempty"`
+}
+
+// ChartValuesProfileSpec represents the values profile's spec. Profiles are
+// applied in ascending priority order, so a profile with a higher priority
+// overrides one with a lower priority. The chartmgr's own values override
+// all profiles. Values with a valueFrom are read from the namespace of each
+// matching chartmgr.
+type ChartValuesProfileSpec struct {
+	Priority int32                       `json:"priority,omitempty"`
+	Selector *ChartValuesProfileSelector `json:"selector,omitempty"`
+	Values   []*ChartMgrValuePair        `json:"values,omitempty"`
+}
+
+// ChartValuesProfileSelector represents the chartmgrs a values profile
+// applies to. An empty selector matches every chartmgr.
+type ChartValuesProfileSelector struct {
+	Charts           []string              `json:"charts,omitempty"`
+	ChartMgrSelector *metav1.LabelSelector `json:"chartManagerSelector,omitempty"`
+}
+
+// ChartValuesProfileList represents a list of values profiles.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ChartValuesProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []ChartValuesProfile `json:"items"`
+}
+
+// ChartRepository represents a cluster-wide chart repository that chartmgrs
+// reference by name.
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ChartRepository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              ChartRepositorySpec   `json:"spec,omitempty"`
+	Status            ChartRepositoryStatus `json:"status,omitempty"`
+}
+
+// ChartRepositorySpec represents the chart repository's spec.
+type ChartRepositorySpec struct {
+	URL                string                    `json:"url,omitempty"`
+	SecretRef          *ChartRepositorySecretRef `json:"secretRef,omitempty"`
+	RefreshIntervalSec int64                     `json:"refreshIntervalSec,omitempty"`
+	TLS                *ChartRepositoryTLS       `json:"tls,omitempty"`
+	Verify             *ChartRepositoryVerify    `json:"verify,omitempty"`
+	Proxy              *ChartRepositoryProxy     `json:"proxy,omitempty"`
+}
+
+// ChartRepositoryVerify represents the provenance verification policy of the
+// charts of a chart repository
+type ChartRepositoryVerify struct {
+	Mode             ChartMgrVerifyMode               `json:"mode,omitempty"`
+	KeyringSecretRef *ChartRepositoryKeyringSecretRef `json:"keyringSecretRef,omitempty"`
+}
+
+// ChartRepositoryKeyringSecretRef represents the Secret key holding the
+// keyring charts of a chart repository are verified against
+type ChartRepositoryKeyringSecretRef struct {
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key,omitempty"`
+}
+
+// ChartRepositorySecretRef represents the Secret holding the credentials of
+// a chart repository
+type ChartRepositorySecretRef struct {
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ChartRepositoryTLS represents the TLS settings of a chart repository
+type ChartRepositoryTLS struct {
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+}
+
+// ChartRepositoryProxy represents the HTTP proxy settings of a chart
+// repository. an empty URL connects to the repository directly.
+type ChartRepositoryProxy struct {
+	URL     string   `json:"url,omitempty"`
+	NoProxy []string `json:"noProxy,omitempty"`
+}
+
+// ChartRepositoryStatus is the chart repository's index status.
+type ChartRepositoryStatus struct {
+	State           ChartRepositoryState `json:"state,omitempty"`
+	LastRefreshTime *metav1.Time         `json:"lastRefreshTime,omitempty"`
+	ChartCount      int                  `json:"chartCount,omitempty"`
+	Message         string               `json:"message,omitempty"`
+}
+
+// ChartRepositoryList represents a list of chart repositories.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ChartRepositoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []ChartRepository `json:"items"`
+}
@@ -5,6 +5,7 @@
 package v1alpha1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	conversion "k8s.io/apimachinery/pkg/conversion"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	reflect "reflect"
@@ -27,18 +28,54 @@ func GetGeneratedDeepCopyFuncs() []conversion.GeneratedDeepCopyFunc {
 			in.(*ChartMgrChart).DeepCopyInto(out.(*ChartMgrChart))
 			return nil
 		}, InType: reflect.TypeOf(&ChartMgrChart{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrChartGit).DeepCopyInto(out.(*ChartMgrChartGit))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrChartGit{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrChartOCI).DeepCopyInto(out.(*ChartMgrChartOCI))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrChartOCI{})},
 		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*ChartMgrChartRepository).DeepCopyInto(out.(*ChartMgrChartRepository))
 			return nil
 		}, InType: reflect.TypeOf(&ChartMgrChartRepository{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrChartVerify).DeepCopyInto(out.(*ChartMgrChartVerify))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrChartVerify{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrConfigMapKeyRef).DeepCopyInto(out.(*ChartMgrConfigMapKeyRef))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrConfigMapKeyRef{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrDependency).DeepCopyInto(out.(*ChartMgrDependency))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrDependency{})},
 		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*ChartMgrOptions).DeepCopyInto(out.(*ChartMgrOptions))
 			return nil
 		}, InType: reflect.TypeOf(&ChartMgrOptions{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrProvenance).DeepCopyInto(out.(*ChartMgrProvenance))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrProvenance{})},
 		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*ChartMgrRelease).DeepCopyInto(out.(*ChartMgrRelease))
 			return nil
 		}, InType: reflect.TypeOf(&ChartMgrRelease{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrRepositoryRef).DeepCopyInto(out.(*ChartMgrRepositoryRef))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrRepositoryRef{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrRepositorySecretRef).DeepCopyInto(out.(*ChartMgrRepositorySecretRef))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrRepositorySecretRef{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrSecretKeyRef).DeepCopyInto(out.(*ChartMgrSecretKeyRef))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrSecretKeyRef{})},
 		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*ChartMgrSpec).DeepCopyInto(out.(*ChartMgrSpec))
 			return nil
@@ -47,10 +84,70 @@ func GetGeneratedDeepCopyFuncs() []conversion.GeneratedDeepCopyFunc {
 			in.(*ChartMgrStatus).DeepCopyInto(out.(*ChartMgrStatus))
 			return nil
 		}, InType: reflect.TypeOf(&ChartMgrStatus{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrTiller).DeepCopyInto(out.(*ChartMgrTiller))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrTiller{})},
 		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*ChartMgrValuePair).DeepCopyInto(out.(*ChartMgrValuePair))
 			return nil
 		}, InType: reflect.TypeOf(&ChartMgrValuePair{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartMgrValueSource).DeepCopyInto(out.(*ChartMgrValueSource))
+			return nil
+		}, InType: reflect.TypeOf(&ChartMgrValueSource{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartRepository).DeepCopyInto(out.(*ChartRepository))
+			return nil
+		}, InType: reflect.TypeOf(&ChartRepository{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartRepositoryKeyringSecretRef).DeepCopyInto(out.(*ChartRepositoryKeyringSecretRef))
+			return nil
+		}, InType: reflect.TypeOf(&ChartRepositoryKeyringSecretRef{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartRepositoryList).DeepCopyInto(out.(*ChartRepositoryList))
+			return nil
+		}, InType: reflect.TypeOf(&ChartRepositoryList{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartRepositoryProxy).DeepCopyInto(out.(*ChartRepositoryProxy))
+			return nil
+		}, InType: reflect.TypeOf(&ChartRepositoryProxy{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartRepositorySecretRef).DeepCopyInto(out.(*ChartRepositorySecretRef))
+			return nil
+		}, InType: reflect.TypeOf(&ChartRepositorySecretRef{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartRepositorySpec).DeepCopyInto(out.(*ChartRepositorySpec))
+			return nil
+		}, InType: reflect.TypeOf(&ChartRepositorySpec{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartRepositoryStatus).DeepCopyInto(out.(*ChartRepositoryStatus))
+			return nil
+		}, InType: reflect.TypeOf(&ChartRepositoryStatus{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartRepositoryTLS).DeepCopyInto(out.(*ChartRepositoryTLS))
+			return nil
+		}, InType: reflect.TypeOf(&ChartRepositoryTLS{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartRepositoryVerify).DeepCopyInto(out.(*ChartRepositoryVerify))
+			return nil
+		}, InType: reflect.TypeOf(&ChartRepositoryVerify{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartValuesProfile).DeepCopyInto(out.(*ChartValuesProfile))
+			return nil
+		}, InType: reflect.TypeOf(&ChartValuesProfile{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartValuesProfileList).DeepCopyInto(out.(*ChartValuesProfileList))
+			return nil
+		}, InType: reflect.TypeOf(&ChartValuesProfileList{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartValuesProfileSelector).DeepCopyInto(out.(*ChartValuesProfileSelector))
+			return nil
+		}, InType: reflect.TypeOf(&ChartValuesProfileSelector{})},
+		{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ChartValuesProfileSpec).DeepCopyInto(out.(*ChartValuesProfileSpec))
+			return nil
+		}, InType: reflect.TypeOf(&ChartValuesProfileSpec{})},
 	}
 }
 
@@ -60,7 +157,7 @@ func (in *ChartManager) DeepCopyInto(out *ChartManager) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -126,9 +223,54 @@ func (in *ChartMgrChart) DeepCopyInto(out *ChartMgrChart) {
 			*out = nil
 		} else {
 			*out = new(ChartMgrChartRepository)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.RepositoryRef != nil {
+		in, out := &in.RepositoryRef, &out.RepositoryRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrRepositoryRef)
+			**out = **in
+		}
+	}
+	if in.Git != nil {
+		in, out := &in.Git, &out.Git
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrChartGit)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.OCI != nil {
+		in, out := &in.OCI, &out.OCI
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrChartOCI)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.ConfigMapRef != nil {
+		in, out := &in.ConfigMapRef, &out.ConfigMapRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrConfigMapKeyRef)
 			**out = **in
 		}
 	}
+	if in.Verify != nil {
+		in, out := &in.Verify, &out.Verify
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrChartVerify)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	return
 }
 
@@ -142,9 +284,68 @@ func (in *ChartMgrChart) DeepCopy() *ChartMgrChart {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrChartGit) DeepCopyInto(out *ChartMgrChartGit) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrRepositorySecretRef)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrChartGit.
+func (in *ChartMgrChartGit) DeepCopy() *ChartMgrChartGit {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrChartGit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrChartOCI) DeepCopyInto(out *ChartMgrChartOCI) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrRepositorySecretRef)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrChartOCI.
+func (in *ChartMgrChartOCI) DeepCopy() *ChartMgrChartOCI {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrChartOCI)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ChartMgrChartRepository) DeepCopyInto(out *ChartMgrChartRepository) {
 	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrRepositorySecretRef)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -158,6 +359,63 @@ func (in *ChartMgrChartRepository) DeepCopy() *ChartMgrChartRepository {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrChartVerify) DeepCopyInto(out *ChartMgrChartVerify) {
+	*out = *in
+	if in.KeyringSecretRef != nil {
+		in, out := &in.KeyringSecretRef, &out.KeyringSecretRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrSecretKeyRef)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrChartVerify.
+func (in *ChartMgrChartVerify) DeepCopy() *ChartMgrChartVerify {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrChartVerify)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrConfigMapKeyRef) DeepCopyInto(out *ChartMgrConfigMapKeyRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrConfigMapKeyRef.
+func (in *ChartMgrConfigMapKeyRef) DeepCopy() *ChartMgrConfigMapKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrConfigMapKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrDependency) DeepCopyInto(out *ChartMgrDependency) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrDependency.
+func (in *ChartMgrDependency) DeepCopy() *ChartMgrDependency {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrDependency)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ChartMgrOptions) DeepCopyInto(out *ChartMgrOptions) {
 	*out = *in
@@ -174,6 +432,22 @@ func (in *ChartMgrOptions) DeepCopy() *ChartMgrOptions {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrProvenance) DeepCopyInto(out *ChartMgrProvenance) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrProvenance.
+func (in *ChartMgrProvenance) DeepCopy() *ChartMgrProvenance {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrProvenance)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ChartMgrRelease) DeepCopyInto(out *ChartMgrRelease) {
 	*out = *in
@@ -190,6 +464,54 @@ func (in *ChartMgrRelease) DeepCopy() *ChartMgrRelease {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrRepositoryRef) DeepCopyInto(out *ChartMgrRepositoryRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrRepositoryRef.
+func (in *ChartMgrRepositoryRef) DeepCopy() *ChartMgrRepositoryRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrRepositoryRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrRepositorySecretRef) DeepCopyInto(out *ChartMgrRepositorySecretRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrRepositorySecretRef.
+func (in *ChartMgrRepositorySecretRef) DeepCopy() *ChartMgrRepositorySecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrRepositorySecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrSecretKeyRef) DeepCopyInto(out *ChartMgrSecretKeyRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrSecretKeyRef.
+func (in *ChartMgrSecretKeyRef) DeepCopy() *ChartMgrSecretKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrSecretKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ChartMgrSpec) DeepCopyInto(out *ChartMgrSpec) {
 	*out = *in
@@ -232,6 +554,15 @@ func (in *ChartMgrSpec) DeepCopyInto(out *ChartMgrSpec) {
 			}
 		}
 	}
+	if in.Tiller != nil {
+		in, out := &in.Tiller, &out.Tiller
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrTiller)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -248,6 +579,30 @@ func (in *ChartMgrSpec) DeepCopy() *ChartMgrSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ChartMgrStatus) DeepCopyInto(out *ChartMgrStatus) {
 	*out = *in
+	if in.InvalidValues != nil {
+		in, out := &in.InvalidValues, &out.InvalidValues
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AppliedProfiles != nil {
+		in, out := &in.AppliedProfiles, &out.AppliedProfiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Provenance != nil {
+		in, out := &in.Provenance, &out.Provenance
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrProvenance)
+			**out = **in
+		}
+	}
+	if in.Dependencies != nil {
+		in, out := &in.Dependencies, &out.Dependencies
+		*out = make([]ChartMgrDependency, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -261,9 +616,34 @@ func (in *ChartMgrStatus) DeepCopy() *ChartMgrStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrTiller) DeepCopyInto(out *ChartMgrTiller) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrTiller.
+func (in *ChartMgrTiller) DeepCopy() *ChartMgrTiller {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrTiller)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ChartMgrValuePair) DeepCopyInto(out *ChartMgrValuePair) {
 	*out = *in
+	if in.ValueFrom != nil {
+		in, out := &in.ValueFrom, &out.ValueFrom
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrValueSource)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	return
 }
 
@@ -276,3 +656,400 @@ func (in *ChartMgrValuePair) DeepCopy() *ChartMgrValuePair {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartMgrValueSource) DeepCopyInto(out *ChartMgrValueSource) {
+	*out = *in
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrConfigMapKeyRef)
+			**out = **in
+		}
+	}
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartMgrSecretKeyRef)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartMgrValueSource.
+func (in *ChartMgrValueSource) DeepCopy() *ChartMgrValueSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartMgrValueSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartRepository) DeepCopyInto(out *ChartRepository) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartRepository.
+func (in *ChartRepository) DeepCopy() *ChartRepository {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartRepository)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ChartRepository) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	} else {
+		return nil
+	}
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartRepositoryKeyringSecretRef) DeepCopyInto(out *ChartRepositoryKeyringSecretRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartRepositoryKeyringSecretRef.
+func (in *ChartRepositoryKeyringSecretRef) DeepCopy() *ChartRepositoryKeyringSecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartRepositoryKeyringSecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartRepositoryList) DeepCopyInto(out *ChartRepositoryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ChartRepository, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartRepositoryList.
+func (in *ChartRepositoryList) DeepCopy() *ChartRepositoryList {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartRepositoryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ChartRepositoryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	} else {
+		return nil
+	}
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartRepositoryProxy) DeepCopyInto(out *ChartRepositoryProxy) {
+	*out = *in
+	if in.NoProxy != nil {
+		in, out := &in.NoProxy, &out.NoProxy
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartRepositoryProxy.
+func (in *ChartRepositoryProxy) DeepCopy() *ChartRepositoryProxy {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartRepositoryProxy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartRepositorySecretRef) DeepCopyInto(out *ChartRepositorySecretRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartRepositorySecretRef.
+func (in *ChartRepositorySecretRef) DeepCopy() *ChartRepositorySecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartRepositorySecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartRepositorySpec) DeepCopyInto(out *ChartRepositorySpec) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartRepositorySecretRef)
+			**out = **in
+		}
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartRepositoryTLS)
+			**out = **in
+		}
+	}
+	if in.Verify != nil {
+		in, out := &in.Verify, &out.Verify
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartRepositoryVerify)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.Proxy != nil {
+		in, out := &in.Proxy, &out.Proxy
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartRepositoryProxy)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartRepositorySpec.
+func (in *ChartRepositorySpec) DeepCopy() *ChartRepositorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartRepositorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartRepositoryStatus) DeepCopyInto(out *ChartRepositoryStatus) {
+	*out = *in
+	if in.LastRefreshTime != nil {
+		in, out := &in.LastRefreshTime, &out.LastRefreshTime
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.Time)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartRepositoryStatus.
+func (in *ChartRepositoryStatus) DeepCopy() *ChartRepositoryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartRepositoryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartRepositoryTLS) DeepCopyInto(out *ChartRepositoryTLS) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartRepositoryTLS.
+func (in *ChartRepositoryTLS) DeepCopy() *ChartRepositoryTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartRepositoryTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartRepositoryVerify) DeepCopyInto(out *ChartRepositoryVerify) {
+	*out = *in
+	if in.KeyringSecretRef != nil {
+		in, out := &in.KeyringSecretRef, &out.KeyringSecretRef
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartRepositoryKeyringSecretRef)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartRepositoryVerify.
+func (in *ChartRepositoryVerify) DeepCopy() *ChartRepositoryVerify {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartRepositoryVerify)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartValuesProfile) DeepCopyInto(out *ChartValuesProfile) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartValuesProfile.
+func (in *ChartValuesProfile) DeepCopy() *ChartValuesProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartValuesProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ChartValuesProfile) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	} else {
+		return nil
+	}
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartValuesProfileList) DeepCopyInto(out *ChartValuesProfileList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ChartValuesProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartValuesProfileList.
+func (in *ChartValuesProfileList) DeepCopy() *ChartValuesProfileList {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartValuesProfileList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ChartValuesProfileList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	} else {
+		return nil
+	}
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartValuesProfileSelector) DeepCopyInto(out *ChartValuesProfileSelector) {
+	*out = *in
+	if in.Charts != nil {
+		in, out := &in.Charts, &out.Charts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ChartMgrSelector != nil {
+		in, out := &in.ChartMgrSelector, &out.ChartMgrSelector
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.LabelSelector)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartValuesProfileSelector.
+func (in *ChartValuesProfileSelector) DeepCopy() *ChartValuesProfileSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartValuesProfileSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartValuesProfileSpec) DeepCopyInto(out *ChartValuesProfileSpec) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ChartValuesProfileSelector)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]*ChartMgrValuePair, len(*in))
+		for i := range *in {
+			if (*in)[i] == nil {
+				(*out)[i] = nil
+			} else {
+				(*out)[i] = new(ChartMgrValuePair)
+				(*in)[i].DeepCopyInto((*out)[i])
+			}
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartValuesProfileSpec.
+func (in *ChartValuesProfileSpec) DeepCopy() *ChartValuesProfileSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartValuesProfileSpec)
+	in.DeepCopyInto(out)
+	return out
+}
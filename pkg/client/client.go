@@ -10,19 +10,39 @@ import (
 	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
 	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/constants"
 	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 )
 
 const crdName = crv1alpha1.ChartMgrResourcePlural + "." + crv1alpha1.GroupName
 
+const repositoryCRDName = crv1alpha1.ChartRepositoryResourcePlural + "." + crv1alpha1.GroupName
+
+const profileCRDName = crv1alpha1.ChartValuesProfileResourcePlural + "." + crv1alpha1.GroupName
+
+// Interface is the API of the Chart Manager custom resources used by the
+// controller. It is implemented by Client and by the in-memory fake in
+// package fake.
+type Interface interface {
+	CreateCustomResourceDefinitions() error
+	GetChartManager(namespace string, name string) (*crv1alpha1.ChartManager, error)
+	UpdateChartManager(chartmgr *crv1alpha1.ChartManager) error
+	ListChartValuesProfiles() ([]crv1alpha1.ChartValuesProfile, error)
+	GetChartRepository(name string) (*crv1alpha1.ChartRepository, error)
+	UpdateChartRepository(repository *crv1alpha1.ChartRepository) error
+	ListWatch(resource string) cache.ListerWatcher
+}
+
 // Client represents the Chart Manager client.
 type Client struct {
 	Clientset              *clientset.Clientset
@@ -80,19 +100,30 @@ func restConfig(cfg *rest.Config, s *runtime.Scheme) rest.Config {
 	return config
 }
 
-// CreateCustomResourceDefinition creates the CRD for chartmgrs.
-func (c *Client) CreateCustomResourceDefinition() (*apiextensionsv1beta1.CustomResourceDefinition, error) {
-	crd := c.getCRD()
+// CreateCustomResourceDefinitions creates the CRDs for chartmgrs, chart
+// repositories and values profiles.
+func (c *Client) CreateCustomResourceDefinitions() error {
+	for _, crd := range c.getCRDs() {
+		_, err := c.createCustomResourceDefinition(crd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) createCustomResourceDefinition(crd *apiextensionsv1beta1.CustomResourceDefinition) (*apiextensionsv1beta1.CustomResourceDefinition, error) {
+	crdName := crd.ObjectMeta.Name
 
 	log.Infof("Creating CRD %s", crdName)
-	crd, err := c.APIExtensionsClientset.ApiextensionsV1beta1().CustomResourceDefinitions().Create(crd)
+	created, err := c.APIExtensionsClientset.ApiextensionsV1beta1().CustomResourceDefinitions().Create(crd)
 	if err != nil {
 		if !strings.Contains(err.Error(), "already exists") {
 			return nil, err
 		}
-		return c.updateCustomResourceDefinition(crdName)
+		return c.updateCustomResourceDefinition(crd)
 	}
-	return crd, c.verify(crdName)
+	return created, c.verify(crdName)
 }
 
 func (c *Client) verify(crdName string) error {
@@ -144,6 +175,14 @@ func (c *Client) checkCondition(cond apiextensionsv1beta1.CustomResourceDefiniti
 	return false
 }
 
+func (c *Client) getCRDs() []*apiextensionsv1beta1.CustomResourceDefinition {
+	return []*apiextensionsv1beta1.CustomResourceDefinition{
+		c.getCRD(),
+		c.getRepositoryCRD(),
+		c.getProfileCRD(),
+	}
+}
+
 func (c *Client) getCRD() *apiextensionsv1beta1.CustomResourceDefinition {
 	return &apiextensionsv1beta1.CustomResourceDefinition{
 		ObjectMeta: metav1.ObjectMeta{
@@ -166,38 +205,162 @@ func (c *Client) getCRD() *apiextensionsv1beta1.CustomResourceDefinition {
 	}
 }
 
-// GetCRDString returns the CRD as a YAML or JSON string
+func (c *Client) getRepositoryCRD() *apiextensionsv1beta1.CustomResourceDefinition {
+	return &apiextensionsv1beta1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: repositoryCRDName,
+		},
+		Spec: apiextensionsv1beta1.CustomResourceDefinitionSpec{
+			Group:   crv1alpha1.GroupName,
+			Version: crv1alpha1.SchemeGroupVersion.Version,
+			Scope:   apiextensionsv1beta1.ClusterScoped,
+			Names: apiextensionsv1beta1.CustomResourceDefinitionNames{
+				Plural: crv1alpha1.ChartRepositoryResourcePlural,
+				ShortNames: []string{
+					crv1alpha1.ChartRepositoryResourceShortNameSingular,
+					crv1alpha1.ChartRepositoryResourceShortNamePlural,
+				},
+				Kind: reflect.TypeOf(crv1alpha1.ChartRepository{}).Name(),
+			},
+			Validation: constants.ChartRepositoryValidationRules(),
+		},
+	}
+}
+
+func (c *Client) getProfileCRD() *apiextensionsv1beta1.CustomResourceDefinition {
+	return &apiextensionsv1beta1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: profileCRDName,
+		},
+		Spec: apiextensionsv1beta1.CustomResourceDefinitionSpec{
+			Group:   crv1alpha1.GroupName,
+			Version: crv1alpha1.SchemeGroupVersion.Version,
+			Scope:   apiextensionsv1beta1.ClusterScoped,
+			Names: apiextensionsv1beta1.CustomResourceDefinitionNames{
+				Plural: crv1alpha1.ChartValuesProfileResourcePlural,
+				ShortNames: []string{
+					crv1alpha1.ChartValuesProfileResourceShortNameSingular,
+					crv1alpha1.ChartValuesProfileResourceShortNamePlural,
+				},
+				Kind: reflect.TypeOf(crv1alpha1.ChartValuesProfile{}).Name(),
+			},
+			Validation: constants.ChartValuesProfileValidationRules(),
+		},
+	}
+}
+
+// GetCRDString returns the CRDs as a YAML or JSON string
 func (c *Client) GetCRDString(format string) string {
-	crd := c.getCRD()
+	docs := []string{}
+	for _, crd := range c.getCRDs() {
+		var s []byte
+		var err error
 
-	var s []byte
-	var err error
+		switch format {
+		case "yaml":
+			s, err = yaml.Marshal(crd)
+		case "json":
+			s, err = json.MarshalIndent(crd, "", "  ")
+		default:
+			s, err = yaml.Marshal(crd)
+		}
+		if err != nil {
+			log.Errorf("%v", err)
+			return ""
+		}
+		docs = append(docs, string(s))
+	}
 
-	switch format {
-	case "yaml":
-		s, err = yaml.Marshal(crd)
-	case "json":
-		s, err = json.MarshalIndent(crd, "", "  ")
-	default:
-		s, err = yaml.Marshal(crd)
+	if format == "json" {
+		return strings.Join(docs, "\n")
 	}
+	return strings.Join(docs, "---\n")
+}
+
+// ListChartValuesProfiles returns all values profiles in the cluster.
+func (c *Client) ListChartValuesProfiles() ([]crv1alpha1.ChartValuesProfile, error) {
+	list := &crv1alpha1.ChartValuesProfileList{}
+	err := c.RESTClient.Get().
+		Resource(crv1alpha1.ChartValuesProfileResourcePlural).
+		Do().
+		Into(list)
 	if err != nil {
-		log.Errorf("%v", err)
-		return ""
+		return nil, err
 	}
-	return string(s)
+	return list.Items, nil
 }
 
-func (c *Client) updateCustomResourceDefinition(crdName string) (*apiextensionsv1beta1.CustomResourceDefinition, error) {
+// updateCustomResourceDefinition updates the existing CRD with the names and
+// validation of the desired CRD, e.g. when the controller is upgraded
+func (c *Client) updateCustomResourceDefinition(desired *apiextensionsv1beta1.CustomResourceDefinition) (*apiextensionsv1beta1.CustomResourceDefinition, error) {
+	crdName := desired.ObjectMeta.Name
 	log.Warnf("CRD %s already exists. Attempting to update.", crdName)
 	crd, err := c.APIExtensionsClientset.ApiextensionsV1beta1().CustomResourceDefinitions().Get(crdName, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 
+	// the group, version and scope of an established CRD can't change
+	crd.Spec.Names = desired.Spec.Names
+	crd.Spec.Validation = desired.Spec.Validation
 	crd, err = c.APIExtensionsClientset.ApiextensionsV1beta1().CustomResourceDefinitions().Update(crd)
 	if err != nil {
 		return nil, err
 	}
 	return crd, c.verify(crdName)
 }
+
+// GetChartManager returns the chart manager with the given namespace and name.
+func (c *Client) GetChartManager(namespace string, name string) (*crv1alpha1.ChartManager, error) {
+	chartmgr := &crv1alpha1.ChartManager{}
+	err := c.RESTClient.Get().
+		Namespace(namespace).
+		Resource(crv1alpha1.ChartMgrResourcePlural).
+		Name(name).
+		Do().
+		Into(chartmgr)
+	if err != nil {
+		return nil, err
+	}
+	return chartmgr, nil
+}
+
+// UpdateChartManager updates the chart manager.
+func (c *Client) UpdateChartManager(chartmgr *crv1alpha1.ChartManager) error {
+	return c.RESTClient.Put().
+		Name(chartmgr.ObjectMeta.Name).
+		Namespace(chartmgr.ObjectMeta.Namespace).
+		Resource(crv1alpha1.ChartMgrResourcePlural).
+		Body(chartmgr).
+		Do().
+		Error()
+}
+
+// GetChartRepository returns the chart repository with the given name.
+func (c *Client) GetChartRepository(name string) (*crv1alpha1.ChartRepository, error) {
+	repository := &crv1alpha1.ChartRepository{}
+	err := c.RESTClient.Get().
+		Resource(crv1alpha1.ChartRepositoryResourcePlural).
+		Name(name).
+		Do().
+		Into(repository)
+	if err != nil {
+		return nil, err
+	}
+	return repository, nil
+}
+
+// UpdateChartRepository updates the chart repository.
+func (c *Client) UpdateChartRepository(repository *crv1alpha1.ChartRepository) error {
+	return c.RESTClient.Put().
+		Resource(crv1alpha1.ChartRepositoryResourcePlural).
+		Name(repository.ObjectMeta.Name).
+		Body(repository).
+		Do().
+		Error()
+}
+
+// ListWatch returns a list watch of the resource in all namespaces.
+func (c *Client) ListWatch(resource string) cache.ListerWatcher {
+	return cache.NewListWatchFromClient(c.RESTClient, resource, apiv1.NamespaceAll, fields.Everything())
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/client/fake"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCreateCustomResourceDefinitions(t *testing.T) {
+	// a CRD of an older controller, without validation and short names
+	established := apiextensionsv1beta1.CustomResourceDefinitionStatus{
+		Conditions: []apiextensionsv1beta1.CustomResourceDefinitionCondition{
+			{Type: apiextensionsv1beta1.Established, Status: apiextensionsv1beta1.ConditionTrue},
+		},
+	}
+	old := (&Client{}).getCRD()
+	old.Spec.Names.ShortNames = nil
+	old.Spec.Validation = nil
+	old.Status = established
+
+	tests := []struct {
+		name     string
+		existing []runtime.Object
+	}{
+		{name: "created"},
+		{name: "updated", existing: []runtime.Object{old}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, err := fake.NewKubeAPI(tt.existing...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer api.Close()
+			c := &Client{APIExtensionsClientset: api.APIExtensionsClientset}
+
+			err = c.CreateCustomResourceDefinitions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range c.getCRDs() {
+				got, err := api.APIExtensionsClientset.ApiextensionsV1beta1().CustomResourceDefinitions().Get(want.ObjectMeta.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got.Spec, want.Spec) {
+					t.Errorf("spec of %s = %+v, want %+v", want.ObjectMeta.Name, got.Spec, want.Spec)
+				}
+				if !reflect.DeepEqual(got.Status, established) {
+					t.Errorf("status of %s = %+v", want.ObjectMeta.Name, got.Status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Package fake provides an in-memory implementation of the Chart Manager
+// client and a fake Kubernetes API server for tests.
+package fake
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Client is an in-memory Chart Manager client. Objects are deep copied in
+// and out, and updates bump the resource version like the API server does.
+type Client struct {
+	mu           sync.Mutex
+	chartmgrs    map[string]*crv1alpha1.ChartManager
+	repositories map[string]*crv1alpha1.ChartRepository
+	profiles     []crv1alpha1.ChartValuesProfile
+	version      int
+}
+
+// NewClient returns a client holding the objects, which may be chart
+// managers, chart repositories and values profiles.
+func NewClient(objects ...runtime.Object) *Client {
+	c := &Client{
+		chartmgrs:    map[string]*crv1alpha1.ChartManager{},
+		repositories: map[string]*crv1alpha1.ChartRepository{},
+	}
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *crv1alpha1.ChartManager:
+			c.chartmgrs[key(o.ObjectMeta)] = o.DeepCopy()
+		case *crv1alpha1.ChartRepository:
+			c.repositories[o.ObjectMeta.Name] = o.DeepCopy()
+		case *crv1alpha1.ChartValuesProfile:
+			c.profiles = append(c.profiles, *o.DeepCopy())
+		default:
+			panic(fmt.Sprintf("unsupported object %T", obj))
+		}
+	}
+	return c
+}
+
+// CreateCustomResourceDefinitions does nothing.
+func (c *Client) CreateCustomResourceDefinitions() error {
+	return nil
+}
+
+// GetChartManager returns the chart manager with the given namespace and name.
+func (c *Client) GetChartManager(namespace string, name string) (*crv1alpha1.ChartManager, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	chartmgr, ok := c.chartmgrs[namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(crv1alpha1.SchemeGroupVersion.WithResource(crv1alpha1.ChartMgrResourcePlural).GroupResource(), name)
+	}
+	return chartmgr.DeepCopy(), nil
+}
+
+// UpdateChartManager updates the chart manager.
+func (c *Client) UpdateChartManager(chartmgr *crv1alpha1.ChartManager) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := key(chartmgr.ObjectMeta)
+	if _, ok := c.chartmgrs[k]; !ok {
+		return apierrors.NewNotFound(crv1alpha1.SchemeGroupVersion.WithResource(crv1alpha1.ChartMgrResourcePlural).GroupResource(), chartmgr.ObjectMeta.Name)
+	}
+	updated := chartmgr.DeepCopy()
+	updated.ObjectMeta.ResourceVersion = c.nextVersion()
+	c.chartmgrs[k] = updated
+	return nil
+}
+
+// ListChartValuesProfiles returns all values profiles.
+func (c *Client) ListChartValuesProfiles() ([]crv1alpha1.ChartValuesProfile, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	profiles := make([]crv1alpha1.ChartValuesProfile, len(c.profiles))
+	for i := range c.profiles {
+		c.profiles[i].DeepCopyInto(&profiles[i])
+	}
+	return profiles, nil
+}
+
+// GetChartRepository returns the chart repository with the given name.
+func (c *Client) GetChartRepository(name string) (*crv1alpha1.ChartRepository, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	repository, ok := c.repositories[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(crv1alpha1.SchemeGroupVersion.WithResource(crv1alpha1.ChartRepositoryResourcePlural).GroupResource(), name)
+	}
+	return repository.DeepCopy(), nil
+}
+
+// UpdateChartRepository updates the chart repository.
+func (c *Client) UpdateChartRepository(repository *crv1alpha1.ChartRepository) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.repositories[repository.ObjectMeta.Name]; !ok {
+		return apierrors.NewNotFound(crv1alpha1.SchemeGroupVersion.WithResource(crv1alpha1.ChartRepositoryResourcePlural).GroupResource(), repository.ObjectMeta.Name)
+	}
+	updated := repository.DeepCopy()
+	updated.ObjectMeta.ResourceVersion = c.nextVersion()
+	c.repositories[repository.ObjectMeta.Name] = updated
+	return nil
+}
+
+// ListWatch returns a list watch of the resource. The watch never delivers
+// events, since the fake has no way to create or delete objects after it was
+// created.
+func (c *Client) ListWatch(resource string) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return c.list(resource)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return watch.NewFake(), nil
+		},
+	}
+}
+
+func (c *Client) list(resource string) (runtime.Object, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch resource {
+	case crv1alpha1.ChartMgrResourcePlural:
+		list := &crv1alpha1.ChartManagerList{}
+		for _, chartmgr := range c.chartmgrs {
+			list.Items = append(list.Items, *chartmgr.DeepCopy())
+		}
+		return list, nil
+	case crv1alpha1.ChartRepositoryResourcePlural:
+		list := &crv1alpha1.ChartRepositoryList{}
+		for _, repository := range c.repositories {
+			list.Items = append(list.Items, *repository.DeepCopy())
+		}
+		return list, nil
+	case crv1alpha1.ChartValuesProfileResourcePlural:
+		list := &crv1alpha1.ChartValuesProfileList{}
+		for i := range c.profiles {
+			list.Items = append(list.Items, *c.profiles[i].DeepCopy())
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("unsupported resource %s", resource)
+}
+
+func (c *Client) nextVersion() string {
+	c.version++
+	return strconv.Itoa(c.version)
+}
+
+func key(meta metav1.ObjectMeta) string {
+	return meta.Namespace + "/" + meta.Name
+}
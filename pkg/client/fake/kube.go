@@ -0,0 +1,329 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	crdAPIPath  = "/apis/apiextensions.k8s.io/v1beta1/"
+	crdResource = "customresourcedefinitions"
+)
+
+// KubeAPI serves ConfigMaps, Secrets, CustomResourceDefinitions and the
+// server version over HTTP like the Kubernetes API server, so that a real
+// Kubernetes client, including its REST and discovery clients, can be used
+// in tests. ConfigMaps and Secrets can be created, updated, deleted and
+// listed by label, CustomResourceDefinitions can be created, updated and
+// deleted, other objects set with Set can only be read.
+type KubeAPI struct {
+	*httptest.Server
+	// Clientset is a Kubernetes client of the server
+	Clientset *kubernetes.Clientset
+	// APIExtensionsClientset is an API extensions client of the server
+	APIExtensionsClientset *apiextensionsclientset.Clientset
+	// Version is the server version
+	Version version.Info
+
+	mu       sync.Mutex
+	objects  map[string]interface{}
+	requests map[string]int
+}
+
+// NewKubeAPI starts serving the objects, which may be ConfigMaps, Secrets
+// and CustomResourceDefinitions. Close stops the server.
+func NewKubeAPI(objects ...runtime.Object) (*KubeAPI, error) {
+	a := &KubeAPI{
+		Version:  version.Info{Major: "1", Minor: "9", GitVersion: "v1.9.0"},
+		objects:  map[string]interface{}{},
+		requests: map[string]int{},
+	}
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *v1.ConfigMap:
+			a.Set("configmaps", o.ObjectMeta.Namespace, o.ObjectMeta.Name, o)
+		case *v1.Secret:
+			a.Set("secrets", o.ObjectMeta.Namespace, o.ObjectMeta.Name, o)
+		case *apiextensionsv1beta1.CustomResourceDefinition:
+			a.Set(crdResource, "", o.ObjectMeta.Name, o)
+		default:
+			panic(fmt.Sprintf("unsupported object %T", obj))
+		}
+	}
+
+	a.Server = httptest.NewServer(http.HandlerFunc(a.serve))
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: a.URL, QPS: 1000, Burst: 1000})
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	a.Clientset = clientset
+	apiextensionsclient, err := apiextensionsclientset.NewForConfig(&rest.Config{Host: a.URL, QPS: 1000, Burst: 1000})
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	a.APIExtensionsClientset = apiextensionsclient
+	return a, nil
+}
+
+// Set serves obj, which is encoded to JSON, as the object of the resource
+// with the namespace and name. objects the vendored API types can't
+// represent, e.g. ConfigMaps with binaryData, may be set as maps.
+func (a *KubeAPI) Set(resource string, namespace string, name string, obj interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.objects[objectPath(resource, namespace, name)] = obj
+}
+
+// Delete stops serving the object of the resource with the namespace and name
+func (a *KubeAPI) Delete(resource string, namespace string, name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.objects, objectPath(resource, namespace, name))
+}
+
+// Requests returns the number of requests for the path, e.g. "/version" or
+// "/api/v1/namespaces/default/configmaps/name"
+func (a *KubeAPI) Requests(path string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.requests[path]
+}
+
+// Objects returns the objects of the resource in the namespace, or in all
+// namespaces if it is empty
+func (a *KubeAPI) Objects(resource string, namespace string) []interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.list(resource, namespace, labels.Everything())
+}
+
+func (a *KubeAPI) serve(w http.ResponseWriter, req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests[req.URL.Path]++
+
+	switch req.URL.Path {
+	case "/version":
+		writeJSON(w, http.StatusOK, a.Version)
+		return
+	case "/api":
+		writeJSON(w, http.StatusOK, &metav1.APIVersions{
+			TypeMeta: metav1.TypeMeta{Kind: "APIVersions"},
+			Versions: []string{"v1"},
+		})
+		return
+	case "/apis":
+		writeJSON(w, http.StatusOK, &metav1.APIGroupList{
+			TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"},
+		})
+		return
+	}
+
+	// /api/v1/[namespaces/namespace/]resource[/name] or
+	// /apis/apiextensions.k8s.io/v1beta1/customresourcedefinitions[/name]
+	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/api/v1/"), crdAPIPath), "/")
+	namespace := ""
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		namespace, parts = parts[1], parts[2:]
+	}
+	resource, name := parts[0], ""
+	if len(parts) > 1 {
+		name = parts[1]
+	}
+
+	switch {
+	case req.Method == http.MethodGet && name == "":
+		selector, err := labels.Parse(req.URL.Query().Get("labelSelector"))
+		if err != nil {
+			writeStatus(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		writeJSON(w, http.StatusOK, newList(resource, a.list(resource, namespace, selector)))
+	case req.Method == http.MethodGet:
+		obj, ok := a.objects[req.URL.Path]
+		if !ok {
+			writeStatus(w, apierrors.NewNotFound(schema.GroupResource{Resource: resource}, name))
+			return
+		}
+		writeJSON(w, http.StatusOK, withKind(obj))
+	case req.Method == http.MethodPost && name == "":
+		obj, meta, err := decodeObject(resource, req)
+		if err != nil {
+			writeStatus(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		path := objectPath(resource, namespace, meta.Name)
+		if _, ok := a.objects[path]; ok {
+			writeStatus(w, apierrors.NewAlreadyExists(schema.GroupResource{Resource: resource}, meta.Name))
+			return
+		}
+		if crd, ok := obj.(*apiextensionsv1beta1.CustomResourceDefinition); ok {
+			// CRDs are established right away
+			crd.Status.Conditions = []apiextensionsv1beta1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1beta1.Established, Status: apiextensionsv1beta1.ConditionTrue},
+			}
+		}
+		a.objects[path] = obj
+		writeJSON(w, http.StatusCreated, withKind(obj))
+	case req.Method == http.MethodPut && name != "":
+		obj, _, err := decodeObject(resource, req)
+		if err != nil {
+			writeStatus(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		if _, ok := a.objects[req.URL.Path]; !ok {
+			writeStatus(w, apierrors.NewNotFound(schema.GroupResource{Resource: resource}, name))
+			return
+		}
+		a.objects[req.URL.Path] = obj
+		writeJSON(w, http.StatusOK, withKind(obj))
+	case req.Method == http.MethodDelete && name != "":
+		if _, ok := a.objects[req.URL.Path]; !ok {
+			writeStatus(w, apierrors.NewNotFound(schema.GroupResource{Resource: resource}, name))
+			return
+		}
+		delete(a.objects, req.URL.Path)
+		writeJSON(w, http.StatusOK, &metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusSuccess,
+		})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// list returns the ConfigMaps or Secrets matching the selector. it must be
+// called with the lock held.
+func (a *KubeAPI) list(resource string, namespace string, selector labels.Selector) []interface{} {
+	prefix := "/api/v1/namespaces/"
+	if namespace != "" {
+		prefix += namespace + "/"
+	}
+	paths := []string{}
+	for path := range a.objects {
+		if strings.HasPrefix(path, prefix) && strings.Contains(path, "/"+resource+"/") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	objects := []interface{}{}
+	for _, path := range paths {
+		var meta *metav1.ObjectMeta
+		switch o := a.objects[path].(type) {
+		case *v1.ConfigMap:
+			meta = &o.ObjectMeta
+		case *v1.Secret:
+			meta = &o.ObjectMeta
+		default:
+			continue
+		}
+		if selector.Matches(labels.Set(meta.Labels)) {
+			objects = append(objects, a.objects[path])
+		}
+	}
+	return objects
+}
+
+func newList(resource string, objects []interface{}) interface{} {
+	switch resource {
+	case "configmaps":
+		list := &v1.ConfigMapList{TypeMeta: metav1.TypeMeta{Kind: "ConfigMapList", APIVersion: "v1"}}
+		for _, obj := range objects {
+			list.Items = append(list.Items, *obj.(*v1.ConfigMap))
+		}
+		return list
+	case "secrets":
+		list := &v1.SecretList{TypeMeta: metav1.TypeMeta{Kind: "SecretList", APIVersion: "v1"}}
+		for _, obj := range objects {
+			list.Items = append(list.Items, *obj.(*v1.Secret))
+		}
+		return list
+	}
+	return &metav1.List{TypeMeta: metav1.TypeMeta{Kind: "List", APIVersion: "v1"}}
+}
+
+// decodeObject decodes the ConfigMap, Secret or CustomResourceDefinition in
+// the request body
+func decodeObject(resource string, req *http.Request) (interface{}, *metav1.ObjectMeta, error) {
+	switch resource {
+	case "configmaps":
+		obj := &v1.ConfigMap{}
+		err := json.NewDecoder(req.Body).Decode(obj)
+		return obj, &obj.ObjectMeta, err
+	case "secrets":
+		obj := &v1.Secret{}
+		err := json.NewDecoder(req.Body).Decode(obj)
+		return obj, &obj.ObjectMeta, err
+	case crdResource:
+		obj := &apiextensionsv1beta1.CustomResourceDefinition{}
+		err := json.NewDecoder(req.Body).Decode(obj)
+		return obj, &obj.ObjectMeta, err
+	}
+	return nil, nil, fmt.Errorf("unsupported resource %s", resource)
+}
+
+func writeStatus(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.ErrStatus
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+	writeJSON(w, int(status.Code), status)
+}
+
+// withKind sets the kind and API version of API objects, which the client
+// requires to decode them
+func withKind(obj interface{}) interface{} {
+	switch o := obj.(type) {
+	case *v1.ConfigMap:
+		c := o.DeepCopy()
+		c.Kind, c.APIVersion = "ConfigMap", "v1"
+		return c
+	case *v1.Secret:
+		s := o.DeepCopy()
+		s.Kind, s.APIVersion = "Secret", "v1"
+		return s
+	case *apiextensionsv1beta1.CustomResourceDefinition:
+		crd := o.DeepCopy()
+		crd.Kind, crd.APIVersion = "CustomResourceDefinition", apiextensionsv1beta1.SchemeGroupVersion.String()
+		return crd
+	case map[string]interface{}:
+		m := map[string]interface{}{"apiVersion": "v1"}
+		for k, v := range o {
+			m[k] = v
+		}
+		return m
+	}
+	return obj
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v) // nolint: errcheck
+}
+
+func objectPath(resource string, namespace string, name string) string {
+	if resource == crdResource {
+		return crdAPIPath + crdResource + "/" + name
+	}
+	return fmt.Sprintf("/api/v1/namespaces/%s/%s/%s", namespace, resource, name)
+}
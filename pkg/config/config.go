@@ -6,10 +6,37 @@ import (
 
 // Config represents the application's configuration file.
 type Config struct {
-	TillerHost        string
-	TillerNamespace   string `default:"kube-system"`
-	ReleaseTimeoutSec int64  `default:"300"`
-	DebugMode         bool   `envconfig:"DEBUG"`
+	ReleaseBackend               string `default:"tiller"`
+	TillerHost                   string
+	TillerNamespace              string `default:"kube-system"`
+	TillerTLSCAFile              string
+	TillerTLSCertFile            string
+	TillerTLSKeyFile             string
+	TillerTLSServerName          string
+	TillerTLSSecret              string
+	TillerHealthCheckIntervalSec int64 `default:"30"`
+	TillerIdleTimeoutSec         int64 `default:"3600"`
+	AllowedTillers               []string
+	ReleaseNaming                string `default:"uid"`
+	ReleaseNameMigration         string `default:"adopt"`
+	ReleaseTimeoutSec            int64  `default:"300"`
+	DebugMode                    bool   `envconfig:"DEBUG"`
+	Variables                    map[string]string
+	VariablesConfigMap           string
+	VariablesCacheTTLSec         int64    `default:"60"`
+	OrphanSweepMode              string   `default:"off"`
+	OrphanSweepIntervalSec       int64    `default:"3600"`
+	OrphanGracePeriodSec         int64    `default:"86400"`
+	SourcePollIntervalSec        int64    `default:"300"`
+	ChartCacheMaxSizeMB          int64    `default:"512"`
+	ChartCacheMaxAgeSec          int64    `default:"604800"`
+	RepositoryIndexTTLSec        int64    `default:"300"`
+	DefaultRepositories          []string `default:"stable=https://charts.helm.sh/stable"`
+	HTTPProxy                    string
+	HTTPSProxy                   string
+	NoProxy                      string
+	CABundleFiles                []string
+	ChartPathRoot                string
 }
 
 // New returns the application configuration specified by the config file.
@@ -6,10 +6,8 @@ var (
 )
 
 const (
-	// HelmStableRepo is the name of the stable helm repo
-	HelmStableRepo = "stable"
-	// HelmStableRepoURL is the URL of the stable helm repo
-	HelmStableRepoURL = "https://kubernetes-charts.storage.googleapis.com"
+	// DefaultRepositoryRefreshIntervalSec is the default interval between chart repository index refreshes
+	DefaultRepositoryRefreshIntervalSec = 300
 )
 
 const (
@@ -1,6 +1,8 @@
 package constants
 
 import (
+	"fmt"
+
 	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/utilities"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 )
@@ -15,6 +17,23 @@ const (
 	ValidateReleaseNamePattern = "^[a-z0-9\\-]+?"
 )
 
+const (
+	// ValidateOCITagPattern is the regex pattern used to validate OCI tags
+	ValidateOCITagPattern = "^[A-Za-z0-9_][A-Za-z0-9_.\\-]*$"
+	// ValidateOCIDigestPattern is the regex pattern used to validate OCI digests
+	ValidateOCIDigestPattern = "^[a-z0-9]+([+._\\-][a-z0-9]+)*:[a-zA-Z0-9=_\\-]+$"
+)
+
+const (
+	// ValidateTillerHostPattern is the regex pattern used to validate Tiller hosts
+	ValidateTillerHostPattern = "^[A-Za-z0-9.\\-]+:[0-9]{1,5}$"
+)
+
+const (
+	// ValidateChartDigestPattern is the regex pattern used to validate chart digests
+	ValidateChartDigestPattern = "^(sha256:)?[a-f0-9]{64}$"
+)
+
 // ChartMgrValidationRules returns the CRD validation
 func ChartMgrValidationRules() *apiextensionsv1beta1.CustomResourceValidation {
 	return &apiextensionsv1beta1.CustomResourceValidation{
@@ -29,6 +48,34 @@ func ChartMgrValidationRules() *apiextensionsv1beta1.CustomResourceValidation {
 	}
 }
 
+// ChartValuesProfileValidationRules returns the values profile CRD validation
+func ChartValuesProfileValidationRules() *apiextensionsv1beta1.CustomResourceValidation {
+	return &apiextensionsv1beta1.CustomResourceValidation{
+		OpenAPIV3Schema: &apiextensionsv1beta1.JSONSchemaProps{
+			Required: []string{
+				"spec",
+			},
+			Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+				"spec": profileSpecValidationRules(),
+			},
+		},
+	}
+}
+
+// ChartRepositoryValidationRules returns the chart repository CRD validation
+func ChartRepositoryValidationRules() *apiextensionsv1beta1.CustomResourceValidation {
+	return &apiextensionsv1beta1.CustomResourceValidation{
+		OpenAPIV3Schema: &apiextensionsv1beta1.JSONSchemaProps{
+			Required: []string{
+				"spec",
+			},
+			Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+				"spec": chartRepositorySpecValidationRules(),
+			},
+		},
+	}
+}
+
 func specValidationRules() apiextensionsv1beta1.JSONSchemaProps {
 	return apiextensionsv1beta1.JSONSchemaProps{
 		Required: []string{
@@ -39,6 +86,140 @@ func specValidationRules() apiextensionsv1beta1.JSONSchemaProps {
 			"values":  valuesValidationRules(),
 			"release": releaseValidationRules(),
 			"options": optionsValidationRules(),
+			"tiller":  tillerValidationRules(),
+		},
+	}
+}
+
+func chartRepositorySpecValidationRules() apiextensionsv1beta1.JSONSchemaProps {
+	return apiextensionsv1beta1.JSONSchemaProps{
+		Required: []string{
+			"url",
+		},
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+			"url": {
+				Type:      "string",
+				Pattern:   ValidateChartRepoURLPattern,
+				MaxLength: utilities.I64ToPI64(2083),
+			},
+			"secretRef": {
+				Required: []string{
+					"name",
+					"namespace",
+				},
+				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+					"name": {
+						Type:      "string",
+						MinLength: utilities.I64ToPI64(1),
+						MaxLength: utilities.I64ToPI64(253),
+					},
+					"namespace": {
+						Type:      "string",
+						MinLength: utilities.I64ToPI64(1),
+						MaxLength: utilities.I64ToPI64(63),
+					},
+				},
+			},
+			"refreshIntervalSec": {
+				Type:    "integer",
+				Minimum: utilities.F64ToPF64(1),
+			},
+			"tls": {
+				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+					"insecureSkipVerify": {
+						Type: "boolean",
+					},
+					"serverName": {
+						Type: "string",
+					},
+				},
+			},
+			"proxy": {
+				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+					"url": {
+						Type:      "string",
+						MaxLength: utilities.I64ToPI64(2083),
+					},
+					"noProxy": {
+						Type: "array",
+						Items: &apiextensionsv1beta1.JSONSchemaPropsOrArray{
+							Schema: &apiextensionsv1beta1.JSONSchemaProps{
+								Type:      "string",
+								MinLength: utilities.I64ToPI64(1),
+							},
+						},
+					},
+				},
+			},
+			"verify": {
+				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+					"mode": verifyModeValidationRules(),
+					"keyringSecretRef": {
+						Required: []string{
+							"name",
+							"namespace",
+							"key",
+						},
+						Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+							"name": {
+								Type:      "string",
+								MinLength: utilities.I64ToPI64(1),
+								MaxLength: utilities.I64ToPI64(253),
+							},
+							"namespace": {
+								Type:      "string",
+								MinLength: utilities.I64ToPI64(1),
+								MaxLength: utilities.I64ToPI64(63),
+							},
+							"key": {
+								Type:      "string",
+								MinLength: utilities.I64ToPI64(1),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func verifyModeValidationRules() apiextensionsv1beta1.JSONSchemaProps {
+	return apiextensionsv1beta1.JSONSchemaProps{
+		Type: "string",
+		Enum: enum("none", "ifPossible", "always"),
+	}
+}
+
+func profileSpecValidationRules() apiextensionsv1beta1.JSONSchemaProps {
+	return apiextensionsv1beta1.JSONSchemaProps{
+		Required: []string{
+			"values",
+		},
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+			"priority": {
+				Type: "integer",
+			},
+			"selector": profileSelectorValidationRules(),
+			"values":   valuesValidationRules(),
+		},
+	}
+}
+
+func profileSelectorValidationRules() apiextensionsv1beta1.JSONSchemaProps {
+	return apiextensionsv1beta1.JSONSchemaProps{
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+			"charts": {
+				Type: "array",
+				Items: &apiextensionsv1beta1.JSONSchemaPropsOrArray{
+					Schema: &apiextensionsv1beta1.JSONSchemaProps{
+						Type:      "string",
+						MinLength: utilities.I64ToPI64(1),
+					},
+				},
+			},
+			"chartManagerSelector": {
+				Type: "object",
+			},
 		},
 	}
 }
@@ -54,7 +235,37 @@ func chartValidationRules() apiextensionsv1beta1.JSONSchemaProps {
 				MinLength: utilities.I64ToPI64(1),
 				MaxLength: utilities.I64ToPI64(253),
 			},
-			"repository": repositoryValidationRules(),
+			"repository":   repositoryValidationRules(),
+			"git":          gitValidationRules(),
+			"oci":          ociValidationRules(),
+			"configMapRef": keyRefValidationRules(),
+			"digest": {
+				Type:    "string",
+				Pattern: ValidateChartDigestPattern,
+			},
+			"verify": {
+				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+					"mode":             verifyModeValidationRules(),
+					"keyringSecretRef": keyRefValidationRules(),
+				},
+			},
+			"path": {
+				Type:      "string",
+				MinLength: utilities.I64ToPI64(1),
+				MaxLength: utilities.I64ToPI64(4096),
+			},
+			"repositoryRef": {
+				Required: []string{
+					"name",
+				},
+				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+					"name": {
+						Type:      "string",
+						MinLength: utilities.I64ToPI64(1),
+						MaxLength: utilities.I64ToPI64(253),
+					},
+				},
+			},
 		},
 	}
 }
@@ -66,7 +277,6 @@ func valuesValidationRules() apiextensionsv1beta1.JSONSchemaProps {
 			Schema: &apiextensionsv1beta1.JSONSchemaProps{
 				Required: []string{
 					"name",
-					"value",
 				},
 				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
 					"name": {
@@ -77,12 +287,49 @@ func valuesValidationRules() apiextensionsv1beta1.JSONSchemaProps {
 						Type:      "string",
 						MinLength: utilities.I64ToPI64(1),
 					},
+					"type": {
+						Type: "string",
+						Enum: enum("auto", "string", "json", "file"),
+					},
+					"valueFrom": valueFromValidationRules(),
+					"sensitive": {
+						Type: "boolean",
+					},
 				},
 			},
 		},
 	}
 }
 
+func valueFromValidationRules() apiextensionsv1beta1.JSONSchemaProps {
+	return apiextensionsv1beta1.JSONSchemaProps{
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+			"configMapKeyRef": keyRefValidationRules(),
+			"secretKeyRef":    keyRefValidationRules(),
+		},
+	}
+}
+
+func keyRefValidationRules() apiextensionsv1beta1.JSONSchemaProps {
+	return apiextensionsv1beta1.JSONSchemaProps{
+		Required: []string{
+			"name",
+			"key",
+		},
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+			"name": {
+				Type:      "string",
+				MinLength: utilities.I64ToPI64(1),
+				MaxLength: utilities.I64ToPI64(253),
+			},
+			"key": {
+				Type:      "string",
+				MinLength: utilities.I64ToPI64(1),
+			},
+		},
+	}
+}
+
 func releaseValidationRules() apiextensionsv1beta1.JSONSchemaProps {
 	return apiextensionsv1beta1.JSONSchemaProps{
 		Required: []string{
@@ -97,12 +344,30 @@ func releaseValidationRules() apiextensionsv1beta1.JSONSchemaProps {
 	}
 }
 
+func tillerValidationRules() apiextensionsv1beta1.JSONSchemaProps {
+	return apiextensionsv1beta1.JSONSchemaProps{
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+			"namespace": {
+				Type:      "string",
+				MinLength: utilities.I64ToPI64(1),
+			},
+			"host": {
+				Type:    "string",
+				Pattern: ValidateTillerHostPattern,
+			},
+		},
+	}
+}
+
 func optionsValidationRules() apiextensionsv1beta1.JSONSchemaProps {
 	return apiextensionsv1beta1.JSONSchemaProps{
 		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
 			"createOnly": {
 				Type: "boolean",
 			},
+			"strictValues": {
+				Type: "boolean",
+			},
 		},
 	}
 }
@@ -124,6 +389,103 @@ func repositoryValidationRules() apiextensionsv1beta1.JSONSchemaProps {
 				Pattern:   ValidateChartRepoURLPattern,
 				MaxLength: utilities.I64ToPI64(2083),
 			},
+			"secretRef": {
+				Required: []string{
+					"name",
+				},
+				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+					"name": {
+						Type:      "string",
+						MinLength: utilities.I64ToPI64(1),
+						MaxLength: utilities.I64ToPI64(253),
+					},
+				},
+			},
 		},
 	}
 }
+
+func gitValidationRules() apiextensionsv1beta1.JSONSchemaProps {
+	return apiextensionsv1beta1.JSONSchemaProps{
+		Required: []string{
+			"url",
+		},
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+			"url": {
+				Type:      "string",
+				MinLength: utilities.I64ToPI64(1),
+				MaxLength: utilities.I64ToPI64(2083),
+			},
+			"ref": {
+				Type:      "string",
+				MaxLength: utilities.I64ToPI64(255),
+			},
+			"path": {
+				Type:      "string",
+				MaxLength: utilities.I64ToPI64(4096),
+			},
+			"insecureSkipHostKeyVerification": {
+				Type: "boolean",
+			},
+			"secretRef": {
+				Required: []string{
+					"name",
+				},
+				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+					"name": {
+						Type:      "string",
+						MinLength: utilities.I64ToPI64(1),
+						MaxLength: utilities.I64ToPI64(253),
+					},
+				},
+			},
+		},
+	}
+}
+
+func ociValidationRules() apiextensionsv1beta1.JSONSchemaProps {
+	return apiextensionsv1beta1.JSONSchemaProps{
+		Required: []string{
+			"reference",
+		},
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+			"reference": {
+				Type:      "string",
+				MinLength: utilities.I64ToPI64(1),
+				MaxLength: utilities.I64ToPI64(255),
+			},
+			"tag": {
+				Type:      "string",
+				Pattern:   ValidateOCITagPattern,
+				MaxLength: utilities.I64ToPI64(128),
+			},
+			"digest": {
+				Type:    "string",
+				Pattern: ValidateOCIDigestPattern,
+			},
+			"plainHTTP": {
+				Type: "boolean",
+			},
+			"secretRef": {
+				Required: []string{
+					"name",
+				},
+				Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+					"name": {
+						Type:      "string",
+						MinLength: utilities.I64ToPI64(1),
+						MaxLength: utilities.I64ToPI64(253),
+					},
+				},
+			},
+		},
+	}
+}
+
+func enum(vals ...string) []apiextensionsv1beta1.JSON {
+	e := []apiextensionsv1beta1.JSON{}
+	for _, v := range vals {
+		e = append(e, apiextensionsv1beta1.JSON{Raw: []byte(fmt.Sprintf("%q", v))})
+	}
+	return e
+}
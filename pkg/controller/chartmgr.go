@@ -7,32 +7,66 @@ import (
 )
 
 // CreateOrUpdateChartMgr creates a Chart Manager
-func CreateOrUpdateChartMgr(chartmgr *crv1alpha1.ChartManager, client *lmhelm.Client) (*lmhelm.Release, error) {
-	rls := &lmhelm.Release{
-		Client:   client,
-		Chartmgr: chartmgr,
-	}
+func CreateOrUpdateChartMgr(chartmgr *crv1alpha1.ChartManager, profiles []crv1alpha1.ChartValuesProfile, repository *crv1alpha1.ChartRepository, client lmhelm.Interface) (*lmhelm.Release, error) {
+	rls := client.NewRelease(chartmgr)
+	rls.Profiles = profiles
+	rls.Repository = repository
+	rls = adoptLegacyRelease(chartmgr, rls)
 
-	err := removeMismatchedReleases(chartmgr, rls)
+	exists, err := rls.Exists()
 	if err != nil {
 		return rls, err
 	}
-
-	if rls.Exists() {
+	if exists {
 		log.Infof("Release %s found", rls.Name())
-		return rls, rls.Update()
+		err = rls.Update()
+	} else {
+		log.Infof("Release %s not found", rls.Name())
+		err = rls.Install()
+	}
+	if err != nil {
+		return rls, err
 	}
-	log.Infof("Release %s not found", rls.Name())
-	return rls, rls.Install()
+
+	// the stored release is only removed once the release is deployed under
+	// its new name, so a rename doesn't take the application down and a
+	// failed rename leaves the stored release in place
+	return rls, removeMismatchedReleases(chartmgr, rls)
 }
 
 // DeleteChartMgr deletes a Chart Manager
-func DeleteChartMgr(chartmgr *crv1alpha1.ChartManager, client *lmhelm.Client) (*lmhelm.Release, error) {
-	rls := &lmhelm.Release{
-		Client:   client,
-		Chartmgr: chartmgr,
+func DeleteChartMgr(chartmgr *crv1alpha1.ChartManager, client lmhelm.Interface) (*lmhelm.Release, error) {
+	rls := client.NewRelease(chartmgr)
+	// delete the release the chart manager manages, which may have been
+	// adopted or named differently before
+	if resourceReleaseName(chartmgr) != "" {
+		rls = rls.WithName(resourceReleaseName(chartmgr))
+	}
+	err := rls.Delete()
+	if err != nil {
+		return rls, err
+	}
+	err = client.RemoveDependencyLock(chartmgr)
+	if err != nil {
+		log.Warnf("Failed to remove requirements lock of %s/%s: %v", chartmgr.ObjectMeta.Namespace, chartmgr.ObjectMeta.Name, err)
+	}
+	return rls, nil
+}
+
+// adoptLegacyRelease returns the release the chart manager created with the
+// uid naming if the controller now uses another naming and is configured to
+// adopt such releases rather than rename them
+func adoptLegacyRelease(chartmgr *crv1alpha1.ChartManager, rls *lmhelm.Release) *lmhelm.Release {
+	stored := resourceReleaseName(chartmgr)
+	if stored == "" || stored == rls.Name() || stored != lmhelm.LegacyReleaseName(chartmgr) {
+		return rls
+	}
+	if rls.Client.Config().ReleaseNameMigration == lmhelm.ReleaseNameMigrationRename {
+		log.Infof("Renaming release %s to %s", stored, rls.Name())
+		return rls
 	}
-	return rls, rls.Delete()
+	log.Infof("Adopting release %s instead of %s", stored, rls.Name())
+	return rls.WithName(stored)
 }
 
 func removeMismatchedReleases(chartmgr *crv1alpha1.ChartManager, rls *lmhelm.Release) error {
@@ -42,7 +76,7 @@ func removeMismatchedReleases(chartmgr *crv1alpha1.ChartManager, rls *lmhelm.Rel
 	// with the chartmgr.
 	if resourceReleaseName(chartmgr) != "" && resourceReleaseName(chartmgr) != rls.Name() {
 		log.Warnf("Calculated release name %q does not match stored release %q", rls.Name(), resourceReleaseName(chartmgr))
-		return rls.Delete()
+		return rls.WithName(resourceReleaseName(chartmgr)).Delete()
 	}
 	return nil
 }
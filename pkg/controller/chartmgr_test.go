@@ -0,0 +1,537 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	clientfake "github.com/logicmonitor/k8s-chart-manager-controller/pkg/client/fake"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	lmhelm "github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const testNamespace = "default"
+
+// testEnv is a controller backed by an in-memory release backend, an
+// in-memory chart manager client, a fake kubernetes API server and an HTTP
+// chart repository
+type testEnv struct {
+	controller *Controller
+	client     *clientfake.Client
+	kubeAPI    *clientfake.KubeAPI
+	backend    *fake.Backend
+	repository *fake.ChartRepository
+	home       string
+}
+
+func newTestEnv(t *testing.T, backend *fake.Backend, objects ...runtime.Object) *testEnv {
+	repository, err := fake.NewChartRepository(
+		fake.NewChart("app", "1.0.0", "replicas: 1\n"),
+		fake.NewChart("app", "1.1.0", "replicas: 1\n"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, err := ioutil.TempDir("", "chartmgr-home-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chartmgrconfig := &config.Config{
+		ReleaseTimeoutSec:     300,
+		ChartCacheMaxSizeMB:   512,
+		ChartCacheMaxAgeSec:   604800,
+		RepositoryIndexTTLSec: 300,
+	}
+	kubeAPI, err := clientfake.NewKubeAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	helmClient, err := lmhelm.NewClient(chartmgrconfig, kubeAPI.Clientset, backend, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := clientfake.NewClient(objects...)
+	return &testEnv{
+		controller: &Controller{
+			Interface:            client,
+			Config:               chartmgrconfig,
+			HelmClient:           helmClient,
+			ctx:                  context.Background(),
+			repositoryRefreshers: map[string]context.CancelFunc{},
+			sourcePollers:        map[string]context.CancelFunc{},
+			retries:              map[string]func(){},
+		},
+		client:     client,
+		kubeAPI:    kubeAPI,
+		backend:    backend,
+		repository: repository,
+		home:       home,
+	}
+}
+
+func (e *testEnv) close() {
+	e.repository.Close()
+	e.kubeAPI.Close()
+	os.RemoveAll(e.home) // nolint: errcheck
+}
+
+// chartmgr returns a chart manager installing the app chart as the release
+func (e *testEnv) chartmgr(release string, version string) *crv1alpha1.ChartManager {
+	return &crv1alpha1.ChartManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: testNamespace,
+			UID:       "1234",
+		},
+		Spec: crv1alpha1.ChartMgrSpec{
+			Chart: &crv1alpha1.ChartMgrChart{
+				Name:    "app",
+				Version: version,
+				Repository: &crv1alpha1.ChartMgrChartRepository{
+					Name: "test",
+					URL:  e.repository.URL,
+				},
+			},
+			Release: &crv1alpha1.ChartMgrRelease{Name: release},
+		},
+	}
+}
+
+func revisionStatuses(revisions []*rspb.Release) []rspb.Status_Code {
+	statuses := []rspb.Status_Code{}
+	for _, rls := range revisions {
+		statuses = append(statuses, rls.GetInfo().GetStatus().GetCode())
+	}
+	return statuses
+}
+
+func TestCreateOrUpdateChartMgr(t *testing.T) {
+	tests := []struct {
+		name       string
+		releases   []*rspb.Release
+		fail       string
+		createOnly bool
+		wantErr    bool
+		wantState  crv1alpha1.ChartMgrState
+		wantCalls  []string
+		wantStatus []rspb.Status_Code
+	}{
+		{
+			name:       "installs a missing release",
+			wantState:  crv1alpha1.ChartMgrStateDeployed,
+			wantCalls:  []string{"get app", "install app"},
+			wantStatus: []rspb.Status_Code{rspb.Status_DEPLOYED},
+		},
+		{
+			name:       "upgrades an existing release",
+			releases:   []*rspb.Release{fake.NewRelease("app", testNamespace, rspb.Status_DEPLOYED)},
+			wantState:  crv1alpha1.ChartMgrStateDeployed,
+			wantCalls:  []string{"get app", "upgrade app"},
+			wantStatus: []rspb.Status_Code{rspb.Status_SUPERSEDED, rspb.Status_DEPLOYED},
+		},
+		{
+			name:       "upgrades a failed release",
+			releases:   []*rspb.Release{fake.NewRelease("app", testNamespace, rspb.Status_FAILED)},
+			wantState:  crv1alpha1.ChartMgrStateDeployed,
+			wantCalls:  []string{"get app", "upgrade app"},
+			wantStatus: []rspb.Status_Code{rspb.Status_FAILED, rspb.Status_DEPLOYED},
+		},
+		{
+			name:       "reinstalls a release deleted without purging",
+			releases:   []*rspb.Release{fake.NewRelease("app", testNamespace, rspb.Status_DELETED)},
+			wantState:  crv1alpha1.ChartMgrStateDeployed,
+			wantCalls:  []string{"get app", "install app"},
+			wantStatus: []rspb.Status_Code{rspb.Status_DELETED, rspb.Status_DEPLOYED},
+		},
+		{
+			name:       "reports a failed install",
+			fail:       fake.OpInstall,
+			wantErr:    true,
+			wantState:  crv1alpha1.ChartMgrStateFailed,
+			wantCalls:  []string{"get app", "install app"},
+			wantStatus: []rspb.Status_Code{rspb.Status_FAILED},
+		},
+		{
+			name:       "reports a failed upgrade",
+			releases:   []*rspb.Release{fake.NewRelease("app", testNamespace, rspb.Status_DEPLOYED)},
+			fail:       fake.OpUpgrade,
+			wantErr:    true,
+			wantState:  crv1alpha1.ChartMgrStateFailed,
+			wantCalls:  []string{"get app", "upgrade app"},
+			wantStatus: []rspb.Status_Code{rspb.Status_SUPERSEDED, rspb.Status_FAILED},
+		},
+		{
+			name:       "doesn't install when the lookup fails",
+			fail:       fake.OpGet,
+			wantErr:    true,
+			wantState:  crv1alpha1.ChartMgrStateUnknown,
+			wantCalls:  []string{"get app"},
+			wantStatus: []rspb.Status_Code{},
+		},
+		{
+			name:       "doesn't upgrade in createOnly mode",
+			releases:   []*rspb.Release{fake.NewRelease("app", testNamespace, rspb.Status_DEPLOYED)},
+			createOnly: true,
+			wantState:  crv1alpha1.ChartMgrStateDeployed,
+			wantCalls:  []string{"get app"},
+			wantStatus: []rspb.Status_Code{rspb.Status_DEPLOYED},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := fake.NewBackend(tt.releases...)
+			if tt.fail != "" {
+				backend.Fail(tt.fail, errors.New("injected failure"))
+			}
+			env := newTestEnv(t, backend)
+			defer env.close()
+
+			chartmgr := env.chartmgr("app", "1.1.0")
+			if tt.createOnly {
+				chartmgr.Spec.Options = &crv1alpha1.ChartMgrOptions{CreateOnly: true}
+			}
+			rls, err := CreateOrUpdateChartMgr(chartmgr, nil, nil, env.controller.HelmClient)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateOrUpdateChartMgr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if rls.Status() != tt.wantState {
+				t.Errorf("release state = %s, want %s", rls.Status(), tt.wantState)
+			}
+			if calls := backend.Calls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("backend calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if statuses := revisionStatuses(backend.Revisions("app")); !reflect.DeepEqual(statuses, tt.wantStatus) {
+				t.Errorf("revision statuses = %v, want %v", statuses, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateChartMgrInstallsRequestedVersion(t *testing.T) {
+	backend := fake.NewBackend()
+	env := newTestEnv(t, backend)
+	defer env.close()
+
+	_, err := CreateOrUpdateChartMgr(env.chartmgr("app", "1.0.0"), nil, nil, env.controller.HelmClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateOrUpdateChartMgr(env.chartmgr("app", "1.1.0"), nil, nil, env.controller.HelmClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := []string{}
+	for _, rls := range backend.Revisions("app") {
+		versions = append(versions, rls.GetChart().GetMetadata().GetVersion())
+	}
+	if want := []string{"1.0.0", "1.1.0"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("revision chart versions = %v, want %v", versions, want)
+	}
+}
+
+func TestRemoveMismatchedReleases(t *testing.T) {
+	tests := []struct {
+		name         string
+		storedName   string
+		releases     []*rspb.Release
+		createOnly   bool
+		wantCalls    []string
+		wantReleases map[string]int
+	}{
+		{
+			name:         "ignores a chart manager without a stored release",
+			releases:     []*rspb.Release{fake.NewRelease("old", testNamespace, rspb.Status_DEPLOYED)},
+			wantCalls:    []string{},
+			wantReleases: map[string]int{"old": 1},
+		},
+		{
+			name:         "ignores a matching stored release",
+			storedName:   "app",
+			releases:     []*rspb.Release{fake.NewRelease("app", testNamespace, rspb.Status_DEPLOYED)},
+			wantCalls:    []string{},
+			wantReleases: map[string]int{"app": 1},
+		},
+		{
+			name:         "deletes the stored release after a rename",
+			storedName:   "old",
+			releases:     []*rspb.Release{fake.NewRelease("old", testNamespace, rspb.Status_DEPLOYED)},
+			wantCalls:    []string{"get old", "delete old"},
+			wantReleases: map[string]int{"old": 0},
+		},
+		{
+			name:         "tolerates a stored release that is gone",
+			storedName:   "old",
+			wantCalls:    []string{"get old"},
+			wantReleases: map[string]int{"old": 0},
+		},
+		{
+			name:         "keeps the stored release in createOnly mode",
+			storedName:   "old",
+			releases:     []*rspb.Release{fake.NewRelease("old", testNamespace, rspb.Status_DEPLOYED)},
+			createOnly:   true,
+			wantCalls:    []string{},
+			wantReleases: map[string]int{"old": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := fake.NewBackend(tt.releases...)
+			env := newTestEnv(t, backend)
+			defer env.close()
+
+			chartmgr := env.chartmgr("app", "1.1.0")
+			chartmgr.Status.ReleaseName = tt.storedName
+			if tt.createOnly {
+				chartmgr.Spec.Options = &crv1alpha1.ChartMgrOptions{CreateOnly: true}
+			}
+			rls := env.controller.HelmClient.NewRelease(chartmgr)
+			err := removeMismatchedReleases(chartmgr, rls)
+			if err != nil {
+				t.Fatalf("removeMismatchedReleases() error = %v", err)
+			}
+			if calls := backend.Calls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("backend calls = %v, want %v", calls, tt.wantCalls)
+			}
+			for name, want := range tt.wantReleases {
+				if got := len(backend.Revisions(name)); got != want {
+					t.Errorf("release %s has %d revisions, want %d", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateChartMgrMigratesLegacyReleases(t *testing.T) {
+	legacy := "chartmgr-rls-1234"
+	tests := []struct {
+		name       string
+		naming     string
+		migration  string
+		storedName string
+		releases   []*rspb.Release
+		wantName   string
+		wantCalls  []string
+	}{
+		{
+			name:      "names a new release after the chart manager",
+			naming:    lmhelm.ReleaseNamingName,
+			migration: lmhelm.ReleaseNameMigrationAdopt,
+			wantName:  "chartmgr-rls-default-app",
+			wantCalls: []string{"get chartmgr-rls-default-app", "install chartmgr-rls-default-app"},
+		},
+		{
+			name:       "adopts a legacy release",
+			naming:     lmhelm.ReleaseNamingName,
+			migration:  lmhelm.ReleaseNameMigrationAdopt,
+			storedName: legacy,
+			releases:   []*rspb.Release{fake.NewRelease(legacy, testNamespace, rspb.Status_DEPLOYED)},
+			wantName:   legacy,
+			wantCalls:  []string{"get " + legacy, "upgrade " + legacy},
+		},
+		{
+			name:       "renames a legacy release",
+			naming:     lmhelm.ReleaseNamingHash,
+			migration:  lmhelm.ReleaseNameMigrationRename,
+			storedName: legacy,
+			releases:   []*rspb.Release{fake.NewRelease(legacy, testNamespace, rspb.Status_DEPLOYED)},
+			wantName:   "chartmgr-rls-a1989cdf73cb2113",
+			wantCalls: []string{
+				"get chartmgr-rls-a1989cdf73cb2113", "install chartmgr-rls-a1989cdf73cb2113",
+				"get " + legacy, "delete " + legacy,
+			},
+		},
+		{
+			name:       "keeps managing a legacy release with the uid naming",
+			naming:     lmhelm.ReleaseNamingUID,
+			migration:  lmhelm.ReleaseNameMigrationRename,
+			storedName: legacy,
+			releases:   []*rspb.Release{fake.NewRelease(legacy, testNamespace, rspb.Status_DEPLOYED)},
+			wantName:   legacy,
+			wantCalls:  []string{"get " + legacy, "upgrade " + legacy},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := fake.NewBackend(tt.releases...)
+			env := newTestEnv(t, backend)
+			defer env.close()
+			env.controller.Config.ReleaseNaming = tt.naming
+			env.controller.Config.ReleaseNameMigration = tt.migration
+
+			chartmgr := env.chartmgr("", "1.1.0")
+			chartmgr.Spec.Release = nil
+			chartmgr.Status.ReleaseName = tt.storedName
+			rls, err := CreateOrUpdateChartMgr(chartmgr, nil, nil, env.controller.HelmClient)
+			if err != nil {
+				t.Fatalf("CreateOrUpdateChartMgr() error = %v", err)
+			}
+			if rls.Name() != tt.wantName {
+				t.Errorf("release name = %q, want %q", rls.Name(), tt.wantName)
+			}
+			if calls := backend.Calls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("backend calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDeleteChartMgrDeletesStoredRelease(t *testing.T) {
+	legacy := "chartmgr-rls-1234"
+	backend := fake.NewBackend(fake.NewRelease(legacy, testNamespace, rspb.Status_DEPLOYED))
+	env := newTestEnv(t, backend)
+	defer env.close()
+	env.controller.Config.ReleaseNaming = lmhelm.ReleaseNamingName
+
+	chartmgr := env.chartmgr("", "1.1.0")
+	chartmgr.Spec.Release = nil
+	chartmgr.Status.ReleaseName = legacy
+	_, err := DeleteChartMgr(chartmgr, env.controller.HelmClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"get " + legacy, "delete " + legacy}; !reflect.DeepEqual(backend.Calls(), want) {
+		t.Errorf("backend calls = %v, want %v", backend.Calls(), want)
+	}
+}
+
+func TestUpdateChartMgrStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		releases    []*rspb.Release
+		fail        string
+		wantState   crv1alpha1.ChartMgrState
+		wantMessage string
+	}{
+		{
+			name:        "reports a deployed release",
+			wantState:   crv1alpha1.ChartMgrStateDeployed,
+			wantMessage: string(crv1alpha1.ChartMgrStateDeployed),
+		},
+		{
+			name:        "reports an upgraded release",
+			releases:    []*rspb.Release{fake.NewRelease("app", testNamespace, rspb.Status_DEPLOYED)},
+			wantState:   crv1alpha1.ChartMgrStateDeployed,
+			wantMessage: string(crv1alpha1.ChartMgrStateDeployed),
+		},
+		{
+			name:        "reports the error of a failed install",
+			fail:        fake.OpInstall,
+			wantState:   crv1alpha1.ChartMgrStateFailed,
+			wantMessage: "injected failure",
+		},
+		{
+			name:        "reports the error of a failed lookup",
+			fail:        fake.OpGet,
+			wantState:   crv1alpha1.ChartMgrStateUnknown,
+			wantMessage: "injected failure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := fake.NewBackend(tt.releases...)
+			if tt.fail != "" {
+				backend.Fail(tt.fail, errors.New("injected failure"))
+			}
+			env := newTestEnv(t, backend)
+			defer env.close()
+			chartmgr := env.chartmgr("app", "1.1.0")
+			env.client = clientfake.NewClient(chartmgr)
+			env.controller.Interface = env.client
+
+			env.controller.updateChartMgr(chartmgr)
+
+			updated, err := env.client.GetChartManager(testNamespace, "app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			status := updated.Status
+			if status.State != tt.wantState {
+				t.Errorf("status state = %s, want %s", status.State, tt.wantState)
+			}
+			if status.Message != tt.wantMessage {
+				t.Errorf("status message = %q, want %q", status.Message, tt.wantMessage)
+			}
+			if status.ReleaseName != "app" {
+				t.Errorf("status release name = %q, want %q", status.ReleaseName, "app")
+			}
+		})
+	}
+}
+
+func TestUpdateChartMgrKeepsStoredReleaseNameUntilRenamed(t *testing.T) {
+	legacy := "chartmgr-rls-1234"
+	renamed := "chartmgr-rls-a1989cdf73cb2113"
+	tests := []struct {
+		name         string
+		fail         string
+		wantName     string
+		wantReleases map[string]int
+	}{
+		{
+			name:         "records the new name after the rename",
+			wantName:     renamed,
+			wantReleases: map[string]int{legacy: 0, renamed: 1},
+		},
+		{
+			name:         "keeps the stored name if the install fails",
+			fail:         fake.OpInstall,
+			wantName:     legacy,
+			wantReleases: map[string]int{legacy: 1},
+		},
+		{
+			name:         "keeps the stored name if the stored release isn't deleted",
+			fail:         fake.OpDelete,
+			wantName:     legacy,
+			wantReleases: map[string]int{legacy: 1, renamed: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := fake.NewBackend(fake.NewRelease(legacy, testNamespace, rspb.Status_DEPLOYED))
+			if tt.fail != "" {
+				backend.Fail(tt.fail, errors.New("injected failure"))
+			}
+			env := newTestEnv(t, backend)
+			defer env.close()
+			env.controller.Config.ReleaseNaming = lmhelm.ReleaseNamingHash
+			env.controller.Config.ReleaseNameMigration = lmhelm.ReleaseNameMigrationRename
+
+			chartmgr := env.chartmgr("", "1.1.0")
+			chartmgr.Spec.Release = nil
+			chartmgr.Status.ReleaseName = legacy
+			env.client = clientfake.NewClient(chartmgr)
+			env.controller.Interface = env.client
+
+			env.controller.updateChartMgr(chartmgr)
+
+			updated, err := env.client.GetChartManager(testNamespace, "app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.Status.ReleaseName != tt.wantName {
+				t.Errorf("status release name = %q, want %q", updated.Status.ReleaseName, tt.wantName)
+			}
+			for name, want := range tt.wantReleases {
+				if got := len(backend.Revisions(name)); got != want {
+					t.Errorf("release %s has %d revisions, want %d", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/constants"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/redact"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func (c *Controller) manageRepositories(ctx context.Context) error {
+	_, controller := cache.NewInformer(
+		c.ListWatch(crv1alpha1.ChartRepositoryResourcePlural),
+		&crv1alpha1.ChartRepository{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.addRepositoryFunc,
+			UpdateFunc: c.updateRepositoryFunc,
+			DeleteFunc: c.deleteRepositoryFunc,
+		},
+	)
+
+	go controller.Run(ctx.Done())
+	return nil
+}
+
+func (c *Controller) addRepositoryFunc(obj interface{}) {
+	repository := obj.(*crv1alpha1.ChartRepository)
+	c.startRepositoryRefresher(repository)
+	log.Infof("Created Chart Repository: %s", repository.Name)
+}
+
+func (c *Controller) updateRepositoryFunc(oldObj, newObj interface{}) {
+	oldRepository := oldObj.(*crv1alpha1.ChartRepository)
+	newRepository := newObj.(*crv1alpha1.ChartRepository)
+
+	// status updates made by the refresher also trigger updates
+	if reflect.DeepEqual(oldRepository.Spec, newRepository.Spec) {
+		return
+	}
+	c.startRepositoryRefresher(newRepository)
+	log.Infof("Updated Chart Repository: %s", newRepository.Name)
+}
+
+func (c *Controller) deleteRepositoryFunc(obj interface{}) {
+	repository, ok := obj.(*crv1alpha1.ChartRepository)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		repository, ok = tombstone.Obj.(*crv1alpha1.ChartRepository)
+		if !ok {
+			return
+		}
+	}
+	c.stopRepositoryRefresher(repository.Name)
+	log.Infof("Deleted Chart Repository: %s", repository.Name)
+}
+
+// startRepositoryRefresher starts refreshing the repository index in the
+// background, replacing the refresher of an earlier version of the repository
+func (c *Controller) startRepositoryRefresher(repository *crv1alpha1.ChartRepository) {
+	c.repositoryMu.Lock()
+	defer c.repositoryMu.Unlock()
+
+	if cancel, ok := c.repositoryRefreshers[repository.Name]; ok {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.repositoryRefreshers[repository.Name] = cancel
+	go c.refreshRepository(ctx, repository.DeepCopy())
+}
+
+func (c *Controller) stopRepositoryRefresher(name string) {
+	c.repositoryMu.Lock()
+	defer c.repositoryMu.Unlock()
+
+	if cancel, ok := c.repositoryRefreshers[name]; ok {
+		cancel()
+		delete(c.repositoryRefreshers, name)
+	}
+}
+
+func (c *Controller) refreshRepository(ctx context.Context, repository *crv1alpha1.ChartRepository) {
+	ticker := time.NewTicker(repositoryRefreshInterval(repository))
+	defer ticker.Stop()
+
+	for {
+		c.refreshRepositoryIndex(repository)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Controller) refreshRepositoryIndex(repository *crv1alpha1.ChartRepository) {
+	now := metav1.Now()
+	status := crv1alpha1.ChartRepositoryStatus{
+		State:           crv1alpha1.ChartRepositoryStateHealthy,
+		LastRefreshTime: &now,
+	}
+
+	count, err := c.HelmClient.RefreshRepository(repository)
+	if err != nil {
+		log.Errorf("Failed to refresh chart repository %s: %v", repository.Name, err)
+		status.State = crv1alpha1.ChartRepositoryStateUnhealthy
+		status.Message = redact.String(err.Error())
+	} else {
+		log.Debugf("Refreshed chart repository %s: %d charts", repository.Name, count)
+		status.ChartCount = count
+	}
+	c.updateChartRepositoryStatus(repository.Name, status)
+}
+
+func (c *Controller) updateChartRepositoryStatus(name string, status crv1alpha1.ChartRepositoryStatus) {
+	// fetch the latest version to avoid conflicting with spec updates
+	repository, err := c.GetChartRepository(name)
+	if err != nil {
+		log.Errorf("Failed to get chart repository %s: %v", name, err)
+		return
+	}
+
+	repository.Status = status
+	err = c.UpdateChartRepository(repository)
+	if err != nil {
+		log.Errorf("Failed to update chart repository %s status: %v", name, err)
+	}
+}
+
+func repositoryRefreshInterval(repository *crv1alpha1.ChartRepository) time.Duration {
+	if repository.Spec.RefreshIntervalSec > 0 {
+		return time.Duration(repository.Spec.RefreshIntervalSec) * time.Second
+	}
+	return constants.DefaultRepositoryRefreshIntervalSec * time.Second
+}
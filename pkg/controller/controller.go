@@ -3,15 +3,16 @@ package controller
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
 	chartmgrclient "github.com/logicmonitor/k8s-chart-manager-controller/pkg/client"
 	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
 	lmhelm "github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/redact"
 	log "github.com/sirupsen/logrus"
-	apiv1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -20,10 +21,21 @@ import (
 // Controller is the Kubernetes controller object for LogicMonitor
 // chartmgrs.
 type Controller struct {
-	*chartmgrclient.Client
-	ChartMgrScheme *runtime.Scheme
-	Config         *config.Config
-	HelmClient     *lmhelm.Client
+	chartmgrclient.Interface
+	ChartMgrScheme       *runtime.Scheme
+	Config               *config.Config
+	HelmClient           lmhelm.Interface
+	ctx                  context.Context
+	repositoryMu         sync.Mutex
+	repositoryRefreshers map[string]context.CancelFunc
+	sourceMu             sync.Mutex
+	sourcePollers        map[string]context.CancelFunc
+	retryMu              sync.Mutex
+	retries              map[string]func()
+	chartmgrsMu          sync.Mutex
+	chartmgrs            cache.Store
+	chartmgrsSynced      func() bool
+	orphans              map[string]time.Time
 }
 
 // New instantiates and returns a Controller and an error if any.
@@ -49,18 +61,49 @@ func New(chartmgrconfig *config.Config) (*Controller, error) {
 
 	// start a controller on instances of our custom resource
 	c := &Controller{
-		Client:         client,
-		ChartMgrScheme: chartmgrscheme,
-		Config:         chartmgrconfig,
-		HelmClient:     helmClient,
+		Interface:            client,
+		ChartMgrScheme:       chartmgrscheme,
+		Config:               chartmgrconfig,
+		HelmClient:           helmClient,
+		repositoryRefreshers: map[string]context.CancelFunc{},
+		sourcePollers:        map[string]context.CancelFunc{},
+		retries:              map[string]func(){},
 	}
 	return c, nil
 }
 
 // Run starts a Chart Manager resource controller.
 func (c *Controller) Run(ctx context.Context) error {
+	c.ctx = ctx
+
+	// Chart Managers start polling their chart sources once they are managed
+	if c.Config.SourcePollIntervalSec <= 0 {
+		return fmt.Errorf("Source poll interval must be positive, got %d", c.Config.SourcePollIntervalSec)
+	}
+
+	// Manage Chart Repository objects
+	err := c.manageRepositories(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Manage Chart Values Profile objects before the Chart Managers they apply to
+	err = c.manageProfiles(ctx)
+	if err != nil {
+		return err
+	}
+
 	// Manage Chart Manager objects
-	err := c.manage(ctx)
+	err = c.manage(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Reconcile again what failed while the release backend was unreachable
+	go c.retryReconciles(ctx)
+
+	// Look for releases left behind by deleted Chart Managers
+	err = c.startOrphanSweeper(ctx)
 	if err != nil {
 		return err
 	}
@@ -72,13 +115,8 @@ func (c *Controller) Run(ctx context.Context) error {
 }
 
 func (c *Controller) manage(ctx context.Context) error {
-	_, controller := cache.NewInformer(
-		cache.NewListWatchFromClient(
-			c.RESTClient,
-			crv1alpha1.ChartMgrResourcePlural,
-			apiv1.NamespaceAll,
-			fields.Everything(),
-		),
+	store, controller := cache.NewInformer(
+		c.ListWatch(crv1alpha1.ChartMgrResourcePlural),
 		&crv1alpha1.ChartManager{},
 		0,
 		cache.ResourceEventHandlerFuncs{
@@ -88,6 +126,11 @@ func (c *Controller) manage(ctx context.Context) error {
 		},
 	)
 
+	c.chartmgrsMu.Lock()
+	c.chartmgrs = store
+	c.chartmgrsSynced = controller.HasSynced
+	c.chartmgrsMu.Unlock()
+
 	go controller.Run(ctx.Done())
 	return nil
 }
@@ -95,10 +138,15 @@ func (c *Controller) manage(ctx context.Context) error {
 func (c *Controller) addFunc(obj interface{}) {
 	go func(obj interface{}) {
 		chartmgr := obj.(*crv1alpha1.ChartManager)
-		rls, err := CreateOrUpdateChartMgr(chartmgr, c.HelmClient)
+		c.syncSourcePoller(chartmgr)
+		reconnected := c.HelmClient.Reconnected()
+		rls, err := c.createOrUpdateChartMgr(chartmgr)
 		if err != nil {
 			log.Errorf("%s", err)
-			c.updateChartMgrStatus(chartmgr, rls, err.Error())
+			c.updateChartMgrStatus(chartmgr, rls, failedReleaseName(chartmgr, rls), err.Error())
+			c.retryOnReconnect(chartmgr, err, reconnected, c.retryLatest(chartmgr, func(latest *crv1alpha1.ChartManager) {
+				c.addFunc(latest)
+			}))
 			return
 		}
 
@@ -115,77 +163,127 @@ func (c *Controller) updateFunc(oldObj, newObj interface{}) {
 	go func(oldObj interface{}, newObj interface{}) {
 		_ = oldObj.(*crv1alpha1.ChartManager)
 		newChartMgr := newObj.(*crv1alpha1.ChartManager)
+		c.syncSourcePoller(newChartMgr)
+		c.updateChartMgr(newChartMgr)
+	}(oldObj, newObj)
+}
 
-		rls, err := CreateOrUpdateChartMgr(newChartMgr, c.HelmClient)
-		if err != nil {
-			log.Errorf("%s", err)
-			c.updateChartMgrStatus(newChartMgr, rls, err.Error())
-			return
-		}
+func (c *Controller) updateChartMgr(chartmgr *crv1alpha1.ChartManager) {
+	reconnected := c.HelmClient.Reconnected()
+	rls, err := c.createOrUpdateChartMgr(chartmgr)
+	if err != nil {
+		log.Errorf("%s", err)
+		c.updateChartMgrStatus(chartmgr, rls, failedReleaseName(chartmgr, rls), err.Error())
+		c.retryOnReconnect(chartmgr, err, reconnected, c.retryLatest(chartmgr, c.updateChartMgr))
+		return
+	}
 
-		if lmhelm.CreateOnly(newChartMgr) {
-			log.Infof("CreateOnly mode. Ignoring update of chart manager %s.", newChartMgr.Name)
-			return
-		}
+	if lmhelm.CreateOnly(chartmgr) {
+		log.Infof("CreateOnly mode. Ignoring update of chart manager %s.", chartmgr.Name)
+		return
+	}
 
-		err = c.updateStatus(newChartMgr, rls)
-		if err != nil {
-			return
-		}
-		log.Infof("Updated Chart Manager: %s", newChartMgr.Name)
-	}(oldObj, newObj)
+	err = c.updateStatus(chartmgr, rls)
+	if err != nil {
+		return
+	}
+	log.Infof("Updated Chart Manager: %s", chartmgr.Name)
 }
 
 func (c *Controller) deleteFunc(obj interface{}) {
 	go func(obj interface{}) {
 		chartmgr := obj.(*crv1alpha1.ChartManager)
+		c.stopSourcePoller(chartmgr)
 
-		_, err := DeleteChartMgr(chartmgr, c.HelmClient)
+		reconnected := c.HelmClient.Reconnected()
+		rls, err := DeleteChartMgr(chartmgr, c.HelmClient)
+		defer rls.ForgetSecrets()
 		if err != nil {
 			log.Errorf("Failed to delete Chart Manager: %v", err)
+			c.retryOnReconnect(chartmgr, err, reconnected, func() {
+				c.deleteFunc(chartmgr)
+			})
 			return
 		}
 		log.Infof("Deleted Chart Manager: %s", chartmgr.Name)
 	}(obj)
 }
 
+func (c *Controller) createOrUpdateChartMgr(chartmgr *crv1alpha1.ChartManager) (*lmhelm.Release, error) {
+	rls := c.HelmClient.NewRelease(chartmgr)
+
+	profiles, err := c.ListChartValuesProfiles()
+	if err != nil {
+		return rls, fmt.Errorf("Failed to list values profiles: %v", err)
+	}
+
+	repository, err := c.chartRepository(chartmgr)
+	if err != nil {
+		return rls, err
+	}
+	return CreateOrUpdateChartMgr(chartmgr, profiles, repository, c.HelmClient)
+}
+
+func (c *Controller) chartRepository(chartmgr *crv1alpha1.ChartManager) (*crv1alpha1.ChartRepository, error) {
+	ref := chartmgr.Spec.Chart.RepositoryRef
+	if ref == nil {
+		return nil, nil
+	}
+	if chartmgr.Spec.Chart.Repository != nil {
+		return nil, errors.New("Chart repository and repositoryRef are mutually exclusive")
+	}
+
+	repository, err := c.GetChartRepository(ref.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get chart repository %s: %v", ref.Name, err)
+	}
+	return repository, nil
+}
+
 func (c *Controller) updateStatus(chartmgr *crv1alpha1.ChartManager, rls *lmhelm.Release) error {
 	err := c.waitForReleaseToDeploy(rls)
 	if err != nil {
 		log.Errorf("Failed to verify that release %v deployed: %v", rls.Name(), err)
-		c.updateChartMgrStatus(chartmgr, rls, err.Error())
+		c.updateChartMgrStatus(chartmgr, rls, rls.Name(), err.Error())
 	} else {
 		log.Infof("Chart Manager %s release %s status is Deployed", chartmgr.Name, rls.Name())
-		c.updateChartMgrStatus(chartmgr, rls, string(rls.Status()))
+		c.updateChartMgrStatus(chartmgr, rls, rls.Name(), string(rls.Status()))
 	}
 	return err
 }
 
-func (c *Controller) updateChartMgrStatus(chartmgr *crv1alpha1.ChartManager, rls *lmhelm.Release, message string) {
-	log.Debugf("Updating Chart Manager status: state=%s release=%s", rls.Status(), rls.Name())
+// failedReleaseName returns the release name to record in the status of a
+// chart manager that failed to reconcile. the stored name is kept, since the
+// release may not be managed under its calculated name yet, e.g. when a
+// rename failed, and recording the calculated name would orphan it.
+func failedReleaseName(chartmgr *crv1alpha1.ChartManager, rls *lmhelm.Release) string {
+	if resourceReleaseName(chartmgr) != "" {
+		return resourceReleaseName(chartmgr)
+	}
+	return rls.Name()
+}
+
+func (c *Controller) updateChartMgrStatus(chartmgr *crv1alpha1.ChartManager, rls *lmhelm.Release, releaseName string, message string) {
+	log.Debugf("Updating Chart Manager status: state=%s release=%s", rls.Status(), releaseName)
 	chartmgrCopy := chartmgr.DeepCopy()
 	chartmgrCopy.Status = crv1alpha1.ChartMgrStatus{
-		State:       rls.Status(),
-		ReleaseName: rls.Name(),
-		Message:     message,
+		State:           rls.Status(),
+		ReleaseName:     releaseName,
+		Message:         redact.String(message),
+		InvalidValues:   rls.InvalidValues(),
+		AppliedProfiles: rls.AppliedProfiles(),
+		GitCommit:       rls.GitCommit(),
+		ChartDigest:     rls.ChartDigest(),
+		Provenance:      rls.Provenance(),
+		Dependencies:    rls.Dependencies(),
 	}
 
-	err := c.put(chartmgrCopy)
+	err := c.UpdateChartManager(chartmgrCopy)
 	if err != nil {
 		log.Errorf("Failed to update status: %v", err)
 	}
 }
 
-func (c *Controller) put(chartmgr *crv1alpha1.ChartManager) error {
-	return c.RESTClient.Put().
-		Name(chartmgr.ObjectMeta.Name).
-		Namespace(chartmgr.ObjectMeta.Namespace).
-		Resource(crv1alpha1.ChartMgrResourcePlural).
-		Body(chartmgr).
-		Do().
-		Error()
-}
-
 func (c *Controller) waitForReleaseToDeploy(rls *lmhelm.Release) error {
 	timeout := time.After(2 * time.Minute)
 	ticker := time.NewTicker(30 * time.Second)
@@ -196,7 +294,13 @@ func (c *Controller) waitForReleaseToDeploy(rls *lmhelm.Release) error {
 			return errors.New("Timed out waiting for release to deploy")
 		default:
 			log.Debugf("Checking status of release %s", rls.Name())
-			if rls.Deployed() {
+			deployed, err := rls.Deployed()
+			if lmhelm.IsReleaseNotFound(err) {
+				return err
+			}
+			if err != nil {
+				log.Warnf("Failed to check status of release %s: %v", rls.Name(), err)
+			} else if deployed {
 				return nil
 			}
 		}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/any"
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm/fake"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// newDependentChart returns the app chart requiring the lib chart with the
+// version constraint from the repository, and shipping the vendored charts
+func newDependentChart(version string, constraint string, repository string, vendored ...*chart.Chart) *chart.Chart {
+	ch := fake.NewChart("app", version, "replicas: 1\n")
+	requirements := fmt.Sprintf("dependencies:\n- name: lib\n  version: %q\n  repository: %q\n", constraint, repository)
+	ch.Files = []*any.Any{{TypeUrl: "requirements.yaml", Value: []byte(requirements)}}
+	ch.Dependencies = vendored
+	return ch
+}
+
+// addRepositorySecret adds the credentials of the private repositories
+func (e *testEnv) addRepositorySecret() {
+	e.kubeAPI.Set("secrets", testNamespace, "repo-creds", &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "repo-creds", Namespace: testNamespace},
+		Data: map[string][]byte{
+			lmhelm.RepoSecretUsernameKey: []byte("deploy"),
+			lmhelm.RepoSecretPasswordKey: []byte("s3cr3t"),
+		},
+	})
+}
+
+// registerRepository adds the chart repository to the helm client
+func (e *testEnv) registerRepository(t *testing.T, name string, url string, secret string) {
+	repository := &crv1alpha1.ChartRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       crv1alpha1.ChartRepositorySpec{URL: url},
+	}
+	if secret != "" {
+		repository.Spec.SecretRef = &crv1alpha1.ChartRepositorySecretRef{Name: secret, Namespace: testNamespace}
+	}
+	_, err := e.controller.HelmClient.RefreshRepository(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChartDependencies(t *testing.T) {
+	lib := fake.NewChart("lib", "1.0.0", "")
+	private, err := fake.NewChartRepository(lib, fake.NewChart("lib", "1.1.0", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer private.Close()
+	private.RequireBasicAuth("deploy", "s3cr3t")
+	err = private.Add(newDependentChart("2.0.0", "^1.0.0", private.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// setup serves the app chart and returns the repository of the chart
+		// manager, or nil for the public test repository
+		setup   func(t *testing.T, env *testEnv) *crv1alpha1.ChartMgrChartRepository
+		want    []crv1alpha1.ChartMgrDependency
+		wantErr string
+	}{
+		{
+			name: "vendored",
+			setup: func(t *testing.T, env *testEnv) *crv1alpha1.ChartMgrChartRepository {
+				err := env.repository.Add(newDependentChart("2.0.0", "^1.0.0", "@missing", lib))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return nil
+			},
+			want: []crv1alpha1.ChartMgrDependency{{Name: "lib", Version: "1.0.0", Repository: "@missing"}},
+		},
+		{
+			name: "missing from an unknown repository",
+			setup: func(t *testing.T, env *testEnv) *crv1alpha1.ChartMgrChartRepository {
+				err := env.repository.Add(newDependentChart("2.0.0", "^1.0.0", "@missing"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return nil
+			},
+			wantErr: "Dependency lib is from unknown repository @missing. Add a ChartRepository or default repository for it",
+		},
+		{
+			name: "release repository credentials",
+			setup: func(t *testing.T, env *testEnv) *crv1alpha1.ChartMgrChartRepository {
+				return &crv1alpha1.ChartMgrChartRepository{
+					Name:      "private",
+					URL:       private.URL,
+					SecretRef: &crv1alpha1.ChartMgrRepositorySecretRef{Name: "repo-creds"},
+				}
+			},
+			want: []crv1alpha1.ChartMgrDependency{{Name: "lib", Version: "1.1.0", Repository: private.URL}},
+		},
+		{
+			name: "chart repository credentials",
+			setup: func(t *testing.T, env *testEnv) *crv1alpha1.ChartMgrChartRepository {
+				env.registerRepository(t, "libs", private.URL, "repo-creds")
+				err := env.repository.Add(newDependentChart("2.0.0", "^1.0.0", "@libs"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return nil
+			},
+			want: []crv1alpha1.ChartMgrDependency{{Name: "lib", Version: "1.1.0", Repository: "@libs"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := fake.NewBackend()
+			env := newTestEnv(t, backend)
+			defer env.close()
+			env.addRepositorySecret()
+
+			chartmgr := env.chartmgr("app", "2.0.0")
+			if repository := tt.setup(t, env); repository != nil {
+				chartmgr.Spec.Chart.Repository = repository
+			}
+
+			rls, err := CreateOrUpdateChartMgr(chartmgr, nil, nil, env.controller.HelmClient)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("CreateOrUpdateChartMgr() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rls.Dependencies(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dependencies = %+v, want %+v", got, tt.want)
+			}
+			installed := backend.Revisions("app")[0].GetChart()
+			if len(installed.Dependencies) != 1 || installed.Dependencies[0].Metadata.Version != tt.want[0].Version {
+				t.Errorf("installed chart with dependencies %v", installed.Dependencies)
+			}
+		})
+	}
+}
+
+func TestChartDependencyLock(t *testing.T) {
+	backend := fake.NewBackend()
+	env := newTestEnv(t, backend)
+	defer env.close()
+	libs, err := fake.NewChartRepository(fake.NewChart("lib", "1.0.0", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer libs.Close()
+	env.registerRepository(t, "libs", libs.URL, "")
+	err = env.repository.Add(
+		newDependentChart("2.0.0", ">=1.0.0", "@libs"),
+		newDependentChart("2.1.0", ">=1.1.0", "@libs"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockPath := filepath.Join(env.home, "cache", "locks", testNamespace, "app.lock")
+
+	install := func(version string) string {
+		rls, err := CreateOrUpdateChartMgr(env.chartmgr("app", version), nil, nil, env.controller.HelmClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deps := rls.Dependencies()
+		if len(deps) != 1 {
+			t.Fatalf("dependencies = %v", deps)
+		}
+		return deps[0].Version
+	}
+	lockedVersion := func() string {
+		data, err := ioutil.ReadFile(lockPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "version: ") {
+				return strings.TrimPrefix(strings.TrimSpace(line), "version: ")
+			}
+		}
+		t.Fatalf("no version in lock %s", data)
+		return ""
+	}
+
+	if got := install("2.0.0"); got != "1.0.0" {
+		t.Errorf("resolved lib %s, want 1.0.0", got)
+	}
+	if got := lockedVersion(); got != "1.0.0" {
+		t.Errorf("locked lib %s, want 1.0.0", got)
+	}
+
+	// a newer version doesn't change the resolution of the same requirements
+	err = libs.Add(fake.NewChart("lib", "1.1.0", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.registerRepository(t, "libs", libs.URL, "")
+	if got := install("2.0.0"); got != "1.0.0" {
+		t.Errorf("resolved lib %s after restoring the lock, want 1.0.0", got)
+	}
+
+	// changed requirements are resolved again
+	if got := install("2.1.0"); got != "1.1.0" {
+		t.Errorf("resolved lib %s for changed requirements, want 1.1.0", got)
+	}
+	if got := lockedVersion(); got != "1.1.0" {
+		t.Errorf("locked lib %s, want 1.1.0", got)
+	}
+
+	err = env.controller.HelmClient.RemoveDependencyLock(env.chartmgr("app", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("the lock wasn't removed: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// OrphanSweepOff disables the orphaned release sweeper
+	OrphanSweepOff = "off"
+	// OrphanSweepReport reports orphaned releases without deleting them
+	OrphanSweepReport = "report"
+	// OrphanSweepPurge purges releases orphaned for longer than the grace
+	// period
+	OrphanSweepPurge = "purge"
+)
+
+// startOrphanSweeper periodically looks for releases the controller created
+// that no chart manager manages anymore
+func (c *Controller) startOrphanSweeper(ctx context.Context) error {
+	switch c.Config.OrphanSweepMode {
+	case "", OrphanSweepOff:
+		return nil
+	case OrphanSweepReport, OrphanSweepPurge:
+	default:
+		return fmt.Errorf("Unknown orphan sweep mode %q. Supported modes: %s, %s, %s", c.Config.OrphanSweepMode, OrphanSweepOff, OrphanSweepReport, OrphanSweepPurge)
+	}
+
+	if c.Config.OrphanSweepIntervalSec <= 0 {
+		return fmt.Errorf("Orphan sweep interval must be positive, got %d", c.Config.OrphanSweepIntervalSec)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(c.Config.OrphanSweepIntervalSec) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				c.sweepOrphans(now)
+			}
+		}
+	}()
+	return nil
+}
+
+// sweepOrphans reports the releases the controller installed that no chart
+// manager manages, and purges those orphaned for longer than the grace period
+// in purge mode
+func (c *Controller) sweepOrphans(now time.Time) {
+	// a release missing from the informer before it synced isn't orphaned
+	if !c.chartmgrsSynced() {
+		log.Debugf("Skipping orphaned release sweep until chart managers are synced")
+		return
+	}
+
+	// list the releases before the chart managers, so that a release listed
+	// belongs to a chart manager listed
+	releases, err := c.HelmClient.ManagedReleases()
+	if err != nil {
+		log.Errorf("Failed to list releases for the orphaned release sweep: %v", err)
+		return
+	}
+	managed := map[string]bool{}
+	for _, obj := range c.chartmgrs.List() {
+		chartmgr := obj.(*crv1alpha1.ChartManager)
+		managed[c.HelmClient.NewRelease(chartmgr).Name()] = true
+		managed[resourceReleaseName(chartmgr)] = true
+	}
+
+	grace := time.Duration(c.Config.OrphanGracePeriodSec) * time.Second
+	orphans := map[string]time.Time{}
+	for _, rls := range releases {
+		if managed[rls.Name] {
+			continue
+		}
+		since, ok := c.orphans[rls.String()]
+		if !ok {
+			since = now
+		}
+
+		if c.Config.OrphanSweepMode != OrphanSweepPurge || now.Sub(since) < grace {
+			log.Warnf("Release %s in namespace %s is orphaned since %s", rls, rls.Namespace, since.Format(time.RFC3339))
+			orphans[rls.String()] = since
+			continue
+		}
+		log.Infof("Purging release %s orphaned since %s", rls, since.Format(time.RFC3339))
+		err = c.HelmClient.PurgeRelease(rls)
+		if err != nil {
+			log.Errorf("Failed to purge orphaned release %s: %v", rls, err)
+			orphans[rls.String()] = since
+			continue
+		}
+		metrics.OrphanPurged()
+	}
+
+	c.orphans = orphans
+	metrics.OrphanedReleases(int64(len(orphans)))
+}
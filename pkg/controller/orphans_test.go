@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	lmhelm "github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func TestSweepOrphans(t *testing.T) {
+	managed := "chartmgr-rls-1234"
+	stored := "chartmgr-rls-5678"
+	// releases are selected by the marker, whatever their names
+	orphan := "custom-9999"
+	unmarked := "chartmgr-rls-0000"
+	tests := []struct {
+		name         string
+		mode         string
+		notSynced    bool
+		sweeps       []time.Duration
+		fail         string
+		wantCalls    []string
+		wantReleases map[string]int
+		wantOrphans  []string
+	}{
+		{
+			name:         "reports orphans in report mode",
+			mode:         OrphanSweepReport,
+			sweeps:       []time.Duration{0, 2 * time.Hour},
+			wantCalls:    []string{"list ", "list "},
+			wantReleases: map[string]int{managed: 1, stored: 1, orphan: 1, unmarked: 1},
+			wantOrphans:  []string{orphan},
+		},
+		{
+			name:         "keeps orphans during the grace period",
+			mode:         OrphanSweepPurge,
+			sweeps:       []time.Duration{0, 30 * time.Minute},
+			wantCalls:    []string{"list ", "list "},
+			wantReleases: map[string]int{managed: 1, stored: 1, orphan: 1, unmarked: 1},
+			wantOrphans:  []string{orphan},
+		},
+		{
+			name:         "purges orphans after the grace period",
+			mode:         OrphanSweepPurge,
+			sweeps:       []time.Duration{0, time.Hour},
+			wantCalls:    []string{"list ", "list ", "delete " + orphan},
+			wantReleases: map[string]int{managed: 1, stored: 1, orphan: 0, unmarked: 1},
+			wantOrphans:  []string{},
+		},
+		{
+			name:         "keeps orphans that failed to purge",
+			mode:         OrphanSweepPurge,
+			sweeps:       []time.Duration{0, time.Hour},
+			fail:         fake.OpDelete,
+			wantCalls:    []string{"list ", "list ", "delete " + orphan},
+			wantReleases: map[string]int{managed: 1, stored: 1, orphan: 1, unmarked: 1},
+			wantOrphans:  []string{orphan},
+		},
+		{
+			name:         "doesn't sweep before the chart managers are synced",
+			mode:         OrphanSweepPurge,
+			notSynced:    true,
+			sweeps:       []time.Duration{0, time.Hour},
+			wantCalls:    []string{},
+			wantReleases: map[string]int{managed: 1, stored: 1, orphan: 1, unmarked: 1},
+			wantOrphans:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			releases := []*rspb.Release{
+				fake.NewRelease(managed, testNamespace, rspb.Status_DEPLOYED),
+				fake.NewRelease(stored, testNamespace, rspb.Status_DEPLOYED),
+				fake.NewRelease(orphan, testNamespace, rspb.Status_DEPLOYED),
+			}
+			for _, rls := range releases {
+				rls.Chart = &chart.Chart{Metadata: &chart.Metadata{
+					Annotations: map[string]string{lmhelm.ManagedByAnnotation: testNamespace + "/app"},
+				}}
+			}
+			backend := fake.NewBackend(append(releases, fake.NewRelease(unmarked, testNamespace, rspb.Status_DEPLOYED))...)
+			if tt.fail != "" {
+				backend.Fail(tt.fail, errors.New("injected failure"))
+			}
+			env := newTestEnv(t, backend)
+			defer env.close()
+			env.controller.Config.OrphanSweepMode = tt.mode
+			env.controller.Config.OrphanGracePeriodSec = 3600
+
+			// a chart manager with the uid naming, and one whose release is
+			// only known from its status
+			generated := env.chartmgr("", "1.1.0")
+			generated.Spec.Release = nil
+			renamed := env.chartmgr("app", "1.1.0")
+			renamed.ObjectMeta.Name = "renamed"
+			renamed.Status.ReleaseName = stored
+			env.controller.chartmgrs = cache.NewStore(cache.MetaNamespaceKeyFunc)
+			for _, chartmgr := range []*crv1alpha1.ChartManager{generated, renamed} {
+				err := env.controller.chartmgrs.Add(chartmgr)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			env.controller.chartmgrsSynced = func() bool { return !tt.notSynced }
+
+			start := time.Now()
+			for _, after := range tt.sweeps {
+				env.controller.sweepOrphans(start.Add(after))
+			}
+
+			if calls := backend.Calls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("backend calls = %v, want %v", calls, tt.wantCalls)
+			}
+			for name, want := range tt.wantReleases {
+				if got := len(backend.Revisions(name)); got != want {
+					t.Errorf("release %s has %d revisions, want %d", name, got, want)
+				}
+			}
+			orphans := []string{}
+			for name := range env.controller.orphans {
+				orphans = append(orphans, name)
+			}
+			if !reflect.DeepEqual(orphans, tt.wantOrphans) {
+				t.Errorf("orphans = %v, want %v", orphans, tt.wantOrphans)
+			}
+		})
+	}
+}
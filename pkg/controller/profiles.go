@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	lmhelm "github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/tools/cache"
+)
+
+// manageProfiles re-reconciles the chart managers matching a values profile
+// when it changes. it waits for the existing profiles to be listed, since the
+// chart managers apply them when they are added.
+func (c *Controller) manageProfiles(ctx context.Context) error {
+	_, controller := cache.NewInformer(
+		c.ListWatch(crv1alpha1.ChartValuesProfileResourcePlural),
+		&crv1alpha1.ChartValuesProfile{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.addProfileFunc,
+			UpdateFunc: c.updateProfileFunc,
+			DeleteFunc: c.deleteProfileFunc,
+		},
+	)
+
+	go controller.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), controller.HasSynced) {
+		return errors.New("Failed to list values profiles")
+	}
+	return nil
+}
+
+func (c *Controller) addProfileFunc(obj interface{}) {
+	profile := obj.(*crv1alpha1.ChartValuesProfile)
+	c.reconcileProfileChartMgrs(profile)
+	log.Infof("Created Chart Values Profile: %s", profile.Name)
+}
+
+func (c *Controller) updateProfileFunc(oldObj, newObj interface{}) {
+	oldProfile := oldObj.(*crv1alpha1.ChartValuesProfile)
+	newProfile := newObj.(*crv1alpha1.ChartValuesProfile)
+
+	if reflect.DeepEqual(oldProfile.Spec, newProfile.Spec) {
+		return
+	}
+	// the chart managers the profile stops matching drop its values
+	c.reconcileProfileChartMgrs(oldProfile, newProfile)
+	log.Infof("Updated Chart Values Profile: %s", newProfile.Name)
+}
+
+func (c *Controller) deleteProfileFunc(obj interface{}) {
+	profile, ok := obj.(*crv1alpha1.ChartValuesProfile)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		profile, ok = tombstone.Obj.(*crv1alpha1.ChartValuesProfile)
+		if !ok {
+			return
+		}
+	}
+	c.reconcileProfileChartMgrs(profile)
+	log.Infof("Deleted Chart Values Profile: %s", profile.Name)
+}
+
+func (c *Controller) reconcileProfileChartMgrs(profiles ...*crv1alpha1.ChartValuesProfile) {
+	for _, chartmgr := range c.profileChartMgrs(profiles...) {
+		log.Infof("Reconciling Chart Manager %s/%s for its values profiles", chartmgr.Namespace, chartmgr.Name)
+		go c.updateChartMgr(chartmgr)
+	}
+}
+
+// profileChartMgrs returns the managed chart managers matching any of the
+// profiles. a chart manager is returned for an invalid selector too, so that
+// its status reports the error.
+func (c *Controller) profileChartMgrs(profiles ...*crv1alpha1.ChartValuesProfile) []*crv1alpha1.ChartManager {
+	c.chartmgrsMu.Lock()
+	store := c.chartmgrs
+	c.chartmgrsMu.Unlock()
+	// chart managers not managed yet apply the profiles once added
+	if store == nil {
+		return nil
+	}
+
+	chartmgrs := []*crv1alpha1.ChartManager{}
+	for _, obj := range store.List() {
+		chartmgr := obj.(*crv1alpha1.ChartManager)
+		for _, profile := range profiles {
+			match, err := lmhelm.ProfileMatches(*profile, chartmgr)
+			if match || err != nil {
+				chartmgrs = append(chartmgrs, chartmgr)
+				break
+			}
+		}
+	}
+	return chartmgrs
+}
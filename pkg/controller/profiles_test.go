@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	clientfake "github.com/logicmonitor/k8s-chart-manager-controller/pkg/client/fake"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestValuesProfile(name string, selector *crv1alpha1.ChartValuesProfileSelector) *crv1alpha1.ChartValuesProfile {
+	return &crv1alpha1.ChartValuesProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: crv1alpha1.ChartValuesProfileSpec{
+			Selector: selector,
+			Values:   []*crv1alpha1.ChartMgrValuePair{{Name: "replicas", Value: "2"}},
+		},
+	}
+}
+
+func teamSelector(team string) *crv1alpha1.ChartValuesProfileSelector {
+	return &crv1alpha1.ChartValuesProfileSelector{
+		ChartMgrSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": team}},
+	}
+}
+
+func TestProfileChartMgrs(t *testing.T) {
+	invalid := &crv1alpha1.ChartValuesProfileSelector{
+		ChartMgrSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "team", Operator: "Near"},
+		}},
+	}
+	tests := []struct {
+		name       string
+		notManaged bool
+		profiles   []*crv1alpha1.ChartValuesProfile
+		want       []string
+	}{
+		{
+			name:     "all",
+			profiles: []*crv1alpha1.ChartValuesProfile{newTestValuesProfile("all", nil)},
+			want:     []string{"db", "redis", "web"},
+		},
+		{
+			name:     "labels",
+			profiles: []*crv1alpha1.ChartValuesProfile{newTestValuesProfile("web", teamSelector("web"))},
+			want:     []string{"web"},
+		},
+		{
+			name: "chart",
+			profiles: []*crv1alpha1.ChartValuesProfile{
+				newTestValuesProfile("redis", &crv1alpha1.ChartValuesProfileSelector{Charts: []string{"redis"}}),
+			},
+			want: []string{"redis"},
+		},
+		{
+			name: "matched before or after a change",
+			profiles: []*crv1alpha1.ChartValuesProfile{
+				newTestValuesProfile("team", teamSelector("web")),
+				newTestValuesProfile("team", teamSelector("db")),
+			},
+			want: []string{"db", "web"},
+		},
+		{
+			name:     "none",
+			profiles: []*crv1alpha1.ChartValuesProfile{newTestValuesProfile("ops", teamSelector("ops"))},
+			want:     []string{},
+		},
+		{
+			name:     "invalid selector",
+			profiles: []*crv1alpha1.ChartValuesProfile{newTestValuesProfile("invalid", invalid)},
+			want:     []string{"db", "redis", "web"},
+		},
+		{
+			name:       "chart managers not managed yet",
+			notManaged: true,
+			profiles:   []*crv1alpha1.ChartValuesProfile{newTestValuesProfile("all", nil)},
+			want:       []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, fake.NewBackend())
+			defer env.close()
+
+			if !tt.notManaged {
+				env.controller.chartmgrs = cache.NewStore(cache.MetaNamespaceKeyFunc)
+				for _, name := range []string{"web", "db", "redis"} {
+					chartmgr := env.chartmgr(name, "1.1.0")
+					chartmgr.ObjectMeta.Name = name
+					chartmgr.ObjectMeta.Labels = map[string]string{"team": name}
+					if name == "redis" {
+						chartmgr.Spec.Chart.Name = "redis"
+					}
+					err := env.controller.chartmgrs.Add(chartmgr)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			got := []string{}
+			for _, chartmgr := range env.controller.profileChartMgrs(tt.profiles...) {
+				got = append(got, chartmgr.ObjectMeta.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("profileChartMgrs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateProfileFunc(t *testing.T) {
+	backend := fake.NewBackend()
+	env := newTestEnv(t, backend)
+	defer env.close()
+
+	chartmgr := env.chartmgr("app", "1.1.0")
+	chartmgr.ObjectMeta.Labels = map[string]string{"team": "web"}
+	oldProfile := newTestValuesProfile("web", teamSelector("ops"))
+	newProfile := newTestValuesProfile("web", teamSelector("web"))
+	env.client = clientfake.NewClient(chartmgr, newProfile)
+	env.controller.Interface = env.client
+	env.controller.chartmgrs = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	err := env.controller.chartmgrs.Add(chartmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changes to the metadata only don't reconcile
+	relabeled := oldProfile.DeepCopy()
+	relabeled.ObjectMeta.Labels = map[string]string{"owner": "ops"}
+	env.controller.updateProfileFunc(oldProfile, relabeled)
+
+	env.controller.updateProfileFunc(oldProfile, newProfile)
+	waitFor(t, func() bool {
+		latest, err := env.client.GetChartManager(testNamespace, "app")
+		return err == nil && len(latest.Status.AppliedProfiles) > 0
+	}, "reconciling the chart manager")
+
+	latest, err := env.client.GetChartManager(testNamespace, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(latest.Status.AppliedProfiles, []string{"web"}) {
+		t.Errorf("applied profiles = %v, want [web]", latest.Status.AppliedProfiles)
+	}
+	if calls := backend.Calls(); !reflect.DeepEqual(calls, []string{"get app", "install app", "get app"}) {
+		t.Errorf("backend calls = %v", calls)
+	}
+	revisions := backend.Revisions("app")
+	if len(revisions) != 1 || revisions[0].GetConfig().GetRaw() != "replicas: 2\n" {
+		t.Errorf("revisions = %v", revisions)
+	}
+}
+
+// waitFor fails the test if cond isn't true within a few seconds
+func waitFor(t *testing.T, cond func() bool, what string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s didn't happen", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	lmhelm "github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm"
+	log "github.com/sirupsen/logrus"
+)
+
+// retryOnReconnect schedules the reconcile to run again once the release
+// backend is reachable, if it failed because the backend couldn't be
+// reached. reconnected is the channel of the release backend taken before the
+// reconcile started. a later retry of the same chart manager replaces an
+// earlier one.
+func (c *Controller) retryOnReconnect(chartmgr *crv1alpha1.ChartManager, err error, reconnected <-chan struct{}, retry func()) {
+	if !lmhelm.IsConnectionError(err) {
+		return
+	}
+
+	key := chartMgrKey(chartmgr)
+	log.Infof("Chart Manager %s will be reconciled again when the release backend is reachable", key)
+	c.retryMu.Lock()
+	c.retries[key] = retry
+	c.retryMu.Unlock()
+
+	// the backend may have reconnected before the retry was scheduled
+	select {
+	case <-reconnected:
+		c.runRetries()
+	default:
+	}
+}
+
+// retryLatest returns a retry reconciling the latest version of the chart
+// manager
+func (c *Controller) retryLatest(chartmgr *crv1alpha1.ChartManager, reconcile func(*crv1alpha1.ChartManager)) func() {
+	namespace, name := chartmgr.ObjectMeta.Namespace, chartmgr.ObjectMeta.Name
+	return func() {
+		latest, err := c.GetChartManager(namespace, name)
+		if err != nil {
+			log.Warnf("Not retrying chart manager %s/%s: %v", namespace, name, err)
+			return
+		}
+		reconcile(latest)
+	}
+}
+
+// retryReconciles runs the scheduled retries whenever the release backend
+// reconnects
+func (c *Controller) retryReconciles(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.HelmClient.Reconnected():
+		}
+		c.runRetries()
+	}
+}
+
+func (c *Controller) runRetries() {
+	c.retryMu.Lock()
+	retries := c.retries
+	c.retries = map[string]func(){}
+	c.retryMu.Unlock()
+
+	for key, retry := range retries {
+		log.Infof("Retrying reconcile of chart manager %s", key)
+		go retry()
+	}
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+// syncSourcePoller starts polling the git, ConfigMap or path chart source of
+// the chart manager for changes, or stops polling if the chart manager no
+// longer has one
+func (c *Controller) syncSourcePoller(chartmgr *crv1alpha1.ChartManager) {
+	if !polledSource(chartmgr) {
+		c.stopSourcePoller(chartmgr)
+		return
+	}
+
+	c.sourceMu.Lock()
+	defer c.sourceMu.Unlock()
+
+	// the poller always reads the latest chart manager, so an existing one
+	// picks up spec changes by itself
+	key := chartMgrKey(chartmgr)
+	if _, ok := c.sourcePollers[key]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.sourcePollers[key] = cancel
+	go c.pollSource(ctx, chartmgr.ObjectMeta.Namespace, chartmgr.ObjectMeta.Name)
+}
+
+func (c *Controller) stopSourcePoller(chartmgr *crv1alpha1.ChartManager) {
+	c.sourceMu.Lock()
+	defer c.sourceMu.Unlock()
+
+	key := chartMgrKey(chartmgr)
+	if cancel, ok := c.sourcePollers[key]; ok {
+		cancel()
+		delete(c.sourcePollers, key)
+	}
+}
+
+func (c *Controller) pollSource(ctx context.Context, namespace string, name string) {
+	ticker := time.NewTicker(time.Duration(c.Config.SourcePollIntervalSec) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.checkSource(namespace, name)
+	}
+}
+
+// checkSource upgrades the release if the chart source of the chart manager
+// changed since the release was last installed
+func (c *Controller) checkSource(namespace string, name string) {
+	chartmgr, err := c.GetChartManager(namespace, name)
+	if err != nil {
+		log.Errorf("Failed to get chart manager %s/%s: %v", namespace, name, err)
+		return
+	}
+	if !polledSource(chartmgr) {
+		return
+	}
+
+	var revision, installed string
+	if chartmgr.Spec.Chart.Git != nil {
+		revision, err = c.HelmClient.GitCommit(chartmgr)
+		installed = chartmgr.Status.GitCommit
+	} else {
+		revision, err = c.HelmClient.ChartDigest(chartmgr)
+		installed = chartmgr.Status.ChartDigest
+	}
+	if err != nil {
+		log.Errorf("Failed to check chart source of chart manager %s: %v", chartmgr.Name, err)
+		return
+	}
+	if revision == installed {
+		log.Debugf("Chart Manager %s chart source is up to date at %s", chartmgr.Name, revision)
+		return
+	}
+
+	log.Infof("Chart Manager %s chart source changed to %s", chartmgr.Name, revision)
+	c.updateChartMgr(chartmgr)
+}
+
+func polledSource(chartmgr *crv1alpha1.ChartManager) bool {
+	ch := chartmgr.Spec.Chart
+	return ch != nil && (ch.Git != nil || ch.ConfigMapRef != nil || ch.Path != "")
+}
+
+func chartMgrKey(chartmgr *crv1alpha1.ChartManager) string {
+	return fmt.Sprintf("%s/%s", chartmgr.ObjectMeta.Namespace, chartmgr.ObjectMeta.Name)
+}
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm/fake"
+	"github.com/opencontainers/go-digest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+// setChartConfigMap serves the packaged app chart with the version in the
+// chart.tgz key of the charts ConfigMap and returns its digest
+func (e *testEnv) setChartConfigMap(t *testing.T, version string) string {
+	path, err := chartutil.Save(fake.NewChart("app", version, "replicas: 1\n"), e.home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.kubeAPI.Set("configmaps", testNamespace, "charts", map[string]interface{}{
+		"metadata":   map[string]string{"name": "charts", "namespace": testNamespace},
+		"binaryData": map[string][]byte{"chart.tgz": archive},
+	})
+	return digest.FromBytes(archive).String()
+}
+
+func TestCheckSource(t *testing.T) {
+	tests := []struct {
+		name         string
+		repository   bool
+		noConfigMap  bool
+		noChartMgr   bool
+		installed    string
+		wantCalls    []string
+		wantUpToDate bool
+	}{
+		{
+			name:         "up to date",
+			wantCalls:    []string{},
+			wantUpToDate: true,
+		},
+		{
+			name:      "changed",
+			installed: "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			wantCalls: []string{"get app", "install app", "get app"},
+		},
+		{
+			name:         "unreadable source",
+			noConfigMap:  true,
+			wantCalls:    []string{},
+			wantUpToDate: true,
+		},
+		{
+			name:         "repository chart",
+			repository:   true,
+			installed:    "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			wantCalls:    []string{},
+			wantUpToDate: true,
+		},
+		{
+			name:         "deleted chart manager",
+			noChartMgr:   true,
+			wantCalls:    []string{},
+			wantUpToDate: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := fake.NewBackend()
+			objects := []runtime.Object{}
+			if !tt.noChartMgr {
+				objects = append(objects, &crv1alpha1.ChartManager{
+					ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: testNamespace},
+				})
+			}
+			env := newTestEnv(t, backend, objects...)
+			defer env.close()
+
+			current := env.setChartConfigMap(t, "1.0.0")
+			if tt.noConfigMap {
+				env.kubeAPI.Delete("configmaps", testNamespace, "charts")
+			}
+			chartmgr := env.chartmgr("app", "")
+			if !tt.repository {
+				chartmgr.Spec.Chart = &crv1alpha1.ChartMgrChart{
+					ConfigMapRef: &crv1alpha1.ChartMgrConfigMapKeyRef{Name: "charts", Key: "chart.tgz"},
+				}
+			}
+			chartmgr.Status.ChartDigest = current
+			if tt.installed != "" {
+				chartmgr.Status.ChartDigest = tt.installed
+			}
+			if !tt.noChartMgr {
+				err := env.client.UpdateChartManager(chartmgr)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			env.controller.checkSource(testNamespace, "app")
+
+			if calls := backend.Calls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("backend calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if tt.noChartMgr {
+				return
+			}
+			updated, err := env.client.GetChartManager(testNamespace, "app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantUpToDate {
+				if updated.Status.ChartDigest != chartmgr.Status.ChartDigest {
+					t.Errorf("chart digest changed to %s", updated.Status.ChartDigest)
+				}
+				return
+			}
+			if updated.Status.ChartDigest != current {
+				t.Errorf("chart digest = %s, want %s", updated.Status.ChartDigest, current)
+			}
+		})
+	}
+}
+
+func TestSyncSourcePoller(t *testing.T) {
+	backend := fake.NewBackend()
+	env := newTestEnv(t, backend)
+	defer env.close()
+	env.controller.Config.SourcePollIntervalSec = 3600
+
+	polled := env.chartmgr("app", "")
+	polled.Spec.Chart = &crv1alpha1.ChartMgrChart{Path: "/charts/app"}
+	notPolled := env.chartmgr("app", "1.0.0")
+
+	tests := []struct {
+		name     string
+		chartmgr *crv1alpha1.ChartManager
+		want     bool
+	}{
+		{name: "starts polling", chartmgr: polled, want: true},
+		{name: "keeps polling", chartmgr: polled, want: true},
+		{name: "stops polling", chartmgr: notPolled, want: false},
+		{name: "doesn't poll", chartmgr: notPolled, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env.controller.syncSourcePoller(tt.chartmgr)
+			env.controller.sourceMu.Lock()
+			defer env.controller.sourceMu.Unlock()
+			if _, ok := env.controller.sourcePollers[chartMgrKey(tt.chartmgr)]; ok != tt.want {
+				t.Errorf("polling = %v, want %v", ok, tt.want)
+			}
+			if len(env.controller.sourcePollers) > 1 {
+				t.Errorf("%d pollers", len(env.controller.sourcePollers))
+			}
+		})
+	}
+}
+
+func TestRunInvalidSourcePollInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval int64
+		wantErr  string
+	}{
+		{name: "zero", interval: 0, wantErr: "Source poll interval must be positive, got 0"},
+		{name: "negative", interval: -1, wantErr: "Source poll interval must be positive, got -1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, fake.NewBackend())
+			defer env.close()
+			env.controller.Config.SourcePollIntervalSec = tt.interval
+
+			err := env.controller.Run(context.Background())
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Run() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
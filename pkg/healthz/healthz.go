@@ -14,3 +14,21 @@ func HandleFunc(w http.ResponseWriter, req *http.Request) {
 		log.Errorf("Failed to write healthz: %v", err)
 	}
 }
+
+// ReadyFunc returns an http handler function reporting readiness. the
+// controller is ready when the check returns no error.
+func ReadyFunc(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		msg := "ok"
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			msg = err.Error()
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		_, err := w.Write([]byte(msg))
+		if err != nil {
+			log.Errorf("Failed to write readiness: %v", err)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package lmhelm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/redact"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/getter"
+	helm_env "k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/repo"
+	"k8s.io/helm/pkg/tlsutil"
+	"k8s.io/helm/pkg/urlutil"
+)
+
+const (
+	// RepoSecretUsernameKey is the repository secret key holding the basic auth username
+	RepoSecretUsernameKey = "username"
+	// RepoSecretPasswordKey is the repository secret key holding the basic auth password
+	RepoSecretPasswordKey = "password"
+	// RepoSecretCertKey is the repository secret key holding the client certificate
+	RepoSecretCertKey = "tls.crt"
+	// RepoSecretKeyKey is the repository secret key holding the client certificate key
+	RepoSecretKeyKey = "tls.key"
+	// RepoSecretCAKey is the repository secret key holding the CA bundle
+	RepoSecretCAKey = "ca.crt"
+	// RepoSecretProxyUsernameKey is the repository secret key holding the proxy username
+	RepoSecretProxyUsernameKey = "proxy-username"
+	// RepoSecretProxyPasswordKey is the repository secret key holding the proxy password
+	RepoSecretProxyPasswordKey = "proxy-password"
+)
+
+// publicSecretKeys are the keys of credential secrets that hold no secret
+// material
+var publicSecretKeys = map[string]bool{
+	RepoSecretUsernameKey:      true,
+	RepoSecretCertKey:          true,
+	RepoSecretCAKey:            true,
+	RepoSecretProxyUsernameKey: true,
+	GitSecretKnownHostsKey:     true,
+}
+
+// redactSecret masks the secret material of a credential secret in logs
+func redactSecret(secret *v1.Secret) {
+	material := []string{}
+	for key, value := range secret.Data {
+		if !publicSecretKeys[key] {
+			material = append(material, string(value))
+		}
+	}
+	redact.Set(fmt.Sprintf("secret %s/%s", secret.ObjectMeta.Namespace, secret.ObjectMeta.Name), material)
+}
+
+// repoCredentials are the credentials of an authenticated chart repository.
+// the certificates are materialized to files because helm only accepts paths.
+type repoCredentials struct {
+	username           string
+	password           string
+	certFile           string
+	keyFile            string
+	caFile             string
+	insecureSkipVerify bool
+	serverName         string
+	dir                string
+	network            *networkConfig
+	// secretDigest identifies the credentials of the secret
+	secretDigest string
+	// source is where the credentials were loaded from, so they can be
+	// loaded again after the files were cleaned up
+	source repoSource
+	// repoURL is the URL of the repository the credentials are for. basic
+	// auth is only sent to its host.
+	repoURL string
+}
+
+// repoSource is where the credentials of a repository come from: a chart
+// repository, an inline repository of a chart manager in the namespace with
+// the url and optionally a secret, or neither for a default repository
+type repoSource struct {
+	repository *crv1alpha1.ChartRepository
+	namespace  string
+	secret     string
+	url        string
+}
+
+// visible returns true if chart managers in the namespace may use the
+// repository of the source by name or URL. inline repositories are private
+// to the namespace of their chart manager.
+func (s repoSource) visible(namespace string) bool {
+	return s.repository != nil || s.namespace == "" || s.namespace == namespace
+}
+
+// rank orders the sources of repositories sharing a name or URL: chart
+// repositories come first, then default repositories, then inline
+// repositories
+func (s repoSource) rank() int {
+	switch {
+	case s.repository != nil:
+		return 0
+	case s.namespace == "":
+		return 1
+	}
+	return 2
+}
+
+func loadRepoCredentials(r *Release) (*repoCredentials, error) {
+	return r.Client.loadSourceCredentials(releaseRepoSource(r))
+}
+
+// releaseRepoSource returns the source of the credentials of the release's
+// repository
+func releaseRepoSource(r *Release) repoSource {
+	if r.Repository != nil {
+		return repoSource{repository: r.Repository}
+	}
+	source := repoSource{namespace: r.Chartmgr.ObjectMeta.Namespace, url: parseRepoURL(r.Chartmgr)}
+	if ref := parseRepoSecretRef(r.Chartmgr); ref != nil {
+		source.secret = ref.Name
+	}
+	return source
+}
+
+// loadSourceCredentials loads the credentials of the source. they must be
+// cleaned up after use.
+func (c *Client) loadSourceCredentials(source repoSource) (*repoCredentials, error) {
+	switch {
+	case source.repository != nil:
+		return c.loadRepositoryCredentials(source.repository)
+	case source.secret != "":
+		creds, _, err := c.loadSecretCredentials(source.namespace, source.secret)
+		if err != nil {
+			return nil, err
+		}
+		creds.source = source
+		creds.repoURL = source.url
+		return creds, nil
+	}
+	creds := c.defaultCredentials()
+	creds.source = source
+	return creds, nil
+}
+
+// defaultCredentials returns the credentials of a public repository
+func (c *Client) defaultCredentials() *repoCredentials {
+	return &repoCredentials{network: c.network}
+}
+
+func (c *Client) loadRepositoryCredentials(repository *crv1alpha1.ChartRepository) (*repoCredentials, error) {
+	creds := c.defaultCredentials()
+	data := map[string][]byte{}
+	if repository.Spec.SecretRef != nil {
+		var err error
+		creds, data, err = c.loadSecretCredentials(repository.Spec.SecretRef.Namespace, repository.Spec.SecretRef.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	creds.source = repoSource{repository: repository}
+	creds.repoURL = repository.Spec.URL
+
+	if repository.Spec.TLS != nil {
+		creds.insecureSkipVerify = repository.Spec.TLS.InsecureSkipVerify
+		creds.serverName = repository.Spec.TLS.ServerName
+	}
+	if repository.Spec.Proxy != nil {
+		network, err := c.network.withProxy(
+			repository.Spec.Proxy,
+			string(data[RepoSecretProxyUsernameKey]),
+			string(data[RepoSecretProxyPasswordKey]),
+		)
+		if err != nil {
+			creds.cleanup()
+			return nil, err
+		}
+		creds.network = network
+	}
+	return creds, nil
+}
+
+// loadSecretCredentials returns the credentials of the secret along with its
+// data
+func (c *Client) loadSecretCredentials(namespace string, name string) (*repoCredentials, map[string][]byte, error) {
+	log.Debugf("Reading repository credentials from secret %s/%s", namespace, name)
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	redactSecret(secret)
+
+	creds := &repoCredentials{
+		username:     string(secret.Data[RepoSecretUsernameKey]),
+		password:     string(secret.Data[RepoSecretPasswordKey]),
+		network:      c.network,
+		secretDigest: credentialsDigest(secret.Data),
+	}
+	err = creds.writeFiles(secret.Data)
+	if err != nil {
+		creds.cleanup()
+		return nil, nil, err
+	}
+	return creds, secret.Data, nil
+}
+
+func (c *repoCredentials) writeFiles(data map[string][]byte) error {
+	if len(data[RepoSecretCertKey]) < 1 && len(data[RepoSecretKeyKey]) < 1 && len(data[RepoSecretCAKey]) < 1 {
+		return nil
+	}
+
+	// the directory is only accessible by the controller user
+	dir, err := ioutil.TempDir("", "chartmgr-repo-")
+	if err != nil {
+		return err
+	}
+	c.dir = dir
+
+	files := map[string]*string{
+		RepoSecretCertKey: &c.certFile,
+		RepoSecretKeyKey:  &c.keyFile,
+		RepoSecretCAKey:   &c.caFile,
+	}
+	for key, path := range files {
+		if len(data[key]) < 1 {
+			continue
+		}
+		*path = filepath.Join(dir, key)
+		err = ioutil.WriteFile(*path, data[key], 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// identity returns a digest identifying the credentials, for keys of data
+// that must only be shared by requests with the same credentials
+func (c *repoCredentials) identity() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%t\x00%s", c.secretDigest, c.insecureSkipVerify, c.serverName) // nolint: errcheck
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// credentialsDigest returns a digest of the credentials in the secret data
+func credentialsDigest(data map[string][]byte) string {
+	h := sha256.New()
+	for _, key := range []string{RepoSecretUsernameKey, RepoSecretPasswordKey, RepoSecretCertKey, RepoSecretKeyKey, RepoSecretCAKey} {
+		// length prefixes keep the values from running into each other
+		fmt.Fprintf(h, "%d:", len(data[key])) // nolint: errcheck
+		h.Write(data[key])                    // nolint: errcheck
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *repoCredentials) cleanup() {
+	if c == nil || c.dir == "" {
+		return
+	}
+	err := os.RemoveAll(c.dir)
+	if err != nil {
+		log.Warnf("Failed to remove repository credentials %s: %v", c.dir, err)
+	}
+}
+
+// entry returns the helm repository entry of the repository with the
+// certificate paths of the credentials. the entry must not outlive the
+// credentials, since their files are removed by cleanup.
+func (c *repoCredentials) entry(name string, cache string, url string) *repo.Entry {
+	return &repo.Entry{
+		Name:     name,
+		Cache:    cache,
+		URL:      url,
+		CertFile: c.certFile,
+		KeyFile:  c.keyFile,
+		CAFile:   c.caFile,
+	}
+}
+
+// withFiles returns the credentials with the certificate paths helm passes
+// to a getter from a repository entry, keeping those of the credentials for
+// the paths helm leaves empty
+func (c *repoCredentials) withFiles(certFile string, keyFile string, caFile string) *repoCredentials {
+	creds := *c
+	if certFile != "" {
+		creds.certFile = certFile
+	}
+	if keyFile != "" {
+		creds.keyFile = keyFile
+	}
+	if caFile != "" {
+		creds.caFile = caFile
+	}
+	return &creds
+}
+
+// getters returns the helm getters with the http getter replaced by one that
+// authenticates with the repository credentials. helm 2.7 repository entries
+// only carry certificate paths, so basic auth is added by the getter.
+func (c *repoCredentials) getters(settings helm_env.EnvSettings) getter.Providers {
+	providers := getter.Providers{
+		{
+			Schemes: []string{"http", "https"},
+			New: func(URL, CertFile, KeyFile, CAFile string) (getter.Getter, error) {
+				return newAuthHTTPGetter(URL, c.withFiles(CertFile, KeyFile, CAFile))
+			},
+		},
+	}
+	for _, p := range getter.All(settings) {
+		if !p.Provides("http") && !p.Provides("https") {
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+// repoRequestTimeout limits the time of a request to a repository, including
+// reading the body
+const repoRequestTimeout = 5 * time.Minute
+
+// authHTTPGetter is an http getter supporting basic auth and client
+// certificates. basic auth is only sent to the host of the repository, since
+// indexes may list charts on other hosts.
+type authHTTPGetter struct {
+	client   *http.Client
+	username string
+	password string
+	host     string
+}
+
+// Get performs a Get from repo.Getter and returns the body.
+func (g *authHTTPGetter) Get(href string) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(nil)
+
+	req, err := http.NewRequest("GET", href, nil)
+	if err != nil {
+		return buf, err
+	}
+	if (g.username != "" || g.password != "") && g.host != "" && req.URL.Host == g.host {
+		req.SetBasicAuth(g.username, g.password)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return buf, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return buf, fmt.Errorf("Failed to fetch %s : %s", href, resp.Status)
+	}
+
+	_, err = io.Copy(buf, resp.Body)
+	return buf, err
+}
+
+func newAuthHTTPGetter(URL string, creds *repoCredentials) (getter.Getter, error) {
+	g := &authHTTPGetter{
+		client:   &http.Client{Timeout: repoRequestTimeout},
+		username: creds.username,
+		password: creds.password,
+	}
+	if creds.repoURL != "" {
+		u, err := url.Parse(creds.repoURL)
+		if err != nil {
+			return nil, err
+		}
+		g.host = u.Host
+	}
+	if !creds.tls() && creds.network == nil {
+		return g, nil
+	}
+
+	transport := creds.network.transport()
+	tlsConf, err := clientTLS(URL, creds)
+	if err != nil {
+		return nil, fmt.Errorf("can't create TLS config for client: %s", err.Error())
+	}
+	transport.TLSClientConfig = tlsConf
+	g.client = &http.Client{Transport: transport, Timeout: repoRequestTimeout}
+	return g, nil
+}
+
+func (c *repoCredentials) tls() bool {
+	return c.certFile != "" || c.keyFile != "" || c.caFile != "" || c.insecureSkipVerify || c.serverName != ""
+}
+
+func clientTLS(URL string, creds *repoCredentials) (*tls.Config, error) {
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: creds.insecureSkipVerify, // nolint: gas
+	}
+	if creds.certFile != "" && creds.keyFile != "" {
+		cert, err := tlsutil.CertFromFilePair(creds.certFile, creds.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates = []tls.Certificate{*cert}
+		tlsConf.BuildNameToCertificate()
+	}
+	pool, err := creds.network.certPool(creds.caFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf.RootCAs = pool
+
+	tlsConf.ServerName = creds.serverName
+	if tlsConf.ServerName == "" {
+		sni, err := urlutil.ExtractHostname(URL)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.ServerName = sni
+	}
+	return tlsConf, nil
+}
+
+func parseRepoSecretRef(chartmgr *crv1alpha1.ChartManager) *crv1alpha1.ChartMgrRepositorySecretRef {
+	if chartmgr.Spec.Chart.Repository == nil {
+		return nil
+	}
+	return chartmgr.Spec.Chart.Repository.SecretRef
+}
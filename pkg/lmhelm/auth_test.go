@@ -0,0 +1,151 @@
+package lmhelm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/getter"
+	helm_env "k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/helm/helmpath"
+	"k8s.io/helm/pkg/repo"
+)
+
+func TestAuthHTTPGetterBasicAuth(t *testing.T) {
+	var repoAuth, otherAuth string
+	repoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repoAuth = r.Header.Get("Authorization")
+	}))
+	defer repoServer.Close()
+	otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherAuth = r.Header.Get("Authorization")
+	}))
+	defer otherServer.Close()
+
+	tests := []struct {
+		name     string
+		href     string
+		auth     *string
+		wantAuth bool
+	}{
+		{
+			name:     "authenticates to the repository",
+			href:     repoServer.URL + "/index.yaml",
+			auth:     &repoAuth,
+			wantAuth: true,
+		},
+		{
+			name: "doesn't authenticate to other hosts",
+			href: otherServer.URL + "/charts/app-1.0.0.tgz",
+			auth: &otherAuth,
+		},
+	}
+
+	creds := &repoCredentials{username: "user", password: "secret", repoURL: repoServer.URL + "/charts"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := newAuthHTTPGetter(tt.href, creds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = g.Get(tt.href)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (*tt.auth != "") != tt.wantAuth {
+				t.Errorf("Authorization = %q, want auth %t", *tt.auth, tt.wantAuth)
+			}
+		})
+	}
+
+	if g, _ := newAuthHTTPGetter(repoServer.URL, creds); g.(*authHTTPGetter).client.Timeout == 0 {
+		t.Errorf("getter client has no timeout")
+	}
+}
+
+func TestRepoCredentialsEntryCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "localhost")
+	clientCert, clientKey := ca.issue(t, "chartmgr")
+	pair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("apiVersion: v1\nentries: {}\n")) // nolint: errcheck
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	// the refused handshake is expected
+	server.Config.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	u := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	dir, err := ioutil.TempDir("", "chartmgr-auth-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	creds := &repoCredentials{repoURL: u}
+	err = creds.writeFiles(map[string][]byte{
+		RepoSecretCertKey: clientCert,
+		RepoSecretKeyKey:  clientKey,
+		RepoSecretCAKey:   ca.pem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer creds.cleanup()
+	settings := helm_env.EnvSettings{Home: helmpath.Home(dir)}
+	cache := filepath.Join(dir, "index.yaml")
+
+	tests := []struct {
+		name    string
+		entry   *repo.Entry
+		getters getter.Providers
+		wantErr bool
+	}{
+		{
+			// helm passes the certificate paths of the entry to the getter
+			name:    "entry certificates",
+			entry:   creds.entry("private", cache, u),
+			getters: (&repoCredentials{repoURL: u}).getters(settings),
+		},
+		{
+			name:    "credentials certificates",
+			entry:   &repo.Entry{Name: "private", Cache: cache, URL: u},
+			getters: creds.getters(settings),
+		},
+		{
+			name:    "no certificates",
+			entry:   &repo.Entry{Name: "private", Cache: cache, URL: u},
+			getters: (&repoCredentials{repoURL: u}).getters(settings),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr, err := repo.NewChartRepository(tt.entry, tt.getters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cr.DownloadIndexFile("")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DownloadIndexFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package lmhelm
+
+import (
+	"fmt"
+	"sort"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const (
+	// ReleaseBackendTiller manages releases through Tiller
+	ReleaseBackendTiller = "tiller"
+	// ReleaseBackendSecrets manages releases without Tiller and stores them
+	// in Secrets in the format of the Helm 3 Secrets storage driver
+	ReleaseBackendSecrets = "secrets"
+
+	// ManagedByAnnotation is the chart annotation marking the releases the
+	// controller installed with the chart manager that installed them
+	ManagedByAnnotation = "chartmgr.logicmonitor.com/managed-by"
+)
+
+// ReleaseBackend manages the helm releases of chart managers. releases are
+// represented by the hapi release type whatever the backend, so the chart
+// manager semantics don't depend on it.
+type ReleaseBackend interface {
+	// Install installs the chart as the release
+	Install(r *Release, chart *chart.Chart, vals []byte) (*rspb.Release, error)
+	// Upgrade upgrades the release to the chart and values
+	Upgrade(r *Release, chart *chart.Chart, vals []byte) (*rspb.Release, error)
+	// Delete deletes and purges the release
+	Delete(r *Release) (*rspb.Release, error)
+	// Get returns the current revision of the release with exactly the
+	// release's name, or a *ReleaseNotFoundError if it doesn't exist
+	Get(r *Release) (*rspb.Release, error)
+	// History returns up to max revisions of the release, newest first
+	History(r *Release, max int32) ([]*rspb.Release, error)
+	// Rollback rolls the release back to the revision
+	Rollback(r *Release, revision int32) (*rspb.Release, error)
+	// Test runs the tests of the release and returns their messages. an error
+	// is returned if a test failed.
+	Test(r *Release) ([]string, error)
+	// List returns the current revisions of all releases whose names start
+	// with the prefix, including releases deleted without purging
+	List(prefix string) ([]*rspb.Release, error)
+}
+
+// ReleaseNotFoundError is returned by release lookups when the release
+// doesn't exist
+type ReleaseNotFoundError struct {
+	Name string
+}
+
+func (e *ReleaseNotFoundError) Error() string {
+	return fmt.Sprintf("Release %s not found", e.Name)
+}
+
+// IsReleaseNotFound returns true if the error is a ReleaseNotFoundError
+func IsReleaseNotFound(err error) bool {
+	_, ok := err.(*ReleaseNotFoundError)
+	return ok
+}
+
+// connectedBackend is implemented by release backends that connect to a
+// server, to report whether the server is reachable
+type connectedBackend interface {
+	// Ready returns an error if the server isn't reachable
+	Ready() error
+	// Reconnected returns a channel that is closed once the server is
+	// reachable again
+	Reconnected() <-chan struct{}
+}
+
+// Ready returns an error if the default release backend isn't reachable. the
+// tillers chart managers select are excluded, so that an unreachable tiller
+// of one namespace doesn't take the controller out of service. their failures
+// are reported in the status of the chart managers selecting them.
+func (c *Client) Ready() error {
+	if b, ok := c.backend.(connectedBackend); ok {
+		return b.Ready()
+	}
+	return nil
+}
+
+// Reconnected returns a channel that is closed once the release backend, or
+// any of the tillers chart managers selected, is reachable again after a
+// connection failure. it is never closed for backends without a connection.
+func (c *Client) Reconnected() <-chan struct{} {
+	if b, ok := c.backend.(connectedBackend); ok {
+		return b.Reconnected()
+	}
+	return nil
+}
+
+// ManagedRelease is a release the controller installed, as listed by
+// ManagedReleases
+type ManagedRelease struct {
+	*rspb.Release
+	// Tiller is the tiller storing the release, or empty for the default
+	// release backend
+	Tiller  string
+	backend ReleaseBackend
+}
+
+func (m *ManagedRelease) String() string {
+	if m.Tiller == "" {
+		return m.Name
+	}
+	return fmt.Sprintf("%s (tiller %s)", m.Name, m.Tiller)
+}
+
+// ManagedReleases returns the releases marked as installed by the controller
+// in the default release backend and the tillers chart managers selected.
+// tillers that fail to list their releases are skipped.
+func (c *Client) ManagedReleases() ([]*ManagedRelease, error) {
+	return managedReleases(c.releaseBackends())
+}
+
+func managedReleases(backends map[string]ReleaseBackend) ([]*ManagedRelease, error) {
+	tillers := []string{}
+	for tiller := range backends {
+		tillers = append(tillers, tiller)
+	}
+	sort.Strings(tillers)
+
+	managed := []*ManagedRelease{}
+	for _, tiller := range tillers {
+		releases, err := backends[tiller].List("")
+		if err != nil && tiller == "" {
+			return nil, err
+		}
+		if err != nil {
+			log.Warnf("Failed to list the releases of tiller %s: %v", tiller, err)
+			continue
+		}
+		for _, rls := range releases {
+			if rls.GetChart().GetMetadata().GetAnnotations()[ManagedByAnnotation] == "" {
+				continue
+			}
+			managed = append(managed, &ManagedRelease{Release: rls, Tiller: tiller, backend: backends[tiller]})
+		}
+	}
+	return managed, nil
+}
+
+// PurgeRelease deletes and purges a managed release that no chart manager
+// manages
+func (c *Client) PurgeRelease(rls *ManagedRelease) error {
+	r := &Release{
+		Client: c,
+		// backends storing releases in their namespace require it
+		Chartmgr: &crv1alpha1.ChartManager{
+			ObjectMeta: metav1.ObjectMeta{Namespace: rls.Namespace},
+		},
+		name: rls.Name,
+	}
+	_, err := rls.backend.Delete(r)
+	return err
+}
+
+// releaseBackends returns the default release backend and the backends of
+// the tillers chart managers selected, by the tiller they connect to
+func (c *Client) releaseBackends() map[string]ReleaseBackend {
+	if c.tillers == nil {
+		return map[string]ReleaseBackend{"": c.backend}
+	}
+	return c.tillers.all()
+}
+
+// markManaged returns a copy of the chart annotated as installed by the
+// chart manager, which the release stores with the chart
+func markManaged(r *Release, ch *chart.Chart) *chart.Chart {
+	marked := *ch
+	metadata := chart.Metadata{}
+	if ch.Metadata != nil {
+		metadata = *ch.Metadata
+	}
+	metadata.Annotations = map[string]string{}
+	for k, v := range ch.GetMetadata().GetAnnotations() {
+		metadata.Annotations[k] = v
+	}
+	metadata.Annotations[ManagedByAnnotation] = fmt.Sprintf("%s/%s", r.Chartmgr.ObjectMeta.Namespace, r.Chartmgr.ObjectMeta.Name)
+	marked.Metadata = &metadata
+	return &marked
+}
+
+// releaseBackend returns the backend managing the release of the chart
+// manager
+func (c *Client) releaseBackend(chartmgr *crv1alpha1.ChartManager) (ReleaseBackend, error) {
+	if chartmgr.Spec.Tiller == nil {
+		return c.backend, nil
+	}
+	if c.tillers == nil {
+		return nil, fmt.Errorf("Selecting a tiller requires the %s release backend", ReleaseBackendTiller)
+	}
+	return c.tillers.selected(chartmgr)
+}
+
+// newReleaseBackend returns the release backend selected in the config
+func (c *Client) newReleaseBackend() (ReleaseBackend, error) {
+	switch c.chartmgrconfig.ReleaseBackend {
+	case ReleaseBackendTiller:
+		return c.newTillerBackend()
+	case ReleaseBackendSecrets:
+		return c.newStorageBackend()
+	default:
+		return nil, fmt.Errorf("Unknown release backend %q. Supported backends: %s, %s", c.chartmgrconfig.ReleaseBackend, ReleaseBackendTiller, ReleaseBackendSecrets)
+	}
+}
@@ -0,0 +1,84 @@
+package lmhelm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+)
+
+func TestManagedReleases(t *testing.T) {
+	r, b, _, _, cleanup := newTestStorageBackend(t)
+	defer cleanup()
+	_, pooled, _, pooledAPI, pooledCleanup := newTestStorageBackend(t)
+	defer pooledCleanup()
+	_, unreachable, _, unreachableAPI, unreachableCleanup := newTestStorageBackend(t)
+	defer unreachableCleanup()
+	unreachableAPI.Close()
+
+	installs := []struct {
+		backend *storageBackend
+		name    string
+		marked  bool
+	}{
+		{backend: b, name: "chartmgr-rls-marked", marked: true},
+		{backend: b, name: "chartmgr-rls-unmarked"},
+		{backend: b, name: "custom", marked: true},
+		{backend: pooled, name: "pooled", marked: true},
+	}
+	for _, install := range installs {
+		ch := newTestStorageChart()
+		if install.marked {
+			ch = markManaged(r, ch)
+		}
+		_, err := install.backend.Install(r.WithName(install.name), ch, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	releases, err := managedReleases(map[string]ReleaseBackend{"": b, "pooled": pooled, "unreachable": unreachable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, rls := range releases {
+		names = append(names, rls.String())
+		if got := rls.Chart.Metadata.Annotations[ManagedByAnnotation]; got != "tenant/app" {
+			t.Errorf("release %s is managed by %q", rls, got)
+		}
+	}
+	if got, want := strings.Join(names, ","), "chartmgr-rls-marked,custom,pooled (tiller pooled)"; got != want {
+		t.Errorf("managed releases = %s, want %s", got, want)
+	}
+
+	// the purge deletes from the backend storing the release
+	err = r.Client.PurgeRelease(releases[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets := pooledAPI.Objects("secrets", "tenant"); len(secrets) != 0 {
+		t.Errorf("pooled backend has %d release secrets left", len(secrets))
+	}
+
+	// the default backend failing fails the listing
+	_, err = managedReleases(map[string]ReleaseBackend{"": unreachable})
+	if err == nil {
+		t.Error("managedReleases() succeeded with an unreachable default backend")
+	}
+}
+
+func TestMarkManagedCopiesChart(t *testing.T) {
+	r, _, cleanup := newTestRelease(t, &config.Config{})
+	defer cleanup()
+	ch := newTestChart()
+	ch.Metadata.Annotations = map[string]string{"team": "infra"}
+
+	marked := markManaged(r, ch)
+	if marked.Metadata.Annotations[ManagedByAnnotation] != "tenant/app" || marked.Metadata.Annotations["team"] != "infra" {
+		t.Errorf("annotations = %v", marked.Metadata.Annotations)
+	}
+	if _, ok := ch.Metadata.Annotations[ManagedByAnnotation]; ok {
+		t.Error("the chart was modified")
+	}
+}
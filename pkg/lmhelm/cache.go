@@ -0,0 +1,222 @@
+package lmhelm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/metrics"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// chartCache stores packaged charts by the digest of their content. charts
+// are looked up by a key identifying the chart version in its repository,
+// and concurrent lookups of the same key share a single download.
+type chartCache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	keys    map[string]digest.Digest
+	blobs   map[digest.Digest]*cachedChart
+	calls   map[string]*cacheCall
+}
+
+type cachedChart struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// cacheCall is a download in progress that other lookups of the same key wait on
+type cacheCall struct {
+	wg      sync.WaitGroup
+	archive []byte
+	err     error
+}
+
+// chartRef identifies a chart package in a repository. the url is only set
+// if the repository index was consulted to identify the chart, and the
+// digest if the index lists it or the chart is pinned to it. creds is the
+// identity of the credentials the chart is downloaded with, so charts are
+// only served from the cache to the credentials that downloaded them.
+type chartRef struct {
+	repoURL string
+	name    string
+	version string
+	url     string
+	digest  string
+	creds   string
+}
+
+func (c *chartRef) key() string {
+	return strings.Join([]string{c.repoURL, c.name, c.version, c.digest, c.creds}, " ")
+}
+
+func (c *chartRef) String() string {
+	return fmt.Sprintf("%s %s-%s", c.repoURL, c.name, c.version)
+}
+
+// newChartCache returns a cache in dir, adopting the charts cached there by
+// earlier runs of the controller
+func newChartCache(dir string, maxSize int64, maxAge time.Duration) (*chartCache, error) {
+	c := &chartCache{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		keys:    map[string]digest.Digest{},
+		blobs:   map[digest.Digest]*cachedChart{},
+		calls:   map[string]*cacheCall{},
+	}
+
+	blobDir := filepath.Join(dir, digest.Canonical.String())
+	err := os.MkdirAll(blobDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(blobDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		d := digest.NewDigestFromEncoded(digest.Canonical, strings.TrimSuffix(f.Name(), ".tgz"))
+		if f.IsDir() || d.Validate() != nil {
+			continue
+		}
+		c.blobs[d] = &cachedChart{size: f.Size(), lastUsed: f.ModTime()}
+	}
+	log.Debugf("Adopted %d cached charts from %s", len(c.blobs), blobDir)
+	return c, nil
+}
+
+// get returns the packaged chart, calling download to fetch it into a
+// temporary directory if it is not cached
+func (c *chartCache) get(ref *chartRef, download func(dir string) (string, error)) ([]byte, error) {
+	key := ref.key()
+	c.mu.Lock()
+	c.evict()
+	if d, ok := c.keys[key]; ok {
+		archive, err := ioutil.ReadFile(c.blobPath(d))
+		if err == nil {
+			c.blobs[d].lastUsed = time.Now()
+			c.mu.Unlock()
+			log.Debugf("Chart cache hit for %s", ref)
+			metrics.ChartCacheHit()
+			return archive, nil
+		}
+		// the file is gone, e.g. removed by hand
+		delete(c.keys, key)
+	}
+
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		log.Debugf("Waiting for download of %s", ref)
+		call.wg.Wait()
+		return call.archive, call.err
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	log.Debugf("Chart cache miss for %s", ref)
+	metrics.ChartCacheMiss()
+	call.archive, call.err = c.fetch(ref, download)
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	call.wg.Done()
+	return call.archive, call.err
+}
+
+func (c *chartCache) fetch(ref *chartRef, download func(dir string) (string, error)) ([]byte, error) {
+	tmp, err := ioutil.TempDir(c.dir, ".download-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp) // nolint: errcheck
+
+	filename, err := download(tmp)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	d := digest.FromBytes(archive)
+	if ref.digest != "" && ref.digest != d.Encoded() {
+		return nil, fmt.Errorf("Digest of %s is %s but %s was expected", ref, d.Encoded(), ref.digest)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.blobs[d]; !ok {
+		err = os.Rename(filename, c.blobPath(d))
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.keys[ref.key()] = d
+	c.blobs[d] = &cachedChart{size: int64(len(archive)), lastUsed: time.Now()}
+	c.evict()
+	return archive, nil
+}
+
+// evict removes the charts unused for longer than the max age, then the least
+// recently used charts until the cache fits the max size. c.mu must be held.
+func (c *chartCache) evict() {
+	now := time.Now()
+	var size int64
+	for d, b := range c.blobs {
+		if c.maxAge > 0 && now.Sub(b.lastUsed) > c.maxAge {
+			c.remove(d)
+			continue
+		}
+		size += b.size
+	}
+	if c.maxSize <= 0 || size <= c.maxSize {
+		return
+	}
+
+	lru := make([]digest.Digest, 0, len(c.blobs))
+	for d := range c.blobs {
+		lru = append(lru, d)
+	}
+	sort.Slice(lru, func(i, j int) bool {
+		return c.blobs[lru[i]].lastUsed.Before(c.blobs[lru[j]].lastUsed)
+	})
+	for _, d := range lru {
+		if size <= c.maxSize {
+			return
+		}
+		size -= c.blobs[d].size
+		c.remove(d)
+	}
+}
+
+func (c *chartCache) remove(d digest.Digest) {
+	log.Debugf("Evicting chart %s from cache", d)
+	err := os.Remove(c.blobPath(d))
+	if err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to evict chart %s: %v", d, err)
+	}
+	delete(c.blobs, d)
+	for key, kd := range c.keys {
+		if kd == d {
+			delete(c.keys, key)
+		}
+	}
+	metrics.ChartCacheEviction()
+}
+
+func (c *chartCache) blobPath(d digest.Digest) string {
+	return filepath.Join(c.dir, d.Algorithm().String(), d.Encoded()+".tgz")
+}
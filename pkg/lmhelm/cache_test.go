@@ -0,0 +1,88 @@
+package lmhelm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestChartCacheKeys(t *testing.T) {
+	archive := []byte("chart")
+	encoded := digest.FromBytes(archive).Encoded()
+	base := chartRef{repoURL: "https://charts.example.com", name: "app", version: "1.0.0", creds: "tenant-a"}
+	tests := []struct {
+		name          string
+		ref           func(chartRef) chartRef
+		wantDownloads int
+		wantErr       bool
+	}{
+		{
+			name:          "serves the same credentials from the cache",
+			ref:           func(ref chartRef) chartRef { return ref },
+			wantDownloads: 1,
+		},
+		{
+			name: "downloads again with other credentials",
+			ref: func(ref chartRef) chartRef {
+				ref.creds = "tenant-b"
+				return ref
+			},
+			wantDownloads: 2,
+		},
+		{
+			name: "downloads again for a pinned digest",
+			ref: func(ref chartRef) chartRef {
+				ref.digest = encoded
+				return ref
+			},
+			wantDownloads: 2,
+		},
+		{
+			name: "refuses a download not matching the pinned digest",
+			ref: func(ref chartRef) chartRef {
+				ref.digest = digest.FromString("other").Encoded()
+				return ref
+			},
+			wantDownloads: 2,
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "chartmgr-cache-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir) // nolint: errcheck
+			cache, err := newChartCache(dir, 0, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			downloads := 0
+			download := func(tmp string) (string, error) {
+				downloads++
+				filename := filepath.Join(tmp, "app-1.0.0.tgz")
+				return filename, ioutil.WriteFile(filename, archive, 0644)
+			}
+			first := base
+			_, err = cache.get(&first, download)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second := tt.ref(base)
+			_, err = cache.get(&second, download)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if downloads != tt.wantDownloads {
+				t.Errorf("downloads = %d, want %d", downloads, tt.wantDownloads)
+			}
+		})
+	}
+}
@@ -1,82 +1,269 @@
 package lmhelm
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
-	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/constants"
+	"github.com/opencontainers/go-digest"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/helm/pkg/chartutil"
 	"k8s.io/helm/pkg/downloader"
-	"k8s.io/helm/pkg/getter"
 	helm_env "k8s.io/helm/pkg/helm/environment"
 	"k8s.io/helm/pkg/proto/hapi/chart"
 	"k8s.io/helm/pkg/repo"
 )
 
-func getChart(chartmgr *crv1alpha1.ChartManager, settings helm_env.EnvSettings) (*chart.Chart, error) {
+// exactVersionPattern matches chart versions that are not ranges
+var exactVersionPattern = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+([-+].*)?$`)
+
+func getChart(r *Release) (*chart.Chart, error) {
+	helmChart, err := getSourceChart(r)
+	if err != nil {
+		return nil, err
+	}
+	return resolveDependencies(r, helmChart)
+}
+
+// getSourceChart loads the chart from the chart manager's chart source
+func getSourceChart(r *Release) (*chart.Chart, error) {
+	chartmgr := r.Chartmgr
+	settings := r.Client.HelmSettings()
 	err := ensureDirectories(settings.Home)
 	if err != nil {
 		return nil, err
 	}
 
-	url, err := getRepo(chartmgr, settings)
+	err = checkChartSource(chartmgr)
+	if err != nil {
+		return nil, err
+	}
+
+	r.provenance = nil
+	r.chartDigest = ""
+	switch {
+	case chartmgr.Spec.Chart.Git != nil, chartmgr.Spec.Chart.OCI != nil, chartmgr.Spec.Chart.ConfigMapRef != nil, chartmgr.Spec.Chart.Path != "":
+		err = checkProvenanceSupported(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case chartmgr.Spec.Chart.Git != nil:
+		helmChart, commit, err := getGitChart(r)
+		if err != nil {
+			return nil, err
+		}
+		r.gitCommit = commit
+		return helmChart, nil
+	case chartmgr.Spec.Chart.OCI != nil:
+		return getOCIChart(r)
+	case chartmgr.Spec.Chart.ConfigMapRef != nil || chartmgr.Spec.Chart.Path != "":
+		helmChart, localDigest, err := getLocalChart(r)
+		if err != nil {
+			return nil, err
+		}
+		r.chartDigest = localDigest
+		err = checkChartDigest(r)
+		if err != nil {
+			return nil, err
+		}
+		return helmChart, nil
+	}
+
+	creds, err := loadRepoCredentials(r)
 	if err != nil {
 		return nil, err
 	}
+	defer creds.cleanup()
 
-	chartFile, err := writeChart(chartmgr, url, settings)
+	ref, err := resolveChart(r, creds)
 	if err != nil {
 		return nil, err
 	}
 
-	helmChart, err := loadChart(chartFile)
+	archive, err := r.Client.charts.get(ref, func(dir string) (string, error) {
+		chartURL := ref.url
+		if chartURL == "" {
+			found, err := findChart(r, creds)
+			if err != nil {
+				return "", err
+			}
+			chartURL = found.url
+		}
+		return downloadChart(chartURL, ref.version, creds, settings, dir)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.chartDigest = digest.FromBytes(archive).String()
+	err = checkChartDigest(r)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyChart(r, ref, archive, creds, settings)
 	if err != nil {
 		return nil, err
 	}
-	return helmChart, nil
+	return loadChartArchive(archive)
 }
 
-func getRepo(chartmgr *crv1alpha1.ChartManager, settings helm_env.EnvSettings) (string, error) {
+// resolveChart identifies the chart version to install. exact versions of
+// charts from inline repositories are identified without looking at the
+// repository index, so cached charts need no requests to the repository.
+// they are identified by the pinned digest if there is one, so a version
+// published again under a new digest isn't served from the cache.
+func resolveChart(r *Release, creds *repoCredentials) (*chartRef, error) {
+	chartmgr := r.Chartmgr
 	url := parseRepoURL(chartmgr)
-	if url == "" {
-		return constants.HelmStableRepoURL, nil
+	version := parseVersion(chartmgr)
+	pinned := pinnedDigest(chartmgr)
+	if r.Repository == nil && url != "" && exactVersionPattern.MatchString(version) {
+		return &chartRef{
+			repoURL: url,
+			name:    chartmgr.Spec.Chart.Name,
+			version: version,
+			digest:  strings.TrimPrefix(pinned, "sha256:"),
+			creds:   creds.identity(),
+		}, nil
 	}
 
-	repoName := parseRepoName(chartmgr)
-	err := addRepo(repoName, url, settings)
+	ref, err := findChart(r, creds)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if pinned != "" && ref.digest != "" && "sha256:"+ref.digest != pinned {
+		return nil, fmt.Errorf("Refusing chart %s: the repository index lists digest sha256:%s but the chart is pinned to %s", ref, ref.digest, pinned)
+	}
+	ref.creds = creds.identity()
+	return ref, nil
+}
+
+// checkChartDigest returns an error if the chart is pinned to a digest other
+// than the digest of the chart loaded
+func checkChartDigest(r *Release) error {
+	pinned := pinnedDigest(r.Chartmgr)
+	if pinned == "" || pinned == r.chartDigest {
+		return nil
+	}
+	return fmt.Errorf("Refusing chart %s: its digest is %s but the chart is pinned to %s", r.Chartmgr.Spec.Chart.Name, r.chartDigest, pinned)
+}
+
+// pinnedDigest returns the digest the chart is pinned to, in the form
+// sha256:<hex>
+func pinnedDigest(chartmgr *crv1alpha1.ChartManager) string {
+	d := chartmgr.Spec.Chart.Digest
+	if d == "" || strings.HasPrefix(d, "sha256:") {
+		return d
+	}
+	return "sha256:" + d
+}
+
+// checkChartSource returns an error if the chart has more than one source
+func checkChartSource(chartmgr *crv1alpha1.ChartManager) error {
+	sources := 0
+	if chartmgr.Spec.Chart.Repository != nil || chartmgr.Spec.Chart.RepositoryRef != nil {
+		sources++
+	}
+	if chartmgr.Spec.Chart.Git != nil {
+		sources++
+	}
+	if chartmgr.Spec.Chart.OCI != nil {
+		sources++
+	}
+	if chartmgr.Spec.Chart.ConfigMapRef != nil {
+		sources++
+	}
+	if chartmgr.Spec.Chart.Path != "" {
+		sources++
+	}
+	if sources > 1 {
+		return errors.New("Chart repository, git, oci, configMapRef and path sources are mutually exclusive")
+	}
+	if chartmgr.Spec.Chart.Digest != "" && (chartmgr.Spec.Chart.Git != nil || chartmgr.Spec.Chart.OCI != nil) {
+		return errors.New("Chart digest is not supported for git and oci sources. Pin git sources with a commit and oci sources with the oci digest")
 	}
-	return url, nil
+	return nil
 }
 
-func writeChart(chartmgr *crv1alpha1.ChartManager, url string, settings helm_env.EnvSettings) (string, error) {
+// findChart looks up the chart version and its URL in the index of the
+// chart manager's repository. charts without a repository are looked up in
+// the default repositories in order.
+func findChart(r *Release, creds *repoCredentials) (*chartRef, error) {
+	chartmgr := r.Chartmgr
 	name := chartmgr.Spec.Chart.Name
 	version := parseVersion(chartmgr)
+	if r.Repository != nil {
+		cv, chartURL, err := r.Client.findChartInRepository(r.Repository, creds, name, version)
+		if err != nil {
+			return nil, err
+		}
+		return newChartRef(r.Repository.Spec.URL, cv, chartURL), nil
+	}
 
-	log.Debugf("Looking for chart %s version %s in repo %s", name, version, url)
+	ttl := time.Duration(r.Client.Config().RepositoryIndexTTLSec) * time.Second
+	if url := parseRepoURL(chartmgr); url != "" {
+		repoName := parseRepoName(chartmgr)
+		index, err := r.Client.repos.index(repoName, url, creds, ttl)
+		if err != nil {
+			return nil, err
+		}
+		cv, chartURL, err := findChartInIndex(index, repoName, url, name, version)
+		if err != nil {
+			return nil, err
+		}
+		return newChartRef(url, cv, chartURL), nil
+	}
 
-	curl, err := repo.FindChartInRepoURL(url, name, version, "", "", "", getter.All(settings))
-	if err != nil {
-		return "", err
+	if len(r.Client.defaultRepos) < 1 {
+		return nil, fmt.Errorf("Chart %q has no repository and no default repositories are configured", name)
 	}
-	log.Debugf("Chart URL found: %s", curl)
+	errs := []string{}
+	for _, dr := range r.Client.defaultRepos {
+		index, err := r.Client.repos.index(dr.name, dr.url, r.Client.defaultCredentials(), ttl)
+		if err != nil {
+			log.Warnf("Failed to get index of default repository %s: %v", dr.name, err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		cv, chartURL, err := findChartInIndex(index, dr.name, dr.url, name, version)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return newChartRef(dr.url, cv, chartURL), nil
+	}
+	return nil, fmt.Errorf("Chart %q not found in default repositories: %s", name, strings.Join(errs, "; "))
+}
 
-	return downloadChart(curl, version, settings)
+func newChartRef(repoURL string, cv *repo.ChartVersion, chartURL string) *chartRef {
+	return &chartRef{
+		repoURL: repoURL,
+		name:    cv.Name,
+		version: cv.Version,
+		url:     chartURL,
+		digest:  cv.Digest,
+	}
 }
 
-func downloadChart(url string, version string, settings helm_env.EnvSettings) (string, error) {
+func downloadChart(url string, version string, creds *repoCredentials, settings helm_env.EnvSettings, dir string) (string, error) {
 	dl := downloader.ChartDownloader{
 		HelmHome: settings.Home,
 		Out:      os.Stdout,
-		Getters:  getter.All(settings),
-		Verify:   downloader.VerifyIfPossible,
+		Getters:  creds.getters(settings),
+		// charts are verified by verifyChart, so cached charts are verified too
+		Verify: downloader.VerifyNever,
 	}
 
-	log.Debugf("Downloading chart %s to %s", url, settings.Home.Archive())
-	filename, _, err := dl.DownloadTo(url, version, settings.Home.Archive())
+	log.Debugf("Downloading chart %s to %s", url, dir)
+	filename, _, err := dl.DownloadTo(url, version, dir)
 	if err != nil {
 		return "", err
 	}
@@ -84,6 +271,16 @@ func downloadChart(url string, version string, settings helm_env.EnvSettings) (s
 	return filename, nil
 }
 
+func loadChartArchive(archive []byte) (*chart.Chart, error) {
+	log.Debugf("Loading chart archive")
+	chartRequested, err := chartutil.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Loaded chart %s", chartRequested.GetMetadata().GetName())
+	return chartRequested, nil
+}
+
 func loadChart(filename string) (*chart.Chart, error) {
 	lname, err := filepath.Abs(filename)
 	if err != nil {
@@ -0,0 +1,291 @@
+package lmhelm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/helm/pkg/helm"
+)
+
+const (
+	// tillerPingTimeout is how long a health check waits for Tiller to answer
+	tillerPingTimeout = 10 * time.Second
+	// tillerReconnectMinDelay is the delay before the first reconnect attempt
+	tillerReconnectMinDelay = time.Second
+	// tillerReconnectMaxDelay is the longest delay between reconnect attempts
+	tillerReconnectMaxDelay = time.Minute
+)
+
+// unavailableError is returned for requests made while there is no
+// connection to Tiller
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("Tiller is unavailable: %v", e.err)
+}
+
+// IsConnectionError returns true if the release operation failed because the
+// release backend couldn't be reached
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(*unavailableError); ok {
+		return true
+	}
+	switch err {
+	case context.DeadlineExceeded, grpc.ErrClientConnTimeout, grpc.ErrClientConnClosing:
+		return true
+	}
+	return grpc.Code(err) == codes.Unavailable
+}
+
+// tillerConnection keeps the connection to Tiller healthy. Tiller is pinged
+// periodically and after requests that failed to reach it. when it doesn't
+// answer, the port forwarding tunnel and helm client are rebuilt with backoff
+// until it does.
+type tillerConnection struct {
+	mu          sync.RWMutex
+	client      *Client
+	target      tillerTarget
+	tls         *tillerTLS
+	helm        *helm.Client
+	tunnel      *tillerTunnel
+	err         error
+	check       chan struct{}
+	stop        chan struct{}
+	reconnected *broadcast
+}
+
+// newTillerConnection connects to the tiller and starts health checking the
+// connection. failing to connect isn't fatal since the connection is
+// retried in the background.
+func newTillerConnection(c *Client, target tillerTarget, tillerTLS *tillerTLS, reconnected *broadcast) *tillerConnection {
+	t := &tillerConnection{
+		client:      c,
+		target:      target,
+		tls:         tillerTLS,
+		check:       make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		reconnected: reconnected,
+	}
+	err := t.connect()
+	if err == nil {
+		err = t.ping()
+	}
+	if err != nil {
+		log.Warnf("Failed to connect to tiller %s, retrying in the background: %v", target, err)
+		t.setErr(err)
+	}
+
+	go t.run(time.Duration(c.chartmgrconfig.TillerHealthCheckIntervalSec) * time.Second)
+	return t
+}
+
+// helmClient returns the client of the current connection
+func (t *tillerConnection) helmClient() (*helm.Client, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.helm == nil {
+		return nil, &unavailableError{err: t.err}
+	}
+	return t.helm, nil
+}
+
+// observe checks the connection right away if the request failed to reach
+// Tiller
+func (t *tillerConnection) observe(err error) error {
+	if !IsConnectionError(err) {
+		return err
+	}
+	t.setErr(err)
+	select {
+	case t.check <- struct{}{}:
+	default:
+		// a check is already pending
+	}
+	return err
+}
+
+// Ready returns an error if Tiller isn't reachable
+func (t *tillerConnection) Ready() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.err != nil {
+		return &unavailableError{err: t.err}
+	}
+	return nil
+}
+
+func (t *tillerConnection) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.check:
+		case <-t.stop:
+			return
+		}
+		t.heal()
+	}
+}
+
+// close stops the health checks and the port forwarding of a connection that
+// is no longer used. closing it again does nothing.
+func (t *tillerConnection) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped() {
+		return
+	}
+	close(t.stop)
+
+	t.tunnel.close()
+	t.tunnel = nil
+	t.helm = nil
+	t.err = fmt.Errorf("Connection to tiller %s was closed", t.target)
+}
+
+func (t *tillerConnection) stopped() bool {
+	select {
+	case <-t.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// heal pings Tiller and reconnects with backoff until it answers or the
+// connection is closed
+func (t *tillerConnection) heal() {
+	err := t.ping()
+	delay := tillerReconnectMinDelay
+	for err != nil {
+		if t.stopped() {
+			return
+		}
+		log.Warnf("Tiller %s health check failed, reconnecting in %v: %v", t.target, delay, err)
+		t.setErr(err)
+		select {
+		case <-time.After(delay):
+		case <-t.stop:
+			return
+		}
+		delay *= 2
+		if delay > tillerReconnectMaxDelay {
+			delay = tillerReconnectMaxDelay
+		}
+
+		err = t.connect()
+		if err == nil {
+			err = t.ping()
+		}
+	}
+	t.setErr(nil)
+}
+
+// connect replaces the port forwarding tunnel and helm client
+func (t *tillerConnection) connect() error {
+	t.mu.Lock()
+	t.tunnel.close()
+	t.tunnel = nil
+	t.helm = nil
+	t.mu.Unlock()
+
+	tillerHost, tunnel, err := t.client.tillerHost(t.target)
+	if err != nil {
+		return err
+	}
+	log.Infof("Using tiller host %s for tiller %s", tillerHost, t.target)
+	opts := []helm.Option{helm.Host(tillerHost)}
+	if t.tls != nil {
+		// helm dials tiller per request, so rotated certificates are used by
+		// the next request
+		tlsConfig, err := t.tls.config(tillerHost)
+		if err != nil {
+			tunnel.close()
+			return err
+		}
+		opts = append(opts, helm.WithTLS(tlsConfig))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped() {
+		tunnel.close()
+		return t.err
+	}
+	t.tunnel = tunnel
+	t.helm = helm.NewClient(opts...)
+	return nil
+}
+
+// ping returns an error if Tiller doesn't answer a version request
+func (t *tillerConnection) ping() error {
+	h, err := t.helmClient()
+	if err != nil {
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := h.GetVersion()
+		errc <- err
+	}()
+	select {
+	case err = <-errc:
+		return err
+	case <-time.After(tillerPingTimeout):
+		return fmt.Errorf("Tiller didn't answer within %v", tillerPingTimeout)
+	}
+}
+
+// setErr records the state of the connection, signaling a reconnect when it
+// recovers. the state of a closed connection doesn't change.
+func (t *tillerConnection) setErr(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped() {
+		return
+	}
+
+	if err == nil && t.err != nil {
+		log.Infof("Reconnected to tiller %s", t.target)
+		t.reconnected.notify()
+	}
+	t.err = err
+}
+
+// broadcast notifies all its waiters at once by closing a channel, which is
+// replaced for the next notification
+type broadcast struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newBroadcast() *broadcast {
+	return &broadcast{ch: make(chan struct{})}
+}
+
+// wait returns a channel that is closed on the next notification
+func (b *broadcast) wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ch
+}
+
+func (b *broadcast) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.ch)
+	b.ch = make(chan struct{})
+}
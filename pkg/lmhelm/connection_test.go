@@ -0,0 +1,180 @@
+package lmhelm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/helm/pkg/proto/hapi/services"
+	"k8s.io/helm/pkg/proto/hapi/version"
+)
+
+// fakeTiller answers version requests while it is available, and fails them
+// with codes.Unavailable otherwise
+type fakeTiller struct {
+	services.ReleaseServiceServer
+
+	mu        sync.Mutex
+	available bool
+	pings     int
+}
+
+func (f *fakeTiller) GetVersion(ctx context.Context, req *services.GetVersionRequest) (*services.GetVersionResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pings++
+	if !f.available {
+		return nil, grpc.Errorf(codes.Unavailable, "tiller is down")
+	}
+	return &services.GetVersionResponse{Version: &version.Version{SemVer: "v2.7.2"}}, nil
+}
+
+func (f *fakeTiller) setAvailable(available bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.available = available
+}
+
+func (f *fakeTiller) pinged() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pings
+}
+
+// newFakeTiller serves a fake tiller on a local port and returns its address
+func newFakeTiller(t *testing.T) (*fakeTiller, string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeTiller{}
+	s := grpc.NewServer()
+	services.RegisterReleaseServiceServer(s, f)
+	go s.Serve(l) // nolint: errcheck
+	return f, l.Addr().String(), s.Stop
+}
+
+// waitClosed fails the test if ch isn't closed within a few seconds
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s didn't happen", what)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "unavailable", err: &unavailableError{err: errors.New("down")}, want: true},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: true},
+		{name: "dial timeout", err: grpc.ErrClientConnTimeout, want: true},
+		{name: "connection closing", err: grpc.ErrClientConnClosing, want: true},
+		{name: "unavailable code", err: grpc.Errorf(codes.Unavailable, "transport is closing"), want: true},
+		{name: "other code", err: grpc.Errorf(codes.NotFound, "release: not found"), want: false},
+		{name: "other error", err: errors.New("chart not found"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsConnectionError(tt.err); got != tt.want {
+				t.Errorf("IsConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTillerConnectionHeal(t *testing.T) {
+	tiller, addr, stop := newFakeTiller(t)
+	defer stop()
+	c := &Client{chartmgrconfig: &config.Config{TillerHealthCheckIntervalSec: 3600}}
+	reconnected := newBroadcast()
+
+	conn := newTillerConnection(c, tillerTarget{host: addr}, nil, reconnected)
+	defer conn.close()
+	if err := conn.Ready(); !IsConnectionError(err) {
+		t.Fatalf("Ready() = %v while tiller is down", err)
+	}
+
+	// a failed request triggers a health check, which recovers the
+	// connection and wakes up the retries
+	waiter := reconnected.wait()
+	tiller.setAvailable(true)
+	conn.observe(grpc.Errorf(codes.Unavailable, "tiller is down"))
+	waitClosed(t, waiter, "recovering")
+	if err := conn.Ready(); err != nil {
+		t.Fatalf("Ready() = %v after recovering", err)
+	}
+
+	// other errors don't affect the connection
+	if err := conn.observe(errors.New("chart not found")); err == nil || conn.Ready() != nil {
+		t.Errorf("observe() = %v, Ready() = %v", err, conn.Ready())
+	}
+
+	// tiller going away rebuilds the helm client until it answers again
+	before, err := conn.helmClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter = reconnected.wait()
+	tiller.setAvailable(false)
+	pings := tiller.pinged()
+	conn.observe(grpc.Errorf(codes.Unavailable, "tiller is down"))
+	for tiller.pinged() == pings {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := conn.Ready(); !IsConnectionError(err) {
+		t.Errorf("Ready() = %v while reconnecting", err)
+	}
+	tiller.setAvailable(true)
+	waitClosed(t, waiter, "reconnecting")
+	after, err := conn.helmClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before {
+		t.Error("the helm client wasn't rebuilt")
+	}
+
+	// a closed connection stays closed
+	conn.close()
+	conn.setErr(nil)
+	if err := conn.Ready(); err == nil || err.Error() != "Tiller is unavailable: Connection to tiller "+addr+" was closed" {
+		t.Errorf("Ready() = %v after closing", err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	b := newBroadcast()
+	waiters := []<-chan struct{}{b.wait(), b.wait()}
+	b.notify()
+	for _, w := range waiters {
+		waitClosed(t, w, "notifying the waiters")
+	}
+
+	// the next notification needs a new wait
+	select {
+	case <-b.wait():
+		t.Error("a new waiter was notified")
+	default:
+	}
+}
+
+func TestTillerTunnelClose(t *testing.T) {
+	var none *tillerTunnel
+	none.close()
+
+	tunnel := &tillerTunnel{stop: make(chan struct{})}
+	tunnel.close()
+	tunnel.close()
+	waitClosed(t, tunnel.stop, "stopping the tunnel")
+}
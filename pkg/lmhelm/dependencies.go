@@ -0,0 +1,351 @@
+package lmhelm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "github.com/ghodss/yaml"
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/downloader"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/helm/helmpath"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+	"k8s.io/helm/pkg/resolver"
+	"k8s.io/helm/pkg/urlutil"
+)
+
+// requirementsLockName is the name of the requirements lock file of a chart
+const requirementsLockName = "requirements.lock"
+
+// resolveDependencies downloads the dependencies the chart declares in its
+// requirements.yaml but doesn't ship in its charts directory, and records the
+// dependency versions in the release. dependencies are resolved from the
+// chart's requirements.lock, or the lock of an earlier resolution of the same
+// requirements, so their versions don't change between reconciles.
+func resolveDependencies(r *Release, helmChart *chart.Chart) (*chart.Chart, error) {
+	r.dependencies = nil
+	req, err := chartutil.LoadRequirements(helmChart)
+	if err == chartutil.ErrRequirementsNotFound {
+		return helmChart, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name := helmChart.GetMetadata().GetName()
+	missing := missingDependencies(helmChart, req)
+	if len(missing) > 0 {
+		log.Infof("Resolving missing dependencies %s of chart %s", strings.Join(missing, ", "), name)
+		helmChart, err = r.Client.buildDependencies(r, helmChart, req)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve dependencies of chart %s: %v", name, err)
+		}
+	}
+
+	r.dependencies = chartDependencies(helmChart, req)
+	return helmChart, nil
+}
+
+// missingDependencies returns the names of the required charts that aren't in
+// the chart's charts directory
+func missingDependencies(helmChart *chart.Chart, req *chartutil.Requirements) []string {
+	vendored := map[string]bool{}
+	for _, dep := range helmChart.Dependencies {
+		vendored[dep.GetMetadata().GetName()] = true
+	}
+
+	missing := []string{}
+	for _, dep := range req.Dependencies {
+		if !vendored[dep.Name] {
+			missing = append(missing, dep.Name)
+		}
+	}
+	return missing
+}
+
+// chartDependencies returns the versions of the chart's dependencies
+func chartDependencies(helmChart *chart.Chart, req *chartutil.Requirements) []crv1alpha1.ChartMgrDependency {
+	versions := map[string]string{}
+	for _, dep := range helmChart.Dependencies {
+		versions[dep.GetMetadata().GetName()] = dep.GetMetadata().GetVersion()
+	}
+
+	deps := []crv1alpha1.ChartMgrDependency{}
+	for _, dep := range req.Dependencies {
+		deps = append(deps, crv1alpha1.ChartMgrDependency{
+			Name:       dep.Name,
+			Version:    versions[dep.Name],
+			Repository: dep.Repository,
+		})
+	}
+	return deps
+}
+
+// buildDependencies unpacks the chart to a temporary directory and runs
+// helm's dependency manager on it against the repositories of the
+// dependencies
+func (c *Client) buildDependencies(r *Release, helmChart *chart.Chart, req *chartutil.Requirements) (*chart.Chart, error) {
+	chartmgr := r.Chartmgr
+	tmp, err := ioutil.TempDir("", "chartmgr-deps-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp) // nolint: errcheck
+
+	home, creds, err := c.dependencyHome(r, req, filepath.Join(tmp, "home"))
+	defer creds.cleanup()
+	if err != nil {
+		return nil, err
+	}
+
+	chartDir := filepath.Join(tmp, "chart")
+	err = os.Mkdir(chartDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = chartutil.SaveDir(helmChart, chartDir)
+	if err != nil {
+		return nil, err
+	}
+	chartPath := filepath.Join(chartDir, helmChart.GetMetadata().GetName())
+
+	lockPath := c.lockPath(chartmgr)
+	_, err = chartutil.LoadRequirementsLock(helmChart)
+	if err != nil {
+		err = restoreLock(lockPath, chartPath, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := log.StandardLogger().WriterLevel(log.DebugLevel)
+	defer out.Close() // nolint: errcheck
+	m := &downloader.Manager{
+		Out:       out,
+		ChartPath: chartPath,
+		HelmHome:  home,
+		Verify:    downloader.VerifyNever,
+		// the registry keeps the indexes up to date
+		SkipUpdate: true,
+		Getters:    creds.getters(c),
+	}
+	err = m.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveLock(filepath.Join(chartPath, requirementsLockName), lockPath)
+	if err != nil {
+		log.Warnf("Failed to save requirements lock of %s/%s: %v", chartmgr.ObjectMeta.Namespace, chartmgr.ObjectMeta.Name, err)
+	}
+	return loadChart(chartPath)
+}
+
+// dependencyCredentials are the credentials of the repositories of a chart's
+// dependencies by repository URL
+type dependencyCredentials map[string]*repoCredentials
+
+// getters returns the helm getters authenticating to each repository with its
+// credentials. helm's downloader creates a getter per repository URL, or per
+// chart URL if it finds no repository listing the chart by that URL.
+func (d dependencyCredentials) getters(c *Client) getter.Providers {
+	providers := getter.Providers{
+		{
+			Schemes: []string{"http", "https"},
+			New: func(URL, CertFile, KeyFile, CAFile string) (getter.Getter, error) {
+				return newAuthHTTPGetter(URL, d.forURL(c, URL).withFiles(CertFile, KeyFile, CAFile))
+			},
+		},
+	}
+	for _, p := range getter.All(c.settings) {
+		if !p.Provides("http") && !p.Provides("https") {
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+// forURL returns the credentials of the repository the URL is in, preferring
+// the longest repository URL, or the default credentials
+func (d dependencyCredentials) forURL(c *Client, u string) *repoCredentials {
+	creds := c.defaultCredentials()
+	longest := -1
+	for repoURL, repoCreds := range d {
+		prefix := strings.TrimSuffix(repoURL, "/")
+		if !urlutil.Equal(repoURL, u) && !strings.HasPrefix(u, prefix+"/") {
+			continue
+		}
+		if len(prefix) > longest {
+			creds, longest = repoCreds, len(prefix)
+		}
+	}
+	return creds
+}
+
+func (d dependencyCredentials) cleanup() {
+	for _, creds := range d {
+		creds.cleanup()
+	}
+}
+
+// dependencyHome returns a helm home in dir holding only the repositories of
+// the dependencies and their indexes, since helm's dependency manager
+// resolves repositories by name from the repositories file of its home.
+// registered repositories may share names, so they aren't all written there.
+// the credentials of the repositories are returned along with the home, and
+// must be cleaned up even if an error is returned.
+func (c *Client) dependencyHome(r *Release, req *chartutil.Requirements, dir string) (helmpath.Home, dependencyCredentials, error) {
+	home := helmpath.Home(dir)
+	creds := dependencyCredentials{}
+	err := ensureDirectories(home)
+	if err != nil {
+		return home, creds, err
+	}
+
+	f := repo.NewRepoFile()
+	for _, dep := range req.Dependencies {
+		if strings.HasPrefix(dep.Repository, "file://") {
+			return home, creds, fmt.Errorf("Dependency %s from a local path must be shipped in the chart's charts directory", dep.Name)
+		}
+		name, url, source, ok := c.dependencyRepo(r, dep.Repository)
+		if !ok {
+			return home, creds, fmt.Errorf("Dependency %s is from unknown repository %s. Add a ChartRepository or default repository for it", dep.Name, dep.Repository)
+		}
+		if creds[url] == nil {
+			creds[url], err = c.loadSourceCredentials(source)
+			if err != nil {
+				delete(creds, url)
+				return home, creds, err
+			}
+		}
+		index, err := c.repos.index(name, url, creds[url], 0)
+		if err != nil {
+			return home, creds, err
+		}
+
+		entryName := dependencyRepoName(dep.Repository)
+		if f.Has(entryName) {
+			continue
+		}
+		err = index.WriteFile(home.CacheIndex(entryName), 0644)
+		if err != nil {
+			return home, creds, err
+		}
+		f.Add(creds[url].entry(entryName, home.CacheIndex(entryName), url))
+	}
+	return home, creds, f.WriteFile(home.RepositoryFile(), 0644)
+}
+
+// dependencyRepo returns the name, URL and credential source of the
+// repository of a dependency: the release's own repository, which the
+// dependency is downloaded from with the release's credentials, or a
+// registered repository
+func (c *Client) dependencyRepo(r *Release, repository string) (string, string, repoSource, bool) {
+	name, url := parseRepoName(r.Chartmgr), parseRepoURL(r.Chartmgr)
+	if r.Repository != nil {
+		name, url = r.Repository.ObjectMeta.Name, r.Repository.Spec.URL
+	}
+	if url != "" && repositoryEqual(repository, name, url) {
+		return name, url, releaseRepoSource(r), true
+	}
+	return c.repos.lookup(repository, r.Chartmgr.ObjectMeta.Namespace)
+}
+
+// dependencyRepoName returns the name the requirements.yaml repository is
+// known by in the dependency home: the name it refers to, or a name derived
+// from its URL
+func dependencyRepoName(repository string) string {
+	switch {
+	case strings.HasPrefix(repository, "@"):
+		return strings.TrimPrefix(repository, "@")
+	case strings.HasPrefix(repository, "alias:"):
+		return strings.TrimPrefix(repository, "alias:")
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(repository, "/")))
+	return "url-" + hex.EncodeToString(sum[:8])
+}
+
+// lockPath returns where the requirements lock of the chart manager's chart
+// is kept
+func (c *Client) lockPath(chartmgr *crv1alpha1.ChartManager) string {
+	return c.settings.Home.Path("cache", "locks", chartmgr.ObjectMeta.Namespace, chartmgr.ObjectMeta.Name+".lock")
+}
+
+// RemoveDependencyLock removes the requirements lock kept for the chart
+// manager's chart, once the chart manager is deleted
+func (c *Client) RemoveDependencyLock(chartmgr *crv1alpha1.ChartManager) error {
+	err := os.Remove(c.lockPath(chartmgr))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// restoreLock copies the lock of an earlier resolution into the chart if it
+// was resolved from the same requirements
+func restoreLock(lockPath string, chartPath string, req *chartutil.Requirements) error {
+	data, err := ioutil.ReadFile(lockPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lock := &chartutil.RequirementsLock{}
+	err = yaml.Unmarshal(data, lock)
+	if err != nil {
+		log.Warnf("Ignoring invalid requirements lock %s: %v", lockPath, err)
+		return nil
+	}
+	digest, err := resolver.HashReq(req)
+	if err != nil {
+		return err
+	}
+	if lock.Digest != digest {
+		log.Debugf("Requirements changed since %s was resolved", lockPath)
+		return nil
+	}
+	return ioutil.WriteFile(filepath.Join(chartPath, requirementsLockName), data, 0644)
+}
+
+func saveLock(src string, lockPath string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(lockPath), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := tempFile(lockPath)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return err
+	}
+	return os.Rename(tmp, lockPath)
+}
+
+// repositoryEqual returns true if the requirements repository refers to the
+// repository name or URL
+func repositoryEqual(repository string, name string, url string) bool {
+	switch {
+	case strings.HasPrefix(repository, "@"):
+		return strings.TrimPrefix(repository, "@") == name
+	case strings.HasPrefix(repository, "alias:"):
+		return strings.TrimPrefix(repository, "alias:") == name
+	}
+	return urlutil.Equal(url, strings.TrimSuffix(repository, "/"))
+}
@@ -0,0 +1,314 @@
+// Package fake provides an in-memory release backend and an HTTP chart
+// repository for hermetic tests of the chart management.
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	lmhelm "github.com/logicmonitor/k8s-chart-manager-controller/pkg/lmhelm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// Operations of the backend, for failure injection and call records
+const (
+	OpInstall  = "install"
+	OpUpgrade  = "upgrade"
+	OpDelete   = "delete"
+	OpGet      = "get"
+	OpHistory  = "history"
+	OpRollback = "rollback"
+	OpTest     = "test"
+	OpList     = "list"
+)
+
+// Backend is an in-memory release backend modeling Tiller's release
+// revisions and statuses: an install creates revision 1, an upgrade or
+// rollback supersedes the deployed revision, a failed install or upgrade
+// records a FAILED revision, and a delete purges the history.
+type Backend struct {
+	mu       sync.Mutex
+	releases map[string][]*rspb.Release
+	failures map[string]error
+	calls    []string
+}
+
+// NewBackend returns a backend holding the releases. releases with the same
+// name are revisions of one release, oldest first.
+func NewBackend(releases ...*rspb.Release) *Backend {
+	b := &Backend{
+		releases: map[string][]*rspb.Release{},
+		failures: map[string]error{},
+	}
+	for _, rls := range releases {
+		b.releases[rls.Name] = append(b.releases[rls.Name], copyRelease(rls))
+	}
+	return b
+}
+
+// NewRelease returns revision 1 of a release with the status
+func NewRelease(name string, namespace string, status rspb.Status_Code) *rspb.Release {
+	return &rspb.Release{
+		Name:      name,
+		Namespace: namespace,
+		Version:   1,
+		Info:      &rspb.Info{Status: &rspb.Status{Code: status}},
+	}
+}
+
+// Fail makes the next call of the operation fail with the error
+func (b *Backend) Fail(op string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures[op] = err
+}
+
+// Calls returns the operations called so far as "operation release-name",
+// in order
+func (b *Backend) Calls() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, b.calls...)
+}
+
+// Revisions returns the revisions of the release, oldest first
+func (b *Backend) Revisions(name string) []*rspb.Release {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	revisions := []*rspb.Release{}
+	for _, rls := range b.releases[name] {
+		revisions = append(revisions, copyRelease(rls))
+	}
+	return revisions
+}
+
+// Install installs the chart as the release
+func (b *Backend) Install(r *lmhelm.Release, ch *chart.Chart, vals []byte) (*rspb.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := r.Name()
+	err := b.call(OpInstall, name)
+	current := b.current(name)
+	if current != nil && statusCode(current) != rspb.Status_DELETED {
+		return nil, fmt.Errorf("a release named %s already exists", name)
+	}
+
+	rls := b.record(name, r.Chartmgr.ObjectMeta.Namespace, ch, vals)
+	if err != nil {
+		setStatus(rls, rspb.Status_FAILED)
+		return copyRelease(rls), err
+	}
+	return copyRelease(rls), nil
+}
+
+// Upgrade upgrades the release to the chart and values
+func (b *Backend) Upgrade(r *lmhelm.Release, ch *chart.Chart, vals []byte) (*rspb.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := r.Name()
+	err := b.call(OpUpgrade, name)
+	current := b.current(name)
+	if current == nil || statusCode(current) == rspb.Status_DELETED {
+		return nil, fmt.Errorf("%q has no deployed releases", name)
+	}
+
+	b.supersede(name)
+	rls := b.record(name, current.Namespace, ch, vals)
+	if err != nil {
+		setStatus(rls, rspb.Status_FAILED)
+		return copyRelease(rls), err
+	}
+	return copyRelease(rls), nil
+}
+
+// Delete deletes and purges the release
+func (b *Backend) Delete(r *lmhelm.Release) (*rspb.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := r.Name()
+	err := b.call(OpDelete, name)
+	if err != nil {
+		return nil, err
+	}
+	current := b.current(name)
+	if current == nil {
+		return nil, fmt.Errorf("release: %q not found", name)
+	}
+
+	delete(b.releases, name)
+	rls := copyRelease(current)
+	setStatus(rls, rspb.Status_DELETED)
+	return rls, nil
+}
+
+// Get returns the current revision of the release, or a
+// *lmhelm.ReleaseNotFoundError if it doesn't exist
+func (b *Backend) Get(r *lmhelm.Release) (*rspb.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := r.Name()
+	err := b.call(OpGet, name)
+	if err != nil {
+		return nil, err
+	}
+	current := b.current(name)
+	if current == nil || statusCode(current) == rspb.Status_DELETED {
+		return nil, &lmhelm.ReleaseNotFoundError{Name: name}
+	}
+	return copyRelease(current), nil
+}
+
+// History returns up to max revisions of the release, newest first
+func (b *Backend) History(r *lmhelm.Release, max int32) ([]*rspb.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := r.Name()
+	err := b.call(OpHistory, name)
+	if err != nil {
+		return nil, err
+	}
+	revisions := b.releases[name]
+	if len(revisions) < 1 {
+		return nil, fmt.Errorf("release: %q not found", name)
+	}
+
+	history := []*rspb.Release{}
+	for i := len(revisions) - 1; i >= 0 && int32(len(history)) < max; i-- {
+		history = append(history, copyRelease(revisions[i]))
+	}
+	return history, nil
+}
+
+// Rollback rolls the release back to the revision
+func (b *Backend) Rollback(r *lmhelm.Release, revision int32) (*rspb.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := r.Name()
+	err := b.call(OpRollback, name)
+	var target *rspb.Release
+	for _, rls := range b.releases[name] {
+		if rls.Version == revision {
+			target = rls
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("release %q revision %d not found", name, revision)
+	}
+
+	b.supersede(name)
+	rls := b.record(name, target.Namespace, target.Chart, []byte(target.GetConfig().GetRaw()))
+	if err != nil {
+		setStatus(rls, rspb.Status_FAILED)
+		return copyRelease(rls), err
+	}
+	return copyRelease(rls), nil
+}
+
+// Test runs no tests, and fails if a failure was injected
+func (b *Backend) Test(r *lmhelm.Release) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := r.Name()
+	err := b.call(OpTest, name)
+	if err != nil {
+		return nil, err
+	}
+	if b.current(name) == nil {
+		return nil, fmt.Errorf("release: %q not found", name)
+	}
+	return []string{}, nil
+}
+
+// List returns the current revisions of the releases with the prefix,
+// ordered by name
+func (b *Backend) List(prefix string) ([]*rspb.Release, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.call(OpList, prefix)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range b.releases {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	releases := []*rspb.Release{}
+	for _, name := range names {
+		releases = append(releases, copyRelease(b.current(name)))
+	}
+	return releases, nil
+}
+
+// call records the call and returns the injected failure of the operation.
+// b.mu must be held.
+func (b *Backend) call(op string, name string) error {
+	b.calls = append(b.calls, op+" "+name)
+	err := b.failures[op]
+	delete(b.failures, op)
+	return err
+}
+
+// current returns the latest revision of the release. b.mu must be held.
+func (b *Backend) current(name string) *rspb.Release {
+	revisions := b.releases[name]
+	if len(revisions) < 1 {
+		return nil
+	}
+	return revisions[len(revisions)-1]
+}
+
+// supersede marks the deployed revisions of the release superseded. b.mu
+// must be held.
+func (b *Backend) supersede(name string) {
+	for _, rls := range b.releases[name] {
+		if statusCode(rls) == rspb.Status_DEPLOYED {
+			setStatus(rls, rspb.Status_SUPERSEDED)
+		}
+	}
+}
+
+// record adds a deployed revision to the release. b.mu must be held.
+func (b *Backend) record(name string, namespace string, ch *chart.Chart, vals []byte) *rspb.Release {
+	rls := &rspb.Release{
+		Name:      name,
+		Namespace: namespace,
+		Chart:     ch,
+		Config:    &chart.Config{Raw: string(vals)},
+		Version:   int32(len(b.releases[name]) + 1),
+		Info:      &rspb.Info{Status: &rspb.Status{Code: rspb.Status_DEPLOYED}},
+	}
+	b.releases[name] = append(b.releases[name], rls)
+	return rls
+}
+
+func statusCode(rls *rspb.Release) rspb.Status_Code {
+	return rls.GetInfo().GetStatus().GetCode()
+}
+
+func setStatus(rls *rspb.Release, code rspb.Status_Code) {
+	if rls.Info == nil {
+		rls.Info = &rspb.Info{}
+	}
+	rls.Info.Status = &rspb.Status{Code: code}
+}
+
+func copyRelease(rls *rspb.Release) *rspb.Release {
+	return proto.Clone(rls).(*rspb.Release)
+}
@@ -0,0 +1,104 @@
+package fake
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+// ChartRepository serves packaged charts and their index over HTTP like a
+// chart repository, optionally requiring basic auth
+type ChartRepository struct {
+	*httptest.Server
+	dir string
+
+	mu       sync.Mutex
+	username string
+	password string
+}
+
+// NewChartRepository packages the charts and starts serving them. Close
+// stops the server and removes the packages.
+func NewChartRepository(charts ...*chart.Chart) (*ChartRepository, error) {
+	dir, err := ioutil.TempDir("", "chartmgr-repo-")
+	if err != nil {
+		return nil, err
+	}
+	r := &ChartRepository{dir: dir}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+
+	err = r.Add(charts...)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Add packages the charts and adds them to the index
+func (r *ChartRepository) Add(charts ...*chart.Chart) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ch := range charts {
+		_, err := chartutil.Save(ch, r.dir)
+		if err != nil {
+			return err
+		}
+	}
+	index, err := repo.IndexDirectory(r.dir, r.URL)
+	if err != nil {
+		return err
+	}
+	return index.WriteFile(filepath.Join(r.dir, "index.yaml"), 0644)
+}
+
+// RequireBasicAuth makes the repository refuse requests without the username
+// and password
+func (r *ChartRepository) RequireBasicAuth(username string, password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.username, r.password = username, password
+}
+
+func (r *ChartRepository) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.username != "" {
+		username, password, ok := req.BasicAuth()
+		if !ok || username != r.username || password != r.password {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	http.FileServer(http.Dir(r.dir)).ServeHTTP(w, req)
+}
+
+// Close stops the server and removes the packages
+func (r *ChartRepository) Close() {
+	r.Server.Close()
+	os.RemoveAll(r.dir) // nolint: errcheck
+}
+
+// NewChart returns a chart with the values and a config map template
+func NewChart(name string, version string, values string) *chart.Chart {
+	return &chart.Chart{
+		Metadata: &chart.Metadata{
+			ApiVersion: chartutil.ApiVersionV1,
+			Name:       name,
+			Version:    version,
+		},
+		Values: &chart.Config{Raw: values},
+		Templates: []*chart.Template{{
+			Name: "templates/configmap.yaml",
+			Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n"),
+		}},
+	}
+}
@@ -0,0 +1,380 @@
+package lmhelm
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+const (
+	// GitSecretSSHKeyKey is the git secret key holding the SSH private key
+	GitSecretSSHKeyKey = "ssh-privatekey"
+	// GitSecretKnownHostsKey is the git secret key holding the SSH known hosts
+	GitSecretKnownHostsKey = "known_hosts"
+	// GitSecretUsernameKey is the git secret key holding the HTTPS username
+	GitSecretUsernameKey = "username"
+	// GitSecretTokenKey is the git secret key holding the HTTPS token
+	GitSecretTokenKey = "token"
+)
+
+// gitSCPURLPattern matches scp-like git URLs, e.g. git@example.com:org/repo.git.
+// a second colon would select a remote helper, e.g. ext::.
+var gitSCPURLPattern = regexp.MustCompile(`^([A-Za-z0-9._~-]+@)?[A-Za-z0-9][A-Za-z0-9.-]*:[^:]`)
+
+// gitLocks serializes access to each git cache directory
+var gitLocks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: map[string]*sync.Mutex{}}
+
+// environment variables passing the HTTPS credentials to the credential
+// helper, so that they don't appear in the command line of git
+const (
+	gitUsernameEnv = "CHARTMGR_GIT_USERNAME"
+	gitTokenEnv    = "CHARTMGR_GIT_TOKEN"
+)
+
+// gitCredentialHelper answers git's credential requests with the HTTPS
+// credentials in the environment
+const gitCredentialHelper = `!f() { test "$1" = get && echo "username=$` + gitUsernameEnv + `" && echo "password=$` + gitTokenEnv + `"; }; f`
+
+// gitAuth is the authentication used for a git remote. the SSH key is
+// materialized to a file because ssh only accepts paths.
+type gitAuth struct {
+	username       string
+	token          string
+	sshKeyFile     string
+	knownHostsFile string
+	// insecureHostKey disables host key verification if there are no known
+	// hosts
+	insecureHostKey bool
+	dir             string
+	network         *networkConfig
+}
+
+// GitCommit fetches the git source of the chart manager and returns the
+// commit its ref resolves to
+func (c *Client) GitCommit(chartmgr *crv1alpha1.ChartManager) (string, error) {
+	g := chartmgr.Spec.Chart.Git
+	err := validateGitURL(g.URL)
+	if err != nil {
+		return "", err
+	}
+	auth, err := c.loadGitAuth(chartmgr)
+	if err != nil {
+		return "", err
+	}
+	defer auth.cleanup()
+
+	dir := gitCacheDir(c, g.URL)
+	unlock := lockGitDir(dir)
+	defer unlock()
+
+	err = syncGitCache(dir, g.URL, auth)
+	if err != nil {
+		return "", err
+	}
+	return resolveGitRef(dir, g.Ref)
+}
+
+// getGitChart loads the chart from the git source and returns it with the
+// commit it was loaded from
+func getGitChart(r *Release) (*chart.Chart, string, error) {
+	g := r.Chartmgr.Spec.Chart.Git
+	err := validateGitURL(g.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	auth, err := r.Client.loadGitAuth(r.Chartmgr)
+	if err != nil {
+		return nil, "", err
+	}
+	defer auth.cleanup()
+
+	dir := gitCacheDir(r.Client, g.URL)
+	unlock := lockGitDir(dir)
+	defer unlock()
+
+	err = syncGitCache(dir, g.URL, auth)
+	if err != nil {
+		return nil, "", err
+	}
+	commit, err := resolveGitRef(dir, g.Ref)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tmp, err := ioutil.TempDir("", "chartmgr-git-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(tmp) // nolint: errcheck
+
+	log.Debugf("Extracting %s at commit %s", g.URL, commit)
+	err = extractGitTree(dir, commit, g.Path, tmp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	helmChart, err := loadChart(filepath.Join(tmp, g.Path))
+	if err != nil {
+		return nil, "", err
+	}
+	return helmChart, commit, nil
+}
+
+// validateGitURL only accepts HTTPS and SSH remotes. local paths and file://
+// URLs would read the git caches of other chart managers, which were fetched
+// with their credentials.
+func validateGitURL(url string) error {
+	switch {
+	case strings.HasPrefix(url, "-"):
+	case strings.HasPrefix(url, "https://"), strings.HasPrefix(url, "ssh://"):
+		return nil
+	case gitSCPURLPattern.MatchString(url) && !strings.Contains(url, "://"):
+		// git parses URLs with a scheme as such, whatever the scheme
+		return nil
+	}
+	return fmt.Errorf("Unsupported git URL %q. Use an https://, ssh:// or scp-like user@host:path URL", url)
+}
+
+func (c *Client) loadGitAuth(chartmgr *crv1alpha1.ChartManager) (*gitAuth, error) {
+	auth := &gitAuth{network: c.network}
+	ref := chartmgr.Spec.Chart.Git.SecretRef
+	if ref == nil {
+		return auth, nil
+	}
+
+	namespace := chartmgr.ObjectMeta.Namespace
+	log.Debugf("Reading git credentials from secret %s/%s", namespace, ref.Name)
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	redactSecret(secret)
+
+	auth.username = string(secret.Data[GitSecretUsernameKey])
+	auth.token = string(secret.Data[GitSecretTokenKey])
+	if auth.username == "" {
+		auth.username = "git"
+	}
+	auth.insecureHostKey = chartmgr.Spec.Chart.Git.InsecureSkipHostKeyVerification
+	if len(secret.Data[GitSecretSSHKeyKey]) > 0 && len(secret.Data[GitSecretKnownHostsKey]) < 1 && !auth.insecureHostKey {
+		return nil, fmt.Errorf("Secret %s/%s has no %s to verify the SSH host key with. Add it or set insecureSkipHostKeyVerification", namespace, ref.Name, GitSecretKnownHostsKey)
+	}
+	err = auth.writeFiles(secret.Data)
+	if err != nil {
+		auth.cleanup()
+		return nil, err
+	}
+	return auth, nil
+}
+
+func (a *gitAuth) writeFiles(data map[string][]byte) error {
+	if len(data[GitSecretSSHKeyKey]) < 1 {
+		return nil
+	}
+
+	// the directory is only accessible by the controller user
+	dir, err := ioutil.TempDir("", "chartmgr-git-auth-")
+	if err != nil {
+		return err
+	}
+	a.dir = dir
+
+	a.sshKeyFile = filepath.Join(dir, GitSecretSSHKeyKey)
+	err = ioutil.WriteFile(a.sshKeyFile, data[GitSecretSSHKeyKey], 0600)
+	if err != nil {
+		return err
+	}
+
+	if len(data[GitSecretKnownHostsKey]) > 0 {
+		a.knownHostsFile = filepath.Join(dir, GitSecretKnownHostsKey)
+		return ioutil.WriteFile(a.knownHostsFile, data[GitSecretKnownHostsKey], 0600)
+	}
+	return nil
+}
+
+func (a *gitAuth) cleanup() {
+	if a == nil || a.dir == "" {
+		return
+	}
+	err := os.RemoveAll(a.dir)
+	if err != nil {
+		log.Warnf("Failed to remove git credentials %s: %v", a.dir, err)
+	}
+}
+
+// args returns the configuration of git for the credentials. the HTTPS
+// token is read by the credential helper from the environment, since the
+// command line of a process is visible to other users of the host.
+func (a *gitAuth) args() []string {
+	if a.token == "" {
+		return nil
+	}
+	// the empty helper drops the helpers of the system configuration
+	return []string{"-c", "credential.helper=", "-c", "credential.helper=" + gitCredentialHelper}
+}
+
+func (a *gitAuth) env() []string {
+	env := append([]string{"GIT_TERMINAL_PROMPT=0"}, a.network.env()...)
+	if a.token != "" {
+		env = append(env, gitUsernameEnv+"="+a.username, gitTokenEnv+"="+a.token)
+	}
+	if a.sshKeyFile == "" {
+		return env
+	}
+
+	// the host key is only left unverified if the chart manager opted in
+	hostKeyOpts := fmt.Sprintf("-o StrictHostKeyChecking=yes -o UserKnownHostsFile=%s", a.knownHostsFile)
+	if a.knownHostsFile == "" && a.insecureHostKey {
+		hostKeyOpts = "-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null"
+	}
+	return append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes %s", a.sshKeyFile, hostKeyOpts))
+}
+
+func gitCacheDir(c *Client, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return c.settings.Home.Path("cache", "git", hex.EncodeToString(sum[:]))
+}
+
+func lockGitDir(dir string) func() {
+	gitLocks.Lock()
+	l, ok := gitLocks.m[dir]
+	if !ok {
+		l = &sync.Mutex{}
+		gitLocks.m[dir] = l
+	}
+	gitLocks.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func syncGitCache(dir string, url string, auth *gitAuth) error {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	if err == nil {
+		log.Debugf("Fetching %s", url)
+		_, err = runGit(dir, auth, "fetch", "--quiet", "--prune", "--tags", "--force", "origin", "+refs/heads/*:refs/remotes/origin/*")
+		return err
+	}
+
+	log.Debugf("Cloning %s to %s", url, dir)
+	err = os.MkdirAll(filepath.Dir(dir), 0700)
+	if err != nil {
+		return err
+	}
+	_, err = runGit(filepath.Dir(dir), auth, "clone", "--quiet", "--no-checkout", "--", url, dir)
+	if err != nil {
+		os.RemoveAll(dir) // nolint: errcheck
+	}
+	return err
+}
+
+func resolveGitRef(dir string, ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("Invalid git ref %q", ref)
+	}
+
+	// prefer branches, then tags, then anything git can resolve, e.g. a commit
+	candidates := []string{
+		"refs/remotes/origin/" + ref,
+		"refs/tags/" + ref,
+		ref,
+	}
+	for _, candidate := range candidates {
+		out, err := runGit(dir, &gitAuth{}, "rev-parse", "--verify", "--quiet", candidate+"^{commit}")
+		if err == nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+	}
+	return "", fmt.Errorf("Failed to resolve git ref %q", ref)
+}
+
+func extractGitTree(dir string, commit string, path string, dest string) error {
+	args := []string{"archive", "--format=tar", commit}
+	if path != "" {
+		args = append(args, "--", path)
+	}
+	out, err := runGit(dir, &gitAuth{}, args...)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(bytes.NewReader(out))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dest, hdr.Name)
+		if !strings.HasPrefix(target, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return fmt.Errorf("Invalid path %q in git archive", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeTarFile(tr, target)
+		default:
+			// symlinks and other special files are not part of a chart
+			log.Debugf("Skipping %s in git archive", hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeTarFile(r io.Reader, target string) error {
+	err := os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func runGit(dir string, auth *gitAuth, args ...string) ([]byte, error) {
+	// the file transport is refused even if the remote redirects to it
+	gitArgs := append([]string{"-c", "protocol.file.allow=never"}, auth.args()...)
+	cmd := exec.Command("git", append(gitArgs, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), auth.env()...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,338 @@
+package lmhelm
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testGitRepo is a git repository in a temporary directory
+type testGitRepo struct {
+	t   *testing.T
+	dir string
+	// authorized counts the requests with the credentials served by serve
+	authorized int32
+	servers    []*httptest.Server
+	caDir      string
+}
+
+func newTestGitRepo(t *testing.T) *testGitRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "chartmgr-git-repo-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &testGitRepo{t: t, dir: dir}
+	repo.git("init", "--quiet")
+	return repo
+}
+
+func (g *testGitRepo) git(args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		g.t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitChart commits the chart with the version at the path and returns
+// the commit
+func (g *testGitRepo) commitChart(path string, version string) string {
+	dir := filepath.Join(g.dir, path)
+	err := os.MkdirAll(filepath.Join(dir, "templates"), 0700)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("name: app\nversion: "+version+"\n"), 0600)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	g.git("add", "--all")
+	g.git("commit", "--quiet", "-m", version)
+	return g.git("rev-parse", "HEAD")
+}
+
+// serve serves the repository over HTTPS with git http-backend, requiring
+// the credentials if the username isn't empty. it returns the URL of the
+// repository and a file holding the CA of the server.
+func (g *testGitRepo) serve(username string, token string) (string, string) {
+	backend, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		g.t.Fatal(err)
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if username != "" {
+			u, p, ok := req.BasicAuth()
+			if !ok || u != username || p != token {
+				w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			atomic.AddInt32(&g.authorized, 1)
+		}
+		h := &cgi.Handler{
+			Path: filepath.Join(strings.TrimSpace(string(backend)), "git-http-backend"),
+			Env:  []string{"GIT_PROJECT_ROOT=" + filepath.Dir(g.dir), "GIT_HTTP_EXPORT_ALL=1"},
+		}
+		h.ServeHTTP(w, req)
+	}))
+	g.servers = append(g.servers, server)
+
+	if g.caDir == "" {
+		g.caDir, err = ioutil.TempDir("", "chartmgr-git-ca-")
+		if err != nil {
+			g.t.Fatal(err)
+		}
+	}
+	caFile := filepath.Join(g.caDir, fmt.Sprintf("ca-%d.pem", len(g.servers)))
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+	err = ioutil.WriteFile(caFile, ca, 0600)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	return server.URL + "/" + filepath.Base(g.dir), caFile
+}
+
+func (g *testGitRepo) close() {
+	for _, server := range g.servers {
+		server.Close()
+	}
+	os.RemoveAll(g.dir)   // nolint: errcheck
+	os.RemoveAll(g.caDir) // nolint: errcheck
+}
+
+func TestGetGitChart(t *testing.T) {
+	repo := newTestGitRepo(t)
+	defer repo.close()
+	first := repo.commitChart("charts/app", "1.0.0")
+	repo.git("tag", "v1")
+	second := repo.commitChart("charts/app", "2.0.0")
+	url, caFile := repo.serve("", "")
+
+	r, _, cleanup := newTestRelease(t, &config.Config{CABundleFiles: []string{caFile}})
+	defer cleanup()
+
+	tests := []struct {
+		name        string
+		url         string
+		ref         string
+		path        string
+		wantCommit  string
+		wantVersion string
+		wantErr     string
+	}{
+		{name: "default branch", path: "charts/app", wantCommit: second, wantVersion: "2.0.0"},
+		{name: "tag", ref: "v1", path: "charts/app", wantCommit: first, wantVersion: "1.0.0"},
+		{name: "commit", ref: first, path: "charts/app", wantCommit: first, wantVersion: "1.0.0"},
+		{name: "missing ref", ref: "missing", path: "charts/app", wantErr: `Failed to resolve git ref "missing"`},
+		{name: "missing path", path: "charts/other", wantErr: "charts/other"},
+		{name: "option as ref", ref: "--output=/tmp/chart", path: "charts/app", wantErr: `Invalid git ref "--output=/tmp/chart"`},
+		{name: "local path", url: repo.dir, path: "charts/app", wantErr: "Unsupported git URL"},
+		{name: "file URL", url: "file://" + repo.dir, path: "charts/app", wantErr: "Unsupported git URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.url == "" {
+				tt.url = url
+			}
+			r.Chartmgr.Spec.Chart = &crv1alpha1.ChartMgrChart{
+				Git: &crv1alpha1.ChartMgrChartGit{URL: tt.url, Ref: tt.ref, Path: tt.path},
+			}
+			ch, commit, err := getGitChart(r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("getGitChart() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if commit != tt.wantCommit || ch.Metadata.Version != tt.wantVersion {
+				t.Errorf("chart %s at %s, want %s at %s", ch.Metadata.Version, commit, tt.wantVersion, tt.wantCommit)
+			}
+		})
+	}
+
+	// the cached clone is fetched again for new commits
+	third := repo.commitChart("charts/app", "3.0.0")
+	r.Chartmgr.Spec.Chart = &crv1alpha1.ChartMgrChart{Git: &crv1alpha1.ChartMgrChartGit{URL: url}}
+	commit, err := r.Client.GitCommit(r.Chartmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != third {
+		t.Errorf("GitCommit() = %s, want %s", commit, third)
+	}
+}
+
+func TestLoadGitAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     map[string][]byte
+		insecure bool
+		wantErr  string
+		wantEnv  []string
+		wantArgs bool
+	}{
+		{
+			name:    "ssh key without known hosts",
+			data:    map[string][]byte{GitSecretSSHKeyKey: []byte("key")},
+			wantErr: "has no known_hosts",
+		},
+		{
+			name:     "ssh key without known hosts opted in",
+			data:     map[string][]byte{GitSecretSSHKeyKey: []byte("key")},
+			insecure: true,
+			wantEnv:  []string{"-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null"},
+		},
+		{
+			name:     "ssh key with known hosts",
+			data:     map[string][]byte{GitSecretSSHKeyKey: []byte("key"), GitSecretKnownHostsKey: []byte("host ssh-rsa AAAA")},
+			insecure: true,
+			wantEnv:  []string{"-o StrictHostKeyChecking=yes -o UserKnownHostsFile=/"},
+		},
+		{
+			name:     "token",
+			data:     map[string][]byte{GitSecretTokenKey: []byte("s3cr3t-t0ken")},
+			wantEnv:  []string{gitUsernameEnv + "=git", gitTokenEnv + "=s3cr3t-t0ken"},
+			wantArgs: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "git", Namespace: "tenant"},
+				Data:       tt.data,
+			}
+			r, _, cleanup := newTestRelease(t, &config.Config{}, secret)
+			defer cleanup()
+			r.Chartmgr.Spec.Chart = &crv1alpha1.ChartMgrChart{Git: &crv1alpha1.ChartMgrChartGit{
+				URL:                             "git@example.com:app.git",
+				SecretRef:                       &crv1alpha1.ChartMgrRepositorySecretRef{Name: "git"},
+				InsecureSkipHostKeyVerification: tt.insecure,
+			}}
+
+			auth, err := r.Client.loadGitAuth(r.Chartmgr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadGitAuth() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer auth.cleanup()
+
+			env := strings.Join(auth.env(), "\n")
+			for _, want := range tt.wantEnv {
+				if !strings.Contains(env, want) {
+					t.Errorf("env doesn't contain %q:\n%s", want, env)
+				}
+			}
+			args := strings.Join(auth.args(), " ")
+			if (args != "") != tt.wantArgs {
+				t.Errorf("args = %q", args)
+			}
+			if token := string(tt.data[GitSecretTokenKey]); token != "" && strings.Contains(args, token) {
+				t.Errorf("args contain the token: %q", args)
+			}
+		})
+	}
+}
+
+// TestGitHTTPCredentials clones a repository served by git http-backend,
+// which requires the credentials of the secret
+func TestGitHTTPCredentials(t *testing.T) {
+	repo := newTestGitRepo(t)
+	defer repo.close()
+	commit := repo.commitChart("", "1.0.0")
+	url, caFile := repo.serve("deploy", "s3cr3t-t0ken")
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "git", Namespace: "tenant"},
+		Data: map[string][]byte{
+			GitSecretUsernameKey: []byte("deploy"),
+			GitSecretTokenKey:    []byte("s3cr3t-t0ken"),
+		},
+	}
+	r, _, cleanup := newTestRelease(t, &config.Config{CABundleFiles: []string{caFile}}, secret)
+	defer cleanup()
+	r.Chartmgr.Spec.Chart = &crv1alpha1.ChartMgrChart{Git: &crv1alpha1.ChartMgrChartGit{
+		URL:       url,
+		SecretRef: &crv1alpha1.ChartMgrRepositorySecretRef{Name: "git"},
+	}}
+
+	got, err := r.Client.GitCommit(r.Chartmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != commit {
+		t.Errorf("GitCommit() = %s, want %s", got, commit)
+	}
+	if atomic.LoadInt32(&repo.authorized) == 0 {
+		t.Error("the server received no authorized requests")
+	}
+
+	// without the secret the clone is refused. the URL differs so that the
+	// cached clone isn't reused.
+	r.Chartmgr.Spec.Chart.Git.SecretRef = nil
+	r.Chartmgr.Spec.Chart.Git.URL += "/"
+	_, err = r.Client.GitCommit(r.Chartmgr)
+	if err == nil {
+		t.Error("GitCommit() succeeded without credentials")
+	}
+}
+
+func TestValidateGitURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "https://example.com/org/app.git"},
+		{url: "ssh://git@example.com:2222/org/app.git"},
+		{url: "git@example.com:org/app.git"},
+		{url: "example.com:app.git"},
+		{url: "http://example.com/org/app.git", wantErr: true},
+		{url: "file:///home/chartmgr/.helm/cache/git/0123", wantErr: true},
+		{url: "/home/chartmgr/.helm/cache/git/0123", wantErr: true},
+		{url: "../app", wantErr: true},
+		{url: "./dir:app", wantErr: true},
+		{url: "ext::sh -c touch% /tmp/pwned", wantErr: true},
+		{url: "--upload-pack=touch /tmp/pwned", wantErr: true},
+		{url: "-u:app", wantErr: true},
+		{url: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := validateGitURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateGitURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
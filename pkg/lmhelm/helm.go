@@ -1,25 +1,49 @@
 package lmhelm
 
 import (
-	"fmt"
+	"time"
 
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
 	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/helm/pkg/helm"
 	helm_env "k8s.io/helm/pkg/helm/environment"
-	"k8s.io/helm/pkg/helm/portforwarder"
+	"k8s.io/helm/pkg/helm/helmpath"
 	"k8s.io/helm/pkg/proto/hapi/chart"
 	rspb "k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/repo"
 )
 
+// Interface is the API of the helm client used by the controller. It is
+// implemented by Client, which manages releases through a ReleaseBackend
+// that package fake implements in memory.
+type Interface interface {
+	NewRelease(chartmgr *crv1alpha1.ChartManager) *Release
+	Config() *config.Config
+	GitCommit(chartmgr *crv1alpha1.ChartManager) (string, error)
+	ChartDigest(chartmgr *crv1alpha1.ChartManager) (string, error)
+	RefreshRepository(repository *crv1alpha1.ChartRepository) (int, error)
+	RemoveDependencyLock(chartmgr *crv1alpha1.ChartManager) error
+	ManagedReleases() ([]*ManagedRelease, error)
+	PurgeRelease(rls *ManagedRelease) error
+	Ready() error
+	Reconnected() <-chan struct{}
+}
+
 // Client represents the LM helm client wrapper
 type Client struct {
-	Helm           *helm.Client
+	backend        ReleaseBackend
+	tillers        *tillerPool
 	chartmgrconfig *config.Config
+	kubeClient     kubernetes.Interface
 	restConfig     *rest.Config
 	settings       helm_env.EnvSettings
+	charts         *chartCache
+	repos          *repoRegistry
+	defaultRepos   []defaultRepo
+	network        *networkConfig
+	variables      clusterVariables
 }
 
 // Init initializes the LM helm wrapper struct
@@ -29,47 +53,69 @@ func (c *Client) Init(chartmgrconfig *config.Config, config *rest.Config) error
 	c.settings = c.getHelmSettings()
 	c.restConfig = config
 
-	err := c.initRepos()
+	log.Debugf("Creating kubernetes client")
+	kubeClient, err := kubernetes.NewForConfig(c.restConfig)
 	if err != nil {
 		return err
 	}
+	c.kubeClient = kubeClient
+	log.Debugf("Created kubernetes client")
 
-	c.Helm, err = c.newHelmClient()
+	err = c.init()
+	if err != nil {
+		return err
+	}
+
+	c.backend, err = c.newReleaseBackend()
 	return err
 }
 
-// NewHeClient returns a helm client
-func (c *Client) newHelmClient() (*helm.Client, error) {
-	tillerHost, err := c.tillerHost()
+// NewClient returns a client managing releases through the backend instead
+// of the backend selected in the config, e.g. an in-memory backend for
+// tests. home is the helm home directory holding the repository and chart
+// caches.
+func NewClient(chartmgrconfig *config.Config, kubeClient kubernetes.Interface, backend ReleaseBackend, home string) (*Client, error) {
+	c := &Client{
+		backend:        backend,
+		chartmgrconfig: chartmgrconfig,
+		kubeClient:     kubeClient,
+	}
+	c.settings = c.getHelmSettings()
+	c.settings.Home = helmpath.Home(home)
+
+	err := c.init()
 	if err != nil {
 		return nil, err
 	}
-
-	log.Infof("Using tiller host %s", tillerHost)
-	heClient := helm.NewClient(helm.Host(tillerHost))
-	return heClient, nil
+	return c, nil
 }
 
-func (c *Client) tillerHost() (string, error) {
-	if c.settings.TillerHost != "" {
-		return c.settings.TillerHost, nil
+// init sets up the repositories, network configuration and chart cache
+func (c *Client) init() error {
+	err := validateReleaseNaming(c.chartmgrconfig)
+	if err != nil {
+		return err
 	}
 
-	log.Debugf("Creating kubernetes client")
-	client, err := kubernetes.NewForConfig(c.restConfig)
+	c.repos = newRepoRegistry(c.settings)
+
+	network, err := newNetworkConfig(c.chartmgrconfig, c.settings.Home.Path("cache", "ca-bundle.pem"))
 	if err != nil {
-		return "", err
+		return err
 	}
-	log.Debugf("Created kubernetes client")
+	c.network = network
 
-	log.Debugf("Setting up port forwarding tunnel to tiller")
-	tunnel, err := portforwarder.New(c.settings.TillerNamespace, client, c.restConfig)
+	err = c.initRepos()
 	if err != nil {
-		return "", err
+		return err
 	}
-	log.Debugf("Set up port forwarding tunnel to tiller")
 
-	return fmt.Sprintf("127.0.0.1:%d", tunnel.Local), nil
+	c.charts, err = newChartCache(
+		c.settings.Home.Path("cache", "charts"),
+		c.chartmgrconfig.ChartCacheMaxSizeMB*1024*1024,
+		time.Duration(c.chartmgrconfig.ChartCacheMaxAgeSec)*time.Second,
+	)
+	return err
 }
 
 // HelmSettings returns the helm client settings
@@ -77,6 +123,11 @@ func (c *Client) HelmSettings() helm_env.EnvSettings {
 	return c.settings
 }
 
+// NewRelease returns the release of the chart manager
+func (c *Client) NewRelease(chartmgr *crv1alpha1.ChartManager) *Release {
+	return &Release{Client: c, Chartmgr: chartmgr}
+}
+
 // Config returns the client application settings
 func (c *Client) Config() *config.Config {
 	return c.chartmgrconfig
@@ -87,7 +138,14 @@ func (c *Client) initRepos() error {
 	if err != nil {
 		return err
 	}
-	return ensureDefaultRepos(c.settings)
+	// helm's chart downloader requires a repositories file. it is kept empty
+	// since charts are downloaded by URL with the credentials of their
+	// repository, and dependencies are resolved in a home of their own.
+	err = repo.NewRepoFile().WriteFile(c.settings.Home.RepositoryFile(), 0644)
+	if err != nil {
+		return err
+	}
+	return c.ensureDefaultRepos()
 }
 
 func (c *Client) getHelmSettings() helm_env.EnvSettings {
@@ -98,59 +156,36 @@ func (c *Client) getHelmSettings() helm_env.EnvSettings {
 }
 
 func getInstalledRelease(r *Release) (*rspb.Release, error) {
-	// try to list the release and determine if it already exists
-	rsp, err := r.Client.Helm.ListReleases(listOpts(r)...)
+	backend, err := r.backend()
 	if err != nil {
 		return nil, err
 	}
-
-	if rsp.Count < 1 {
-		log.Debugf("Helm release %s not found", r.Name())
-		return nil, nil
-	} else if rsp.Count > 1 {
-		return nil, fmt.Errorf("Multiple releases found for release %s", r.Name())
-	}
-	return rsp.Releases[0], nil
+	return backend.Get(r)
 }
 
 func helmInstall(r *Release, chart *chart.Chart, vals []byte) (*rspb.Release, error) {
 	log.Infof("Installing release %s", r.Name())
-	rsp, err := r.Client.Helm.InstallReleaseFromChart(chart, r.Chartmgr.ObjectMeta.Namespace, installOpts(r, vals)...)
-	if rsp == nil || rsp.Release == nil {
-		rls, _ := getInstalledRelease(r)
-		if rls != nil {
-			return rls, nil
-		}
-	} else {
-		return rsp.Release, nil
+	backend, err := r.backend()
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	return backend.Install(r, markManaged(r, chart), vals)
 }
 
 func helmUpdate(r *Release, chart *chart.Chart, vals []byte) (*rspb.Release, error) {
 	log.Infof("Updating release %s", r.Name())
-	rsp, err := r.Client.Helm.UpdateReleaseFromChart(r.Name(), chart, updateOpts(r, vals)...)
-	if rsp == nil || rsp.Release == nil {
-		rls, _ := getInstalledRelease(r)
-		if rls != nil {
-			return rls, nil
-		}
-	} else {
-		return rsp.Release, nil
+	backend, err := r.backend()
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	return backend.Upgrade(r, markManaged(r, chart), vals)
 }
 
 func helmDelete(r *Release) (*rspb.Release, error) {
 	log.Infof("Deleting release %s", r.Name())
-	rsp, err := r.Client.Helm.DeleteRelease(r.Name(), deleteOpts(r)...)
-	if rsp == nil || rsp.Release == nil {
-		rls, _ := getInstalledRelease(r)
-		if rls != nil {
-			return rls, nil
-		}
-	} else {
-		return rsp.Release, nil
+	backend, err := r.backend()
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	return backend.Delete(r)
 }
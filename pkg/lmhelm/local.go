@@ -0,0 +1,180 @@
+package lmhelm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// configMap is the subset of a ConfigMap holding a packaged chart. the
+// vendored core/v1 types predate binaryData, so it is decoded here.
+type configMap struct {
+	Data       map[string]string `json:"data,omitempty"`
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+}
+
+// getLocalChart loads the chart from a ConfigMap or the controller's
+// filesystem and returns it with the digest of its content
+func getLocalChart(r *Release) (*chart.Chart, string, error) {
+	c := r.Chartmgr.Spec.Chart
+	if c.ConfigMapRef != nil {
+		archive, err := r.Client.configMapChart(r.Chartmgr.ObjectMeta.Namespace, c.ConfigMapRef)
+		if err != nil {
+			return nil, "", err
+		}
+		helmChart, err := loadChartArchive(archive)
+		if err != nil {
+			return nil, "", err
+		}
+		return helmChart, digest.FromBytes(archive).String(), nil
+	}
+
+	path, err := r.Client.chartPath(c.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	d, err := pathDigest(path)
+	if err != nil {
+		return nil, "", err
+	}
+	helmChart, err := loadChart(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return helmChart, d.String(), nil
+}
+
+// ChartDigest returns the digest of the content of the chart manager's
+// ConfigMap or path chart source
+func (c *Client) ChartDigest(chartmgr *crv1alpha1.ChartManager) (string, error) {
+	ch := chartmgr.Spec.Chart
+	if ch.ConfigMapRef != nil {
+		archive, err := c.configMapChart(chartmgr.ObjectMeta.Namespace, ch.ConfigMapRef)
+		if err != nil {
+			return "", err
+		}
+		return digest.FromBytes(archive).String(), nil
+	}
+
+	path, err := c.chartPath(ch.Path)
+	if err != nil {
+		return "", err
+	}
+	d, err := pathDigest(path)
+	if err != nil {
+		return "", err
+	}
+	return d.String(), nil
+}
+
+// chartPath resolves the path of a path chart and checks that it is under
+// ChartPathRoot, so that chart managers can't read arbitrary files of the
+// controller. path charts are disabled unless ChartPathRoot is set.
+func (c *Client) chartPath(path string) (string, error) {
+	if c.chartmgrconfig.ChartPathRoot == "" {
+		return "", fmt.Errorf("Path charts are disabled. Set ChartPathRoot to allow charts under a directory")
+	}
+	root, err := filepath.EvalSymlinks(filepath.Clean(c.chartmgrconfig.ChartPathRoot))
+	if err != nil {
+		return "", fmt.Errorf("Invalid chart path root: %v", err)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Chart path %s is outside of the chart path root %s", path, c.chartmgrconfig.ChartPathRoot)
+	}
+	return resolved, nil
+}
+
+// configMapChart returns the packaged chart stored in the ConfigMap key,
+// either as binary data or base64 encoded
+func (c *Client) configMapChart(namespace string, ref *crv1alpha1.ChartMgrConfigMapKeyRef) ([]byte, error) {
+	log.Debugf("Reading chart from configmap %s/%s key %s", namespace, ref.Name, ref.Key)
+	raw, err := c.kubeClient.CoreV1().RESTClient().Get().
+		Namespace(namespace).
+		Resource("configmaps").
+		Name(ref.Name).
+		DoRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	cm := &configMap{}
+	err = json.Unmarshal(raw, cm)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := cm.BinaryData[ref.Key]; ok {
+		return v, nil
+	}
+	if v, ok := cm.Data[ref.Key]; ok {
+		archive, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("Key %s of configmap %s/%s is neither binary data nor base64: %v", ref.Key, namespace, ref.Name, err)
+		}
+		return archive, nil
+	}
+	return nil, fmt.Errorf("Key %s not found in configmap %s/%s", ref.Key, namespace, ref.Name)
+}
+
+// pathDigest returns the digest of a packaged chart, or of the names and
+// contents of the files of an unpacked chart
+func pathDigest(path string) (digest.Digest, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return fileDigest(path)
+	}
+
+	files := []string{}
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	digester := digest.Canonical.Digester()
+	for _, f := range files {
+		rel, err := filepath.Rel(path, f)
+		if err != nil {
+			return "", err
+		}
+		d, err := fileDigest(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(digester.Hash(), "%s %s\n", d, filepath.ToSlash(rel)) // nolint: errcheck
+	}
+	return digester.Digest(), nil
+}
+
+func fileDigest(path string) (digest.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() // nolint: errcheck
+	return digest.Canonical.FromReader(f)
+}
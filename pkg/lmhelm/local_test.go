@@ -0,0 +1,270 @@
+package lmhelm
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	"github.com/opencontainers/go-digest"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+// newTestLocalCharts saves the test chart unpacked in dir/app and packaged
+// as dir/app-1.0.0.tgz, and returns the archive
+func newTestLocalCharts(t *testing.T, dir string) []byte {
+	ch := newTestChart()
+	ch.Metadata.ApiVersion = chartutil.ApiVersionV1
+	err := chartutil.SaveDir(ch, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivePath, err := chartutil.Save(ch, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestGetLocalChart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chartmgr-local-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	archive := newTestLocalCharts(t, dir)
+	archiveDigest := digest.FromBytes(archive).String()
+
+	chartDir := filepath.Join(dir, "app")
+	dirDigest, err := pathDigest(chartDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a chart outside of the chart path root, and a link to it inside
+	outside, err := ioutil.TempDir("", "chartmgr-local-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside) // nolint: errcheck
+	newTestLocalCharts(t, outside)
+	link := filepath.Join(dir, "link")
+	err = os.Symlink(filepath.Join(outside, "app"), link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		configMap    map[string]interface{}
+		configMapRef *crv1alpha1.ChartMgrConfigMapKeyRef
+		path         string
+		disabled     bool
+		wantDigest   string
+		wantErr      string
+	}{
+		{
+			name:         "configmap binary data",
+			configMap:    map[string]interface{}{"binaryData": map[string][]byte{"chart.tgz": archive}},
+			configMapRef: &crv1alpha1.ChartMgrConfigMapKeyRef{Name: "charts", Key: "chart.tgz"},
+			wantDigest:   archiveDigest,
+		},
+		{
+			name:         "configmap base64 data",
+			configMap:    map[string]interface{}{"data": map[string]string{"chart.tgz": base64.StdEncoding.EncodeToString(archive)}},
+			configMapRef: &crv1alpha1.ChartMgrConfigMapKeyRef{Name: "charts", Key: "chart.tgz"},
+			wantDigest:   archiveDigest,
+		},
+		{
+			name:         "configmap key not base64",
+			configMap:    map[string]interface{}{"data": map[string]string{"chart.tgz": "not a chart"}},
+			configMapRef: &crv1alpha1.ChartMgrConfigMapKeyRef{Name: "charts", Key: "chart.tgz"},
+			wantErr:      "Key chart.tgz of configmap tenant/charts is neither binary data nor base64: illegal base64 data at input byte 3",
+		},
+		{
+			name:         "configmap key not a chart",
+			configMap:    map[string]interface{}{"binaryData": map[string][]byte{"chart.tgz": []byte("not a chart")}},
+			configMapRef: &crv1alpha1.ChartMgrConfigMapKeyRef{Name: "charts", Key: "chart.tgz"},
+			wantErr:      "gzip: invalid header",
+		},
+		{
+			name:         "missing configmap key",
+			configMap:    map[string]interface{}{"binaryData": map[string][]byte{"chart.tgz": archive}},
+			configMapRef: &crv1alpha1.ChartMgrConfigMapKeyRef{Name: "charts", Key: "other.tgz"},
+			wantErr:      "Key other.tgz not found in configmap tenant/charts",
+		},
+		{
+			name:         "missing configmap",
+			configMapRef: &crv1alpha1.ChartMgrConfigMapKeyRef{Name: "charts", Key: "chart.tgz"},
+			wantErr:      "the server could not find the requested resource (get configmaps charts)",
+		},
+		{
+			name:       "packaged path",
+			path:       filepath.Join(dir, "app-1.0.0.tgz"),
+			wantDigest: archiveDigest,
+		},
+		{
+			name:       "unpacked path",
+			path:       chartDir,
+			wantDigest: dirDigest.String(),
+		},
+		{
+			name:    "missing path",
+			path:    filepath.Join(dir, "missing"),
+			wantErr: "lstat " + filepath.Join(dir, "missing") + ": no such file or directory",
+		},
+		{
+			name:     "path charts disabled",
+			path:     chartDir,
+			disabled: true,
+			wantErr:  "Path charts are disabled. Set ChartPathRoot to allow charts under a directory",
+		},
+		{
+			name:    "path outside of the root",
+			path:    filepath.Join(outside, "app"),
+			wantErr: "Chart path " + filepath.Join(outside, "app") + " is outside of the chart path root " + dir,
+		},
+		{
+			name:    "parent of the root",
+			path:    dir + "/app/../..",
+			wantErr: "Chart path " + dir + "/app/../.. is outside of the chart path root " + dir,
+		},
+		{
+			name:    "link out of the root",
+			path:    link,
+			wantErr: "Chart path " + link + " is outside of the chart path root " + dir,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{ChartPathRoot: dir}
+			if tt.disabled {
+				cfg.ChartPathRoot = ""
+			}
+			r, api, cleanup := newTestRelease(t, cfg)
+			defer cleanup()
+			if tt.configMap != nil {
+				tt.configMap["metadata"] = map[string]string{"name": "charts", "namespace": "tenant"}
+				api.Set("configmaps", "tenant", "charts", tt.configMap)
+			}
+			r.Chartmgr.Spec.Chart = &crv1alpha1.ChartMgrChart{ConfigMapRef: tt.configMapRef, Path: tt.path}
+
+			ch, d, err := getLocalChart(r)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("getLocalChart() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ch.Metadata.Name != "app" || len(ch.Templates) == 0 || len(ch.Dependencies) != 1 {
+				t.Errorf("loaded chart %s with %d templates and %d dependencies", ch.Metadata.Name, len(ch.Templates), len(ch.Dependencies))
+			}
+			if d != tt.wantDigest {
+				t.Errorf("digest = %s, want %s", d, tt.wantDigest)
+			}
+
+			// the poller sees the digest of the installed chart
+			polled, err := r.Client.ChartDigest(r.Chartmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if polled != d {
+				t.Errorf("ChartDigest() = %s, want %s", polled, d)
+			}
+		})
+	}
+}
+
+func TestPathDigest(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(dir string) error
+		wantSame bool
+	}{
+		{
+			name:     "unchanged",
+			change:   func(dir string) error { return nil },
+			wantSame: true,
+		},
+		{
+			name: "touched",
+			change: func(dir string) error {
+				return ioutil.WriteFile(filepath.Join(dir, "values.yaml"), []byte("color: blue\n"), 0600)
+			},
+			wantSame: true,
+		},
+		{
+			name: "modified file",
+			change: func(dir string) error {
+				return ioutil.WriteFile(filepath.Join(dir, "values.yaml"), []byte("color: red\n"), 0600)
+			},
+		},
+		{
+			name: "renamed file",
+			change: func(dir string) error {
+				return os.Rename(filepath.Join(dir, "values.yaml"), filepath.Join(dir, "values.yml"))
+			},
+		},
+		{
+			name: "added file",
+			change: func(dir string) error {
+				return ioutil.WriteFile(filepath.Join(dir, "templates", "service.yaml"), []byte{}, 0600)
+			},
+		},
+		{
+			name: "removed file",
+			change: func(dir string) error {
+				return os.Remove(filepath.Join(dir, "templates", "configmap.yaml"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "chartmgr-local-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir) // nolint: errcheck
+			err = os.MkdirAll(filepath.Join(dir, "templates"), 0700)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range map[string]string{
+				"Chart.yaml":               "name: app\nversion: 1.0.0\n",
+				"values.yaml":              "color: blue\n",
+				"templates/configmap.yaml": "kind: ConfigMap\n",
+			} {
+				err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			before, err := pathDigest(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.change(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := pathDigest(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (before == after) != tt.wantSame {
+				t.Errorf("digest changed from %s to %s, want same %v", before, after, tt.wantSame)
+			}
+		})
+	}
+}
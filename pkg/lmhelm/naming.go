@@ -0,0 +1,89 @@
+package lmhelm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/constants"
+)
+
+const (
+	// ReleaseNamingUID names releases after the chart manager uid. the name
+	// changes when the chart manager is recreated.
+	ReleaseNamingUID = "uid"
+	// ReleaseNamingName names releases after the chart manager namespace and
+	// name, followed by a hash of them if they had to be shortened
+	ReleaseNamingName = "name"
+	// ReleaseNamingHash names releases after a hash of the chart manager
+	// namespace and name
+	ReleaseNamingHash = "hash"
+)
+
+const (
+	// ReleaseNameMigrationAdopt keeps managing a release created with the uid
+	// naming under its name
+	ReleaseNameMigrationAdopt = "adopt"
+	// ReleaseNameMigrationRename deletes a release created with the uid naming
+	// and installs it under the name of the configured naming
+	ReleaseNameMigrationRename = "rename"
+)
+
+// helm stores releases in config maps labeled with the release name, which
+// limits it to 53 characters
+const maxReleaseNameLen = 53
+
+// length of the hashes in generated release names, in hex characters
+const (
+	releaseNameHashLen   = 16
+	releaseNameSuffixLen = 8
+)
+
+func validateReleaseNaming(chartmgrconfig *config.Config) error {
+	switch chartmgrconfig.ReleaseNaming {
+	case "", ReleaseNamingUID, ReleaseNamingName, ReleaseNamingHash:
+	default:
+		return fmt.Errorf("Unknown release naming %q. Supported namings: %s, %s, %s", chartmgrconfig.ReleaseNaming, ReleaseNamingUID, ReleaseNamingName, ReleaseNamingHash)
+	}
+	switch chartmgrconfig.ReleaseNameMigration {
+	case "", ReleaseNameMigrationAdopt, ReleaseNameMigrationRename:
+	default:
+		return fmt.Errorf("Unknown release name migration %q. Supported migrations: %s, %s", chartmgrconfig.ReleaseNameMigration, ReleaseNameMigrationAdopt, ReleaseNameMigrationRename)
+	}
+	return nil
+}
+
+// generatedReleaseName returns the name of the release of a chart manager
+// without an explicit release name
+func generatedReleaseName(naming string, chartmgr *crv1alpha1.ChartManager) string {
+	switch naming {
+	case ReleaseNamingName:
+		// charts name resources after the release, so the name only uses
+		// characters valid in DNS-1035 labels. the hash keeps names unique
+		// when they are shortened to fit the limit.
+		name := fmt.Sprintf("%s-%s-%s", constants.ReleaseNamePrefix, chartmgr.ObjectMeta.Namespace, chartmgr.ObjectMeta.Name)
+		if len(name) <= maxReleaseNameLen {
+			return name
+		}
+		name = strings.TrimRight(name[:maxReleaseNameLen-releaseNameSuffixLen-1], "-")
+		return name + "-" + chartmgrHash(chartmgr)[:releaseNameSuffixLen]
+	case ReleaseNamingHash:
+		return fmt.Sprintf("%s-%s", constants.ReleaseNamePrefix, chartmgrHash(chartmgr)[:releaseNameHashLen])
+	default:
+		return LegacyReleaseName(chartmgr)
+	}
+}
+
+// LegacyReleaseName returns the name of the release of the chart manager with
+// the uid naming: chartmgr-rls-[chartmgr uid]
+func LegacyReleaseName(chartmgr *crv1alpha1.ChartManager) string {
+	return fmt.Sprintf("%s-%s", constants.ReleaseNamePrefix, chartmgr.ObjectMeta.UID)
+}
+
+func chartmgrHash(chartmgr *crv1alpha1.ChartManager) string {
+	sum := sha256.Sum256([]byte(chartmgr.ObjectMeta.Namespace + "/" + chartmgr.ObjectMeta.Name))
+	return hex.EncodeToString(sum[:])
+}
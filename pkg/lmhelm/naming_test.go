@@ -0,0 +1,135 @@
+package lmhelm
+
+import (
+	"strings"
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestGeneratedReleaseName(t *testing.T) {
+	long := strings.Repeat("a", 60)
+	tests := []struct {
+		name      string
+		naming    string
+		namespace string
+		chartmgr  string
+		want      string
+	}{
+		{
+			name:      "uid",
+			naming:    ReleaseNamingUID,
+			namespace: "default",
+			chartmgr:  "app",
+			want:      "chartmgr-rls-1234",
+		},
+		{
+			name:      "uid by default",
+			namespace: "default",
+			chartmgr:  "app",
+			want:      "chartmgr-rls-1234",
+		},
+		{
+			name:      "namespace and name",
+			naming:    ReleaseNamingName,
+			namespace: "default",
+			chartmgr:  "app",
+			want:      "chartmgr-rls-default-app",
+		},
+		{
+			name:      "long namespace and name",
+			naming:    ReleaseNamingName,
+			namespace: "default",
+			chartmgr:  long,
+			want:      "chartmgr-rls-default-aaaaaaaaaaaaaaaaaaaaaaa-bf5d8047",
+		},
+		{
+			name:      "name at the limit",
+			naming:    ReleaseNamingName,
+			namespace: "default",
+			chartmgr:  strings.Repeat("a", 32),
+			want:      "chartmgr-rls-default-" + strings.Repeat("a", 32),
+		},
+		{
+			name:      "shortened at a dash",
+			naming:    ReleaseNamingName,
+			namespace: "default",
+			chartmgr:  strings.Repeat("a", 22) + "-" + long,
+			want:      "chartmgr-rls-default-aaaaaaaaaaaaaaaaaaaaaa-aaca5631",
+		},
+		{
+			name:      "hash",
+			naming:    ReleaseNamingHash,
+			namespace: "default",
+			chartmgr:  "app",
+			want:      "chartmgr-rls-a1989cdf73cb2113",
+		},
+		{
+			name:      "hash of a long name",
+			naming:    ReleaseNamingHash,
+			namespace: "default",
+			chartmgr:  long,
+			want:      "chartmgr-rls-bf5d804723820b72",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chartmgr := &crv1alpha1.ChartManager{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      tt.chartmgr,
+					Namespace: tt.namespace,
+					UID:       "1234",
+				},
+			}
+			got := generatedReleaseName(tt.naming, chartmgr)
+			if got != tt.want {
+				t.Errorf("generatedReleaseName() = %q, want %q", got, tt.want)
+			}
+			if len(got) > maxReleaseNameLen {
+				t.Errorf("generatedReleaseName() = %q is longer than %d characters", got, maxReleaseNameLen)
+			}
+			if errs := validation.IsDNS1035Label(got); len(errs) > 0 {
+				t.Errorf("generatedReleaseName() = %q isn't a valid resource name: %s", got, strings.Join(errs, ", "))
+			}
+		})
+	}
+}
+
+func TestInstallGeneratedReleaseName(t *testing.T) {
+	tests := []struct {
+		name     string
+		naming   string
+		chartmgr string
+		want     string
+	}{
+		{name: "uid", naming: ReleaseNamingUID, chartmgr: "app", want: "chartmgr-rls-1234"},
+		{name: "namespace and name", naming: ReleaseNamingName, chartmgr: "app", want: "chartmgr-rls-tenant-app"},
+		{name: "long namespace and name", naming: ReleaseNamingName, chartmgr: strings.Repeat("a", 60), want: "chartmgr-rls-tenant-aaaaaaaaaaaaaaaaaaaaaaaa-345daf65"},
+		{name: "hash", naming: ReleaseNamingHash, chartmgr: "app", want: "chartmgr-rls-377d49c7dc38a278"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, b, resources, _, cleanup := newTestStorageBackend(t)
+			defer cleanup()
+			r.name = ""
+			r.Client.chartmgrconfig.ReleaseNaming = tt.naming
+			r.Chartmgr.ObjectMeta.Name = tt.chartmgr
+
+			// the chart names its service after the release
+			_, err := b.Install(r, newTestStorageChart(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Name() != tt.want {
+				t.Errorf("release name = %q, want %q", r.Name(), tt.want)
+			}
+			service := "Service " + tt.want + "-app"
+			if calls := strings.Join(resources.calls, ","); !strings.Contains(calls, service) {
+				t.Errorf("install calls = %s, want %s", calls, service)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package lmhelm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// networkConfig is the proxy and CA configuration of the traffic to chart
+// repositories, OCI registries and git repositories. without a configured
+// proxy the proxy environment variables are used.
+type networkConfig struct {
+	fromEnv    bool
+	httpProxy  *url.URL
+	httpsProxy *url.URL
+	noProxy    []string
+	caFiles    []string
+	rootCAs    *x509.CertPool
+	// caBundle is a PEM file of the system roots and the CA bundles for git
+	caBundle string
+}
+
+// systemCAFiles are the locations of the system roots, as searched by
+// crypto/x509
+var systemCAFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// newNetworkConfig returns the network configuration. if CA bundles are
+// configured they are written to caBundle together with the system roots,
+// since git can only be given a single CA file.
+func newNetworkConfig(chartmgrconfig *config.Config, caBundle string) (*networkConfig, error) {
+	n := &networkConfig{
+		fromEnv: chartmgrconfig.HTTPProxy == "" && chartmgrconfig.HTTPSProxy == "",
+		noProxy: splitNoProxy(chartmgrconfig.NoProxy),
+	}
+
+	var err error
+	n.httpProxy, err = parseProxyURL(chartmgrconfig.HTTPProxy)
+	if err != nil {
+		return nil, err
+	}
+	n.httpsProxy, err = parseProxyURL(chartmgrconfig.HTTPSProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chartmgrconfig.CABundleFiles) > 0 {
+		n.caFiles = chartmgrconfig.CABundleFiles
+		n.rootCAs, err = loadCABundles(n.caFiles)
+		if err != nil {
+			return nil, err
+		}
+		err = writeCABundle(caBundle, n.caFiles)
+		if err != nil {
+			return nil, err
+		}
+		n.caBundle = caBundle
+	}
+	return n, nil
+}
+
+// withProxy returns a copy of the configuration using the chart repository's
+// proxy for all traffic
+func (n *networkConfig) withProxy(p *crv1alpha1.ChartRepositoryProxy, username string, password string) (*networkConfig, error) {
+	proxyURL, err := parseProxyURL(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil && (username != "" || password != "") {
+		proxyURL.User = url.UserPassword(username, password)
+	}
+
+	override := *n
+	override.fromEnv = false
+	override.httpProxy = proxyURL
+	override.httpsProxy = proxyURL
+	override.noProxy = p.NoProxy
+	return &override, nil
+}
+
+// proxy returns the proxy for the request, or nil for a direct connection
+func (n *networkConfig) proxy(req *http.Request) (*url.URL, error) {
+	if n.fromEnv {
+		return http.ProxyFromEnvironment(req)
+	}
+	proxyURL := n.httpProxy
+	if req.URL.Scheme == "https" {
+		proxyURL = n.httpsProxy
+	}
+	if proxyURL == nil || n.bypass(req.URL) {
+		return nil, nil
+	}
+	return proxyURL, nil
+}
+
+// bypass returns true if the URL matches an entry of the no-proxy list. an
+// entry is "*", an IP address or CIDR, a host or domain optionally with a
+// leading ".", or a host and port.
+func (n *networkConfig) bypass(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	ip := net.ParseIP(host)
+	for _, entry := range n.noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			_, cidr, err := net.ParseCIDR(entry)
+			if err == nil && ip != nil && cidr.Contains(ip) {
+				return true
+			}
+		case net.ParseIP(entry) != nil:
+			if ip != nil && ip.Equal(net.ParseIP(entry)) {
+				return true
+			}
+		default:
+			if h, port, err := net.SplitHostPort(entry); err == nil {
+				if port != u.Port() {
+					continue
+				}
+				entry = h
+			}
+			entry = strings.TrimPrefix(entry, ".")
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// env returns the proxy and CA environment variables for git
+func (n *networkConfig) env() []string {
+	if n == nil {
+		return nil
+	}
+	env := []string{}
+	if n.caBundle != "" {
+		env = append(env, "GIT_SSL_CAINFO="+n.caBundle)
+	}
+	if n.fromEnv {
+		return env
+	}
+	if n.httpProxy != nil {
+		env = append(env, "http_proxy="+n.httpProxy.String())
+	}
+	if n.httpsProxy != nil {
+		env = append(env, "https_proxy="+n.httpsProxy.String())
+	}
+	return append(env, "no_proxy="+strings.Join(n.noProxy, ","))
+}
+
+// transport returns an http transport using the proxy and root CAs. it is
+// created per client since the TLS configuration may be repository specific.
+func (n *networkConfig) transport() *http.Transport {
+	t := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if n != nil {
+		t.Proxy = n.proxy
+	}
+	return t
+}
+
+// httpClient returns a client using the proxy and root CAs
+func (n *networkConfig) httpClient() *http.Client {
+	if n == nil {
+		return http.DefaultClient
+	}
+	t := n.transport()
+	t.TLSClientConfig = &tls.Config{RootCAs: n.rootCAs}
+	return &http.Client{Transport: t}
+}
+
+// certPool returns the root CAs extended with the CA file, or nil to use
+// the system roots
+func (n *networkConfig) certPool(caFile string) (*x509.CertPool, error) {
+	if caFile == "" {
+		if n == nil {
+			return nil, nil
+		}
+		return n.rootCAs, nil
+	}
+	if n == nil || n.rootCAs == nil {
+		// the repository CA replaces the system roots unless CA bundles are
+		// configured, which TLS inspecting proxies rely on
+		pool := x509.NewCertPool()
+		return pool, appendCAFile(pool, caFile)
+	}
+
+	// the shared pool must not be modified, so it is loaded again
+	pool, err := loadCABundles(n.caFiles)
+	if err != nil {
+		return nil, err
+	}
+	return pool, appendCAFile(pool, caFile)
+}
+
+func parseProxyURL(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy URL %q", s)
+	}
+	return u, nil
+}
+
+func splitNoProxy(s string) []string {
+	entries := []string{}
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// loadCABundles returns the system roots extended with the certificates of
+// the CA bundle files
+func loadCABundles(files []string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		log.Warnf("Failed to load system root CAs: %v", err)
+		pool = x509.NewCertPool()
+	}
+	for _, f := range files {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		err = appendCAFile(pool, f)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("Loaded CA bundle %s", f)
+	}
+	return pool, nil
+}
+
+// writeCABundle writes the system roots and the CA bundle files to a single
+// PEM file
+func writeCABundle(filename string, files []string) error {
+	var bundle bytes.Buffer
+	system := os.Getenv("SSL_CERT_FILE")
+	if system == "" {
+		for _, f := range systemCAFiles {
+			if _, err := os.Stat(f); err == nil {
+				system = f
+				break
+			}
+		}
+	}
+	if system == "" {
+		log.Warnf("No system root CAs found, git trusts the CA bundles only")
+	}
+
+	for _, f := range append([]string{system}, files...) {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		pem, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		bundle.Write(pem)        // nolint: errcheck
+		bundle.WriteString("\n") // nolint: errcheck
+	}
+
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, bundle.Bytes(), 0644)
+}
+
+func appendCAFile(pool *x509.CertPool, file string) error {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("No certificates found in CA bundle %s", file)
+	}
+	return nil
+}
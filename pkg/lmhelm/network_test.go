@@ -0,0 +1,83 @@
+package lmhelm
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+)
+
+func TestNetworkConfigGitEnv(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "chartmgr-network-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, ca, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  *config.Config
+		wantEnv []string
+	}{
+		{
+			name:    "defaults",
+			config:  &config.Config{},
+			wantEnv: []string{},
+		},
+		{
+			name:    "proxy",
+			config:  &config.Config{HTTPSProxy: "proxy:3128", NoProxy: "localhost"},
+			wantEnv: []string{"https_proxy=http://proxy:3128", "no_proxy=localhost"},
+		},
+		{
+			name:    "CA bundles",
+			config:  &config.Config{CABundleFiles: []string{caFile}},
+			wantEnv: []string{"GIT_SSL_CAINFO=" + filepath.Join(dir, "bundle.pem")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := newNetworkConfig(tt.config, filepath.Join(dir, "bundle.pem"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := n.env()
+			if strings.Join(env, " ") != strings.Join(tt.wantEnv, " ") {
+				t.Errorf("env() = %q, want %q", env, tt.wantEnv)
+			}
+			if len(tt.config.CABundleFiles) < 1 {
+				return
+			}
+
+			// the bundle also trusts the server for the HTTP client
+			resp, err := n.httpClient().Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close() // nolint: errcheck
+			bundle, err := ioutil.ReadFile(filepath.Join(dir, "bundle.pem"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(bundle, ca) {
+				t.Error("the git CA bundle doesn't contain the CA")
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package lmhelm
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/redact"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+const (
+	// OCIChartLayerMediaType is the media type of the chart layer of an OCI chart artifact
+	OCIChartLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	// OCILegacyChartLayerMediaType is the chart layer media type of artifacts pushed by early helm releases
+	OCILegacyChartLayerMediaType = "application/tar+gzip"
+
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociMaxManifestSize   = 4 * 1024 * 1024
+	dockerHubDomain      = "docker.io"
+	dockerHubRegistry    = "registry-1.docker.io"
+)
+
+// ociManifest is the subset of an OCI image manifest needed to find the chart
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType string        `json:"mediaType"`
+	Digest    digest.Digest `json:"digest"`
+	Size      int64         `json:"size"`
+}
+
+// ociRegistry is a client of a single repository of an OCI registry
+type ociRegistry struct {
+	client     *http.Client
+	scheme     string
+	host       string
+	repository string
+	username   string
+	password   string
+	token      string
+}
+
+// getOCIChart pulls the chart from the OCI registry unless the chart cache
+// holds the chart layer. the layer is identified by its digest, which the
+// registry only lists in manifests the credentials are allowed to pull.
+func getOCIChart(r *Release) (*chart.Chart, error) {
+	o := r.Chartmgr.Spec.Chart.OCI
+	registry, ref, err := r.Client.newOCIRegistry(r.Chartmgr)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Fetching manifest of %s", o.Reference)
+	layer, err := registry.chartLayer(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := &chartRef{
+		repoURL: fmt.Sprintf("oci://%s/%s", registry.host, registry.repository),
+		name:    registry.repository,
+		version: layer.Digest.String(),
+		creds:   registry.identity(),
+	}
+	if layer.Digest.Algorithm() == digest.Canonical {
+		cached.digest = layer.Digest.Encoded()
+	}
+	archive, err := r.Client.charts.get(cached, func(dir string) (string, error) {
+		log.Debugf("Pulling chart %s@%s", o.Reference, layer.Digest)
+		filename := filepath.Join(dir, "chart.tgz")
+		return filename, registry.pullBlob(layer.Digest, filename)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadChartArchive(archive)
+}
+
+func (c *Client) newOCIRegistry(chartmgr *crv1alpha1.ChartManager) (*ociRegistry, string, error) {
+	o := chartmgr.Spec.Chart.OCI
+	named, err := reference.ParseNormalizedNamed(o.Reference)
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid OCI reference %s: %v", o.Reference, err)
+	}
+
+	registry := &ociRegistry{
+		client:     c.network.httpClient(),
+		scheme:     "https",
+		host:       reference.Domain(named),
+		repository: reference.Path(named),
+	}
+	if registry.host == dockerHubDomain {
+		registry.host = dockerHubRegistry
+	}
+	if o.PlainHTTP {
+		registry.scheme = "http"
+	}
+
+	if o.SecretRef != nil {
+		err = c.loadOCICredentials(registry, chartmgr.ObjectMeta.Namespace, o.SecretRef.Name)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	ref, err := ociRef(named, o, parseVersion(chartmgr))
+	if err != nil {
+		return nil, "", err
+	}
+	return registry, ref, nil
+}
+
+// ociRef returns the digest or tag to pull. an explicit digest wins over a
+// tag, which wins over the chart version.
+func ociRef(named reference.Named, o *crv1alpha1.ChartMgrChartOCI, version string) (string, error) {
+	switch {
+	case o.Digest != "":
+		d, err := digest.Parse(o.Digest)
+		if err != nil {
+			return "", fmt.Errorf("Invalid OCI digest %s: %v", o.Digest, err)
+		}
+		return d.String(), nil
+	case o.Tag != "":
+		return o.Tag, nil
+	}
+
+	if canonical, ok := named.(reference.Canonical); ok {
+		return canonical.Digest().String(), nil
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		return tagged.Tag(), nil
+	}
+	if version != "" {
+		return version, nil
+	}
+	return "", fmt.Errorf("OCI reference %s requires a tag, digest or chart version", o.Reference)
+}
+
+func (c *Client) loadOCICredentials(registry *ociRegistry, namespace string, name string) error {
+	log.Debugf("Reading registry credentials from secret %s/%s", namespace, name)
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	redactSecret(secret)
+
+	if data, ok := secret.Data[v1.DockerConfigJsonKey]; ok {
+		err = registry.dockerConfigCredentials(data)
+		if err != nil {
+			return err
+		}
+		// the password is only found in the docker config
+		redact.Set(fmt.Sprintf("registry %s/%s", namespace, name), []string{registry.password, registry.basicAuth()})
+		return nil
+	}
+	registry.username = string(secret.Data[RepoSecretUsernameKey])
+	registry.password = string(secret.Data[RepoSecretPasswordKey])
+	return nil
+}
+
+// basicAuth returns the encoded credentials as sent in basic auth headers and
+// docker configs
+func (o *ociRegistry) basicAuth() string {
+	return base64.StdEncoding.EncodeToString([]byte(o.username + ":" + o.password))
+}
+
+// dockerConfigCredentials reads the credentials of the registry from a
+// kubernetes.io/dockerconfigjson secret
+func (o *ociRegistry) dockerConfigCredentials(data []byte) error {
+	config := struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		return fmt.Errorf("Failed to parse %s: %v", v1.DockerConfigJsonKey, err)
+	}
+
+	for server, auth := range config.Auths {
+		if ociServerHost(server) != o.host {
+			continue
+		}
+		o.username = auth.Username
+		o.password = auth.Password
+		if auth.Auth == "" {
+			return nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return fmt.Errorf("Failed to decode registry credentials of %s: %v", server, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) == 2 {
+			o.username, o.password = parts[0], parts[1]
+		}
+		return nil
+	}
+	return nil
+}
+
+// identity identifies the credentials of the registry in chart cache keys
+func (o *ociRegistry) identity() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s%d:%s", len(o.username), o.username, len(o.password), o.password) // nolint: errcheck
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func ociServerHost(server string) string {
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		server = u.Host
+	}
+	if server == dockerHubDomain || server == "index.docker.io" {
+		return dockerHubRegistry
+	}
+	return server
+}
+
+// chartLayer fetches the manifest and returns its chart layer. manifests
+// referenced by digest are verified against the digest, so the chart is
+// exactly the one pinned.
+func (o *ociRegistry) chartLayer(ref string) (*ociDescriptor, error) {
+	resp, err := o.get(fmt.Sprintf("/v2/%s/manifests/%s", o.repository, ref), ociManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, ociMaxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > ociMaxManifestSize {
+		return nil, fmt.Errorf("Manifest of %s/%s:%s exceeds %d bytes", o.host, o.repository, ref, ociMaxManifestSize)
+	}
+	if d, err := digest.Parse(ref); err == nil {
+		if !d.Algorithm().Available() || d.Algorithm().FromBytes(body) != d {
+			return nil, fmt.Errorf("Digest mismatch fetching manifest of %s/%s@%s", o.host, o.repository, d)
+		}
+	}
+
+	manifest := &ociManifest{}
+	err = json.Unmarshal(body, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse manifest of %s/%s:%s: %v", o.host, o.repository, ref, err)
+	}
+	for i, layer := range manifest.Layers {
+		if layer.MediaType == OCIChartLayerMediaType || layer.MediaType == OCILegacyChartLayerMediaType {
+			return &manifest.Layers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("No chart layer found in %s/%s:%s", o.host, o.repository, ref)
+}
+
+// pullBlob downloads the blob and verifies its digest before moving it to
+// the destination
+func (o *ociRegistry) pullBlob(d digest.Digest, dest string) error {
+	err := d.Validate()
+	if err != nil {
+		return err
+	}
+
+	resp, err := o.get(fmt.Sprintf("/v2/%s/blobs/%s", o.repository, d), "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".pull-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+
+	verifier := d.Verifier()
+	_, err = io.Copy(io.MultiWriter(tmp, verifier), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("Digest mismatch pulling %s/%s@%s", o.host, o.repository, d)
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// get performs a GET against the registry, authenticating and retrying once
+// if the registry challenges the request
+func (o *ociRegistry) get(path string, accept string) (*http.Response, error) {
+	href := fmt.Sprintf("%s://%s%s", o.scheme, o.host, path)
+	resp, err := o.do(href, accept)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close() // nolint: errcheck
+		err = o.authenticate(challenge)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = o.do(href, accept)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close() // nolint: errcheck
+		return nil, fmt.Errorf("Failed to fetch %s : %s", href, resp.Status)
+	}
+	return resp, nil
+}
+
+func (o *ociRegistry) do(href string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", href, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	switch {
+	case o.token != "":
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	case o.username != "" || o.password != "":
+		req.SetBasicAuth(o.username, o.password)
+	}
+	return o.client.Do(req)
+}
+
+// authenticate fetches a bearer token as described by the challenge of the
+// registry. basic challenges need no token since the credentials are sent
+// with every request.
+func (o *ociRegistry) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") {
+		if o.username == "" && o.password == "" {
+			return fmt.Errorf("Registry %s requires credentials", o.host)
+		}
+		return nil
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("Invalid authentication realm %q of registry %s", params["realm"], o.host)
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", o.repository)
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if o.username != "" || o.password != "" {
+		req.SetBasicAuth(o.username, o.password)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to authenticate to registry %s : %s", o.host, resp.Status)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return fmt.Errorf("Failed to parse token of registry %s: %v", o.host, err)
+	}
+	o.token = token.Token
+	if o.token == "" {
+		o.token = token.AccessToken
+	}
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header of the form
+// Bearer realm="...",service="...",scope="..."
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				val, rest = rest[1:], ""
+			} else {
+				val, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			val, rest = rest[:comma], rest[comma:]
+		} else {
+			val, rest = rest, ""
+		}
+		params[key] = val
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return parts[0], params
+}
@@ -0,0 +1,183 @@
+package lmhelm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	"github.com/opencontainers/go-digest"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// testRegistry serves the chart app 1.0.0 as charts/app:1.0.0, requiring a
+// bearer token issued for the credentials deploy/s3cr3t
+type testRegistry struct {
+	server         *httptest.Server
+	manifest       []byte
+	manifestDigest digest.Digest
+	blob           []byte
+	blobDigest     digest.Digest
+	tamperManifest bool
+	tamperBlob     bool
+	blobPulls      int32
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	dir, err := ioutil.TempDir("", "chartmgr-oci-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	filename, err := chartutil.Save(&chart.Chart{Metadata: &chart.Metadata{Name: "app", Version: "1.0.0"}}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := &testRegistry{blob: blob, blobDigest: digest.FromBytes(blob)}
+	reg.manifest, err = json.Marshal(ociManifest{Layers: []ociDescriptor{
+		{MediaType: OCIChartLayerMediaType, Digest: reg.blobDigest, Size: int64(len(blob))},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.manifestDigest = digest.FromBytes(reg.manifest)
+	reg.server = httptest.NewServer(http.HandlerFunc(reg.serve))
+	return reg
+}
+
+func (reg *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		username, password, _ := req.BasicAuth()
+		if username != "deploy" || password != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token": "t0ken"}`) // nolint: errcheck
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer t0ken" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, reg.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch req.URL.Path {
+	case "/v2/charts/app/manifests/1.0.0", "/v2/charts/app/manifests/" + reg.manifestDigest.String():
+		body := reg.manifest
+		if reg.tamperManifest {
+			body = append(append([]byte{}, body...), '\n')
+		}
+		w.Write(body) // nolint: errcheck
+	case "/v2/charts/app/blobs/" + reg.blobDigest.String():
+		atomic.AddInt32(&reg.blobPulls, 1)
+		body := reg.blob
+		if reg.tamperBlob {
+			body = append([]byte{0}, body[1:]...)
+		}
+		w.Write(body) // nolint: errcheck
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (reg *testRegistry) newRelease(t *testing.T) (*Release, func()) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "tenant"},
+		Data: map[string][]byte{
+			RepoSecretUsernameKey: []byte("deploy"),
+			RepoSecretPasswordKey: []byte("s3cr3t"),
+		},
+	}
+	r, _, cleanup := newTestRelease(t, &config.Config{}, secret)
+	r.Chartmgr.Spec.Chart = &crv1alpha1.ChartMgrChart{OCI: &crv1alpha1.ChartMgrChartOCI{
+		Reference: strings.TrimPrefix(reg.server.URL, "http://") + "/charts/app",
+		Tag:       "1.0.0",
+		PlainHTTP: true,
+		SecretRef: &crv1alpha1.ChartMgrRepositorySecretRef{Name: "registry"},
+	}}
+	return r, cleanup
+}
+
+func TestGetOCIChart(t *testing.T) {
+	reg := newTestRegistry(t)
+	defer reg.server.Close()
+
+	tests := []struct {
+		name           string
+		digest         bool
+		tamperManifest bool
+		tamperBlob     bool
+		noCredentials  bool
+		wantErr        string
+	}{
+		{name: "tag"},
+		{name: "digest", digest: true},
+		{name: "tampered manifest", digest: true, tamperManifest: true, wantErr: "Digest mismatch fetching manifest"},
+		{name: "tampered blob", tamperBlob: true, wantErr: "Digest mismatch pulling"},
+		{name: "no credentials", noCredentials: true, wantErr: "Failed to authenticate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg.tamperManifest, reg.tamperBlob = tt.tamperManifest, tt.tamperBlob
+			r, cleanup := reg.newRelease(t)
+			defer cleanup()
+			if tt.digest {
+				r.Chartmgr.Spec.Chart.OCI.Digest = reg.manifestDigest.String()
+			}
+			if tt.noCredentials {
+				r.Chartmgr.Spec.Chart.OCI.SecretRef = nil
+			}
+
+			ch, err := getOCIChart(r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("getOCIChart() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ch.Metadata.Name != "app" || ch.Metadata.Version != "1.0.0" {
+				t.Errorf("chart = %s-%s, want app-1.0.0", ch.Metadata.Name, ch.Metadata.Version)
+			}
+		})
+	}
+}
+
+func TestGetOCIChartCache(t *testing.T) {
+	reg := newTestRegistry(t)
+	defer reg.server.Close()
+	r, cleanup := reg.newRelease(t)
+	defer cleanup()
+
+	for i := 0; i < 2; i++ {
+		_, err := getOCIChart(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pulls := atomic.LoadInt32(&reg.blobPulls); pulls != 1 {
+		t.Errorf("blob pulled %d times, want 1", pulls)
+	}
+	blob := filepath.Join(r.Client.charts.dir, "sha256", reg.blobDigest.Encoded()+".tgz")
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("chart isn't in the chart cache: %v", err)
+	}
+}
@@ -1,10 +1,15 @@
 package lmhelm
 
 import (
+	"regexp"
+
 	"k8s.io/helm/pkg/helm"
 	rspb "k8s.io/helm/pkg/proto/hapi/release"
 )
 
+// number of releases listed per request
+const listLimit = 256
+
 func installOpts(r *Release, vals []byte) []helm.InstallOption {
 	return []helm.InstallOption{
 		helm.InstallReuseName(true),
@@ -30,17 +35,30 @@ func deleteOpts(r *Release) []helm.DeleteOption {
 	}
 }
 
-func listOpts(r *Release) []helm.ReleaseListOption {
+func rollbackOpts(r *Release, revision int32) []helm.RollbackOption {
+	return []helm.RollbackOption{
+		helm.RollbackVersion(revision),
+		helm.RollbackTimeout(r.Client.Config().ReleaseTimeoutSec),
+		helm.RollbackWait(true),
+	}
+}
+
+func testOpts(r *Release) []helm.ReleaseTestOption {
+	return []helm.ReleaseTestOption{
+		helm.ReleaseTestTimeout(r.Client.Config().ReleaseTimeoutSec),
+		helm.ReleaseTestCleanup(true),
+	}
+}
+
+func listOpts(prefix string, offset string) []helm.ReleaseListOption {
+	statuses := []rspb.Status_Code{}
+	for code := range rspb.Status_Code_name {
+		statuses = append(statuses, rspb.Status_Code(code))
+	}
 	return []helm.ReleaseListOption{
-		helm.ReleaseListFilter(r.Name()),
-		helm.ReleaseListStatuses([]rspb.Status_Code{
-			rspb.Status_DELETING,
-			rspb.Status_DEPLOYED,
-			rspb.Status_FAILED,
-			rspb.Status_PENDING_INSTALL,
-			rspb.Status_PENDING_ROLLBACK,
-			rspb.Status_PENDING_UPGRADE,
-			rspb.Status_UNKNOWN,
-		}),
+		helm.ReleaseListFilter("^" + regexp.QuoteMeta(prefix)),
+		helm.ReleaseListStatuses(statuses),
+		helm.ReleaseListLimit(listLimit),
+		helm.ReleaseListOffset(offset),
 	}
 }
@@ -0,0 +1,71 @@
+package lmhelm
+
+import (
+	"sort"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// AppliedProfiles returns the names of the values profiles merged into the release values
+func (r *Release) AppliedProfiles() []string {
+	return r.appliedProfiles
+}
+
+// matchingProfiles returns the values profiles that apply to the chartmgr in
+// the order they should be applied
+func (r *Release) matchingProfiles() ([]crv1alpha1.ChartValuesProfile, error) {
+	profiles := []crv1alpha1.ChartValuesProfile{}
+	for _, profile := range r.Profiles {
+		match, err := ProfileMatches(profile, r.Chartmgr)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			profiles = append(profiles, profile)
+		}
+	}
+
+	sort.SliceStable(profiles, func(i, j int) bool {
+		if profiles[i].Spec.Priority != profiles[j].Spec.Priority {
+			return profiles[i].Spec.Priority < profiles[j].Spec.Priority
+		}
+		return profiles[i].ObjectMeta.Name < profiles[j].ObjectMeta.Name
+	})
+	return profiles, nil
+}
+
+// ProfileMatches returns true if the values profile applies to the chartmgr
+func ProfileMatches(profile crv1alpha1.ChartValuesProfile, chartmgr *crv1alpha1.ChartManager) (bool, error) {
+	selector := profile.Spec.Selector
+	if selector == nil {
+		return true, nil
+	}
+
+	if len(selector.Charts) > 0 && !containsString(selector.Charts, chartmgr.Spec.Chart.Name) {
+		return false, nil
+	}
+
+	if selector.ChartMgrSelector != nil {
+		s, err := metav1.LabelSelectorAsSelector(selector.ChartMgrSelector)
+		if err != nil {
+			log.Errorf("Invalid chart manager selector in values profile %s: %v", profile.ObjectMeta.Name, err)
+			return false, err
+		}
+		if !s.Matches(labels.Set(chartmgr.ObjectMeta.Labels)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package lmhelm
+
+import (
+	"reflect"
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestProfile(name string, priority int32, selector *crv1alpha1.ChartValuesProfileSelector) crv1alpha1.ChartValuesProfile {
+	return crv1alpha1.ChartValuesProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: crv1alpha1.ChartValuesProfileSpec{
+			Priority: priority,
+			Selector: selector,
+		},
+	}
+}
+
+func newTestProfileChartMgr() *crv1alpha1.ChartManager {
+	return &crv1alpha1.ChartManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "tenant",
+			Labels:    map[string]string{"team": "web", "env": "prod"},
+		},
+		Spec: crv1alpha1.ChartMgrSpec{
+			Chart: &crv1alpha1.ChartMgrChart{Name: "nginx"},
+		},
+	}
+}
+
+func TestProfileMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector *crv1alpha1.ChartValuesProfileSelector
+		want     bool
+		wantErr  bool
+	}{
+		{
+			name: "no selector",
+			want: true,
+		},
+		{
+			name:     "empty selector",
+			selector: &crv1alpha1.ChartValuesProfileSelector{},
+			want:     true,
+		},
+		{
+			name:     "chart",
+			selector: &crv1alpha1.ChartValuesProfileSelector{Charts: []string{"redis", "nginx"}},
+			want:     true,
+		},
+		{
+			name:     "other chart",
+			selector: &crv1alpha1.ChartValuesProfileSelector{Charts: []string{"redis"}},
+			want:     false,
+		},
+		{
+			name: "labels",
+			selector: &crv1alpha1.ChartValuesProfileSelector{
+				ChartMgrSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}},
+			},
+			want: true,
+		},
+		{
+			name: "other labels",
+			selector: &crv1alpha1.ChartValuesProfileSelector{
+				ChartMgrSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "db"}},
+			},
+			want: false,
+		},
+		{
+			name: "label expression",
+			selector: &crv1alpha1.ChartValuesProfileSelector{
+				ChartMgrSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "env", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod", "staging"}},
+				}},
+			},
+			want: true,
+		},
+		{
+			name: "chart and labels",
+			selector: &crv1alpha1.ChartValuesProfileSelector{
+				Charts:           []string{"nginx"},
+				ChartMgrSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}},
+			},
+			want: true,
+		},
+		{
+			name: "chart but not labels",
+			selector: &crv1alpha1.ChartValuesProfileSelector{
+				Charts:           []string{"nginx"},
+				ChartMgrSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "db"}},
+			},
+			want: false,
+		},
+		{
+			name: "invalid selector",
+			selector: &crv1alpha1.ChartValuesProfileSelector{
+				ChartMgrSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "env", Operator: "Near"},
+				}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ProfileMatches(newTestProfile("profile", 0, tt.selector), newTestProfileChartMgr())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ProfileMatches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ProfileMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchingProfiles(t *testing.T) {
+	other := &crv1alpha1.ChartValuesProfileSelector{Charts: []string{"redis"}}
+	invalid := &crv1alpha1.ChartValuesProfileSelector{
+		ChartMgrSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "env", Operator: "Near"},
+		}},
+	}
+	tests := []struct {
+		name     string
+		profiles []crv1alpha1.ChartValuesProfile
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "none",
+			want: []string{},
+		},
+		{
+			name: "by priority",
+			profiles: []crv1alpha1.ChartValuesProfile{
+				newTestProfile("high", 10, nil),
+				newTestProfile("negative", -5, nil),
+				newTestProfile("default", 0, nil),
+			},
+			want: []string{"negative", "default", "high"},
+		},
+		{
+			name: "by name for the same priority",
+			profiles: []crv1alpha1.ChartValuesProfile{
+				newTestProfile("zeta", 1, nil),
+				newTestProfile("alpha", 1, nil),
+				newTestProfile("base", 0, nil),
+				newTestProfile("mid", 1, nil),
+			},
+			want: []string{"base", "alpha", "mid", "zeta"},
+		},
+		{
+			name: "only matching",
+			profiles: []crv1alpha1.ChartValuesProfile{
+				newTestProfile("redis", 0, other),
+				newTestProfile("all", 0, nil),
+			},
+			want: []string{"all"},
+		},
+		{
+			name: "invalid selector",
+			profiles: []crv1alpha1.ChartValuesProfile{
+				newTestProfile("all", 0, nil),
+				newTestProfile("invalid", 0, invalid),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Release{Chartmgr: newTestProfileChartMgr(), Profiles: tt.profiles}
+			profiles, err := r.matchingProfiles()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matchingProfiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := []string{}
+			for _, profile := range profiles {
+				got = append(got, profile.ObjectMeta.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchingProfiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package lmhelm
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/downloader"
+	helm_env "k8s.io/helm/pkg/helm/environment"
+)
+
+// verifyPolicy is the provenance verification policy applying to a chart
+type verifyPolicy struct {
+	mode      crv1alpha1.ChartMgrVerifyMode
+	namespace string
+	secret    string
+	key       string
+}
+
+// verifyModeStrictness orders the verification modes from the least to the
+// most strict
+var verifyModeStrictness = map[crv1alpha1.ChartMgrVerifyMode]int{
+	crv1alpha1.ChartMgrVerifyModeNone:       0,
+	crv1alpha1.ChartMgrVerifyModeIfPossible: 1,
+	crv1alpha1.ChartMgrVerifyModeAlways:     2,
+}
+
+// getVerifyPolicy returns the verification policy of the chart manager
+// combined with the policy of its repository. the chart manager may only make
+// verification stricter than its repository requires, and charts of a
+// repository that always verifies are verified against the repository's
+// keyring. charts are verified if possible by default.
+func getVerifyPolicy(r *Release) *verifyPolicy {
+	p := &verifyPolicy{mode: crv1alpha1.ChartMgrVerifyModeIfPossible}
+	if r.Repository != nil && r.Repository.Spec.Verify != nil {
+		v := r.Repository.Spec.Verify
+		if v.Mode != "" {
+			p.mode = v.Mode
+		}
+		if v.KeyringSecretRef != nil {
+			p.namespace = v.KeyringSecretRef.Namespace
+			p.secret = v.KeyringSecretRef.Name
+			p.key = v.KeyringSecretRef.Key
+		}
+	}
+	repoMode := p.mode
+
+	v := r.Chartmgr.Spec.Chart.Verify
+	if v == nil {
+		return p
+	}
+	if v.Mode != "" && verifyModeStrictness[v.Mode] > verifyModeStrictness[p.mode] {
+		p.mode = v.Mode
+	}
+	if v.KeyringSecretRef != nil && (repoMode != crv1alpha1.ChartMgrVerifyModeAlways || p.secret == "") {
+		p.namespace = r.Chartmgr.ObjectMeta.Namespace
+		p.secret = v.KeyringSecretRef.Name
+		p.key = v.KeyringSecretRef.Key
+	}
+	return p
+}
+
+// checkProvenanceSupported returns an error if the chart manager requires
+// verification of a chart that isn't from a chart repository, since only
+// charts from repositories have provenance files
+func checkProvenanceSupported(r *Release) error {
+	if getVerifyPolicy(r).mode != crv1alpha1.ChartMgrVerifyModeAlways {
+		return nil
+	}
+	r.provenance = &crv1alpha1.ChartMgrProvenance{
+		Reason: "Provenance verification is only supported for charts from chart repositories",
+	}
+	return errors.New(r.provenance.Reason)
+}
+
+// verifyChart verifies the packaged chart against its provenance file and
+// the keyring of the verification policy. the result is recorded in the
+// release, and an error is returned if the chart is refused.
+func verifyChart(r *Release, ref *chartRef, archive []byte, creds *repoCredentials, settings helm_env.EnvSettings) error {
+	r.provenance = nil
+	policy := getVerifyPolicy(r)
+	if policy.mode == crv1alpha1.ChartMgrVerifyModeNone {
+		log.Debugf("Provenance verification of %s disabled", ref)
+		return nil
+	}
+
+	// without a keyring, verifying if possible means not verifying
+	if policy.secret == "" {
+		if policy.mode == crv1alpha1.ChartMgrVerifyModeAlways {
+			return policy.refuse(r, ref, "No keyring configured")
+		}
+		log.Debugf("Provenance verification of %s skipped: no keyring configured", ref)
+		return nil
+	}
+
+	chartURL := ref.url
+	if chartURL == "" {
+		found, err := findChart(r, creds)
+		if err != nil {
+			return err
+		}
+		chartURL = found.url
+	}
+	prov, err := getProvenanceFile(chartURL, creds, settings)
+	if err != nil {
+		return policy.refuse(r, ref, fmt.Sprintf("Failed to get provenance file: %v", err))
+	}
+	keyring, err := r.Client.getKeyring(policy)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "chartmgr-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	// the provenance file lists the chart by the file name of its URL
+	chartPath := filepath.Join(dir, path.Base(chartURL))
+	keyringPath := filepath.Join(dir, "keyring.gpg")
+	files := map[string][]byte{
+		chartPath:           archive,
+		chartPath + ".prov": prov,
+		keyringPath:         keyring,
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(name, data, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	ver, err := downloader.VerifyChart(chartPath, keyringPath)
+	if err != nil {
+		r.provenance = &crv1alpha1.ChartMgrProvenance{Reason: err.Error()}
+		return fmt.Errorf("Refusing chart %s: verification failed: %v", ref, err)
+	}
+
+	r.provenance = &crv1alpha1.ChartMgrProvenance{Verified: true}
+	if ver.SignedBy != nil {
+		names := []string{}
+		for name := range ver.SignedBy.Identities {
+			names = append(names, name)
+		}
+		r.provenance.SignedBy = strings.Join(names, ", ")
+		if ver.SignedBy.PrimaryKey != nil {
+			r.provenance.Fingerprint = strings.ToUpper(hex.EncodeToString(ver.SignedBy.PrimaryKey.Fingerprint[:]))
+		}
+	}
+	log.Infof("Verified chart %s signed by %s", ref, r.provenance.SignedBy)
+	return nil
+}
+
+// refuse records why the chart couldn't be verified, returning an error if
+// the policy requires verification
+func (p *verifyPolicy) refuse(r *Release, ref *chartRef, reason string) error {
+	r.provenance = &crv1alpha1.ChartMgrProvenance{Reason: reason}
+	if p.mode == crv1alpha1.ChartMgrVerifyModeAlways {
+		return fmt.Errorf("Refusing chart %s: %s", ref, reason)
+	}
+	log.Warnf("Chart %s not verified: %s", ref, reason)
+	return nil
+}
+
+// getProvenanceFile downloads the provenance file published next to the chart
+func getProvenanceFile(chartURL string, creds *repoCredentials, settings helm_env.EnvSettings) ([]byte, error) {
+	u, err := url.Parse(chartURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path += ".prov"
+
+	newGetter, err := creds.getters(settings).ByScheme(u.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	g, err := newGetter(u.String(), "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Downloading provenance file %s", u)
+	buf, err := g.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Client) getKeyring(policy *verifyPolicy) ([]byte, error) {
+	log.Debugf("Reading keyring from secret %s/%s key %s", policy.namespace, policy.secret, policy.key)
+	secret, err := c.kubeClient.CoreV1().Secrets(policy.namespace).Get(policy.secret, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	keyring, ok := secret.Data[policy.key]
+	if !ok {
+		return nil, fmt.Errorf("Key %s not found in secret %s/%s", policy.key, policy.namespace, policy.secret)
+	}
+	return keyring, nil
+}
@@ -0,0 +1,130 @@
+package lmhelm
+
+import (
+	"reflect"
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	helm_env "k8s.io/helm/pkg/helm/environment"
+)
+
+func TestGetVerifyPolicy(t *testing.T) {
+	repoKeyring := &crv1alpha1.ChartRepositoryKeyringSecretRef{Name: "repo-keyring", Namespace: "charts", Key: "pubring.gpg"}
+	chartmgrKeyring := &crv1alpha1.ChartMgrSecretKeyRef{Name: "tenant-keyring", Key: "pubring.gpg"}
+	tests := []struct {
+		name       string
+		repoVerify *crv1alpha1.ChartRepositoryVerify
+		verify     *crv1alpha1.ChartMgrChartVerify
+		wantMode   crv1alpha1.ChartMgrVerifyMode
+		wantSecret string
+	}{
+		{
+			name:     "verifies if possible by default",
+			wantMode: crv1alpha1.ChartMgrVerifyModeIfPossible,
+		},
+		{
+			name:       "uses the repository policy",
+			repoVerify: &crv1alpha1.ChartRepositoryVerify{Mode: crv1alpha1.ChartMgrVerifyModeAlways, KeyringSecretRef: repoKeyring},
+			wantMode:   crv1alpha1.ChartMgrVerifyModeAlways,
+			wantSecret: "repo-keyring",
+		},
+		{
+			name:       "refuses a downgrade of always to none",
+			repoVerify: &crv1alpha1.ChartRepositoryVerify{Mode: crv1alpha1.ChartMgrVerifyModeAlways, KeyringSecretRef: repoKeyring},
+			verify:     &crv1alpha1.ChartMgrChartVerify{Mode: crv1alpha1.ChartMgrVerifyModeNone},
+			wantMode:   crv1alpha1.ChartMgrVerifyModeAlways,
+			wantSecret: "repo-keyring",
+		},
+		{
+			name:       "refuses a downgrade of the default to none",
+			repoVerify: &crv1alpha1.ChartRepositoryVerify{KeyringSecretRef: repoKeyring},
+			verify:     &crv1alpha1.ChartMgrChartVerify{Mode: crv1alpha1.ChartMgrVerifyModeNone},
+			wantMode:   crv1alpha1.ChartMgrVerifyModeIfPossible,
+			wantSecret: "repo-keyring",
+		},
+		{
+			name:       "keeps the keyring of a repository that always verifies",
+			repoVerify: &crv1alpha1.ChartRepositoryVerify{Mode: crv1alpha1.ChartMgrVerifyModeAlways, KeyringSecretRef: repoKeyring},
+			verify:     &crv1alpha1.ChartMgrChartVerify{Mode: crv1alpha1.ChartMgrVerifyModeAlways, KeyringSecretRef: chartmgrKeyring},
+			wantMode:   crv1alpha1.ChartMgrVerifyModeAlways,
+			wantSecret: "repo-keyring",
+		},
+		{
+			name:       "makes verification stricter",
+			repoVerify: &crv1alpha1.ChartRepositoryVerify{Mode: crv1alpha1.ChartMgrVerifyModeNone},
+			verify:     &crv1alpha1.ChartMgrChartVerify{Mode: crv1alpha1.ChartMgrVerifyModeAlways, KeyringSecretRef: chartmgrKeyring},
+			wantMode:   crv1alpha1.ChartMgrVerifyModeAlways,
+			wantSecret: "tenant-keyring",
+		},
+		{
+			name:       "uses the chart manager keyring without a repository",
+			verify:     &crv1alpha1.ChartMgrChartVerify{KeyringSecretRef: chartmgrKeyring},
+			wantMode:   crv1alpha1.ChartMgrVerifyModeIfPossible,
+			wantSecret: "tenant-keyring",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Release{Chartmgr: &crv1alpha1.ChartManager{}}
+			r.Chartmgr.ObjectMeta.Namespace = "tenant"
+			r.Chartmgr.Spec.Chart = &crv1alpha1.ChartMgrChart{Verify: tt.verify}
+			if tt.repoVerify != nil {
+				r.Repository = &crv1alpha1.ChartRepository{}
+				r.Repository.Spec.Verify = tt.repoVerify
+			}
+			p := getVerifyPolicy(r)
+			if p.mode != tt.wantMode || p.secret != tt.wantSecret {
+				t.Errorf("getVerifyPolicy() = %s %q, want %s %q", p.mode, p.secret, tt.wantMode, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestVerifyChartWithoutKeyring(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           crv1alpha1.ChartMgrVerifyMode
+		wantErr        string
+		wantProvenance *crv1alpha1.ChartMgrProvenance
+	}{
+		{
+			name: "if possible",
+			mode: crv1alpha1.ChartMgrVerifyModeIfPossible,
+		},
+		{
+			name: "default",
+		},
+		{
+			name: "none",
+			mode: crv1alpha1.ChartMgrVerifyModeNone,
+		},
+		{
+			name:           "always",
+			mode:           crv1alpha1.ChartMgrVerifyModeAlways,
+			wantErr:        "Refusing chart http://charts.example.com app-1.0.0: No keyring configured",
+			wantProvenance: &crv1alpha1.ChartMgrProvenance{Reason: "No keyring configured"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Release{Chartmgr: &crv1alpha1.ChartManager{}}
+			r.Chartmgr.Spec.Chart = &crv1alpha1.ChartMgrChart{Verify: &crv1alpha1.ChartMgrChartVerify{Mode: tt.mode}}
+			// an earlier result is cleared
+			r.provenance = &crv1alpha1.ChartMgrProvenance{Verified: true}
+			ref := &chartRef{repoURL: "http://charts.example.com", name: "app", version: "1.0.0"}
+
+			err := verifyChart(r, ref, nil, nil, helm_env.EnvSettings{})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("verifyChart() error = %v, want %s", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("verifyChart() error = %v", err)
+			}
+			if !reflect.DeepEqual(r.provenance, tt.wantProvenance) {
+				t.Errorf("provenance = %+v, want %+v", r.provenance, tt.wantProvenance)
+			}
+		})
+	}
+}
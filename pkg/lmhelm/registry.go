@@ -0,0 +1,218 @@
+package lmhelm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	helm_env "k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/repo"
+)
+
+// repoRegistry owns the chart repository indexes. repositories are
+// registered by URL and the identity of the credentials their index is
+// downloaded with, so repositories of different sources sharing a name don't
+// replace each other, and an index is only served to the credentials that
+// downloaded it. the indexes are kept in memory so lookups don't read them
+// back from disk.
+type repoRegistry struct {
+	mu       sync.Mutex
+	settings helm_env.EnvSettings
+	repos    map[string]*registeredRepo
+}
+
+// registeredRepo is a repository of the registry. its lock serializes index
+// downloads, so concurrent lookups of a stale index share one download. the
+// entry has no certificate paths, since the files of the credentials are
+// removed after each use; downloads use an entry with the paths of the
+// credentials loaded again from their source.
+type registeredRepo struct {
+	mu      sync.Mutex
+	entry   repo.Entry
+	source  repoSource
+	index   *repo.IndexFile
+	updated time.Time
+	used    time.Time
+}
+
+// repositories unused for this long are removed from the registry, e.g. after
+// their URL or credentials changed
+const repoIdleTimeout = 24 * time.Hour
+
+func newRepoRegistry(settings helm_env.EnvSettings) *repoRegistry {
+	return &repoRegistry{
+		settings: settings,
+		repos:    map[string]*registeredRepo{},
+	}
+}
+
+// index returns the index of the repository, registering it and downloading
+// the index if it is not cached or older than maxAge. a maxAge of 0 accepts
+// any cached index.
+func (r *repoRegistry) index(name string, url string, creds *repoCredentials, maxAge time.Duration) (*repo.IndexFile, error) {
+	rr := r.register(name, url, creds)
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if rr.index != nil && (maxAge <= 0 || time.Since(rr.updated) < maxAge) {
+		log.Debugf("Using cached index of repository %s", name)
+		return rr.index, nil
+	}
+	return r.download(rr, creds)
+}
+
+// lookup returns the name, URL and credential source of the registered
+// repository the requirements.yaml repository of a chart in the namespace
+// refers to by URL, "@name" or "alias:name". inline repositories of other
+// namespaces are never found, and chart repositories are preferred over
+// default repositories and those over inline repositories.
+func (r *repoRegistry) lookup(repository string, namespace string) (string, string, repoSource, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(r.repos))
+	for key := range r.repos {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var found *registeredRepo
+	for _, key := range keys {
+		rr := r.repos[key]
+		if !rr.source.visible(namespace) || !repositoryEqual(repository, rr.entry.Name, rr.entry.URL) {
+			continue
+		}
+		if found == nil || rr.source.rank() < found.source.rank() {
+			found = rr
+		}
+	}
+	if found == nil {
+		return "", "", repoSource{}, false
+	}
+	return found.entry.Name, found.entry.URL, found.source, true
+}
+
+// refresh downloads the index of the repository, registering it if needed
+func (r *repoRegistry) refresh(name string, url string, creds *repoCredentials) (*repo.IndexFile, error) {
+	rr := r.register(name, url, creds)
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return r.download(rr, creds)
+}
+
+// register returns the repository with the URL and credentials, adding it if
+// needed, and removes idle repositories
+func (r *repoRegistry) register(name string, url string, creds *repoCredentials) *registeredRepo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	key := repoKey(url, creds)
+	for k, rr := range r.repos {
+		if k != key && now.Sub(rr.used) > repoIdleTimeout {
+			log.Debugf("Removing idle repository %s %s", rr.entry.Name, rr.entry.URL)
+			os.Remove(rr.entry.Cache) // nolint: errcheck
+			delete(r.repos, k)
+		}
+	}
+
+	rr, ok := r.repos[key]
+	if !ok {
+		rr = &registeredRepo{
+			entry: repo.Entry{
+				Name:  name,
+				Cache: r.settings.Home.CacheIndex(key),
+				URL:   url,
+			},
+			source: creds.source,
+		}
+		r.repos[key] = rr
+	}
+	// repositories of sources sharing the URL and credentials are registered
+	// once, as the source ranking first
+	if creds.source.rank() < rr.source.rank() {
+		rr.entry.Name = name
+		rr.source = creds.source
+	}
+	rr.used = now
+	return rr
+}
+
+// repoKey identifies the repository with the URL and credentials
+func repoKey(url string, creds *repoCredentials) string {
+	sum := sha256.Sum256([]byte(url + "\x00" + creds.identity()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// download downloads the index of the repository. rr.mu must be held.
+func (r *repoRegistry) download(rr *registeredRepo, creds *repoCredentials) (*repo.IndexFile, error) {
+	// register may rename the entry meanwhile
+	r.mu.Lock()
+	entry := rr.entry
+	r.mu.Unlock()
+	name := entry.Name
+	log.Debugf("Downloading index of repository %s from %s", name, entry.URL)
+
+	tmp, err := tempFile(entry.Cache)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp) // nolint: errcheck
+
+	cr, err := repo.NewChartRepository(creds.entry(entry.Name, tmp, entry.URL), creds.getters(r.settings))
+	if err != nil {
+		return nil, err
+	}
+	err = cr.DownloadIndexFile("")
+	if err != nil {
+		return nil, err
+	}
+	index, err := repo.LoadIndexFile(tmp)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(tmp, rr.entry.Cache)
+	if err != nil {
+		return nil, err
+	}
+
+	rr.index = index
+	rr.updated = time.Now()
+	log.Debugf("Downloaded index of repository %s", name)
+	return index, nil
+}
+
+// findChartInIndex looks up the chart version and its URL in the index
+func findChartInIndex(index *repo.IndexFile, repoName string, repoURL string, name string, version string) (*repo.ChartVersion, string, error) {
+	log.Debugf("Looking for chart %s version %s in repository %s", name, version, repoName)
+	cv, err := index.Get(name, version)
+	if err != nil {
+		return nil, "", fmt.Errorf("chart %q version %q not found in repository %s", name, version, repoName)
+	}
+	if len(cv.URLs) == 0 {
+		return nil, "", fmt.Errorf("chart %q version %q has no downloadable URLs", name, version)
+	}
+	chartURL, err := resolveChartURL(repoURL, cv.URLs[0])
+	if err != nil {
+		return nil, "", err
+	}
+	log.Debugf("Chart URL found: %s", chartURL)
+	return cv, chartURL, nil
+}
+
+// tempFile returns the path of a new empty file next to path, so that it can
+// be renamed over path atomically
+func tempFile(path string) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
@@ -0,0 +1,141 @@
+package lmhelm
+
+import (
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	helm_env "k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/helm/helmpath"
+)
+
+func TestRepoRegistryRegister(t *testing.T) {
+	public := &repoCredentials{}
+	tenantA := &repoCredentials{username: "a", secretDigest: "a"}
+	tenantB := &repoCredentials{username: "b", secretDigest: "b"}
+	tests := []struct {
+		name       string
+		first      []string
+		second     []string
+		firstCred  *repoCredentials
+		secondCred *repoCredentials
+		wantSame   bool
+	}{
+		{
+			name:       "shares a repository with the same URL and credentials",
+			first:      []string{"stable", "https://charts.example.com"},
+			second:     []string{"other", "https://charts.example.com"},
+			firstCred:  tenantA,
+			secondCred: tenantA,
+			wantSame:   true,
+		},
+		{
+			name:       "separates repositories sharing a name",
+			first:      []string{"stable", "https://charts.example.com"},
+			second:     []string{"stable", "https://charts.example.org"},
+			firstCred:  public,
+			secondCred: public,
+		},
+		{
+			name:       "separates credentials of the same URL",
+			first:      []string{"private", "https://charts.example.com"},
+			second:     []string{"private", "https://charts.example.com"},
+			firstCred:  tenantA,
+			secondCred: tenantB,
+		},
+		{
+			name:       "separates a repository with credentials from one without",
+			first:      []string{"private", "https://charts.example.com"},
+			second:     []string{"private", "https://charts.example.com"},
+			firstCred:  tenantA,
+			secondCred: public,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRepoRegistry(helm_env.EnvSettings{Home: helmpath.Home("/tmp/chartmgr-test")})
+			first := r.register(tt.first[0], tt.first[1], tt.firstCred)
+			second := r.register(tt.second[0], tt.second[1], tt.secondCred)
+			if (first == second) != tt.wantSame {
+				t.Errorf("same repository = %t, want %t", first == second, tt.wantSame)
+			}
+			if !tt.wantSame && first.entry.Cache == second.entry.Cache {
+				t.Errorf("repositories share the index file %s", first.entry.Cache)
+			}
+		})
+	}
+}
+
+func TestRepoRegistryKeepsCredentialSource(t *testing.T) {
+	r := newRepoRegistry(helm_env.EnvSettings{Home: helmpath.Home("/tmp/chartmgr-test")})
+	creds := &repoCredentials{
+		certFile:     "/tmp/chartmgr-repo-1/tls.crt",
+		keyFile:      "/tmp/chartmgr-repo-1/tls.key",
+		caFile:       "/tmp/chartmgr-repo-1/ca.crt",
+		secretDigest: "a",
+		source:       repoSource{namespace: "tenant", secret: "repo-credentials", url: "https://charts.example.com"},
+	}
+	rr := r.register("private", "https://charts.example.com", creds)
+	if rr.entry.CertFile != "" || rr.entry.KeyFile != "" || rr.entry.CAFile != "" {
+		t.Errorf("entry keeps the credential files %q, %q, %q", rr.entry.CertFile, rr.entry.KeyFile, rr.entry.CAFile)
+	}
+	if rr.source != creds.source {
+		t.Errorf("source = %+v, want %+v", rr.source, creds.source)
+	}
+}
+
+func TestRepoRegistryLookup(t *testing.T) {
+	repository := &crv1alpha1.ChartRepository{}
+	repository.ObjectMeta.Name = "shared"
+	repository.Spec.URL = "https://charts.example.org"
+
+	r := newRepoRegistry(helm_env.EnvSettings{Home: helmpath.Home("/tmp/chartmgr-test")})
+	r.register("private", "https://charts.example.com", &repoCredentials{
+		secretDigest: "a",
+		source:       repoSource{namespace: "tenant-a", secret: "repo-credentials", url: "https://charts.example.com"},
+	})
+	r.register("shared", "https://charts.example.net", &repoCredentials{
+		secretDigest: "b",
+		source:       repoSource{namespace: "tenant-b", secret: "repo-credentials", url: "https://charts.example.net"},
+	})
+	r.register("shared", "https://charts.example.org", &repoCredentials{
+		source: repoSource{repository: repository},
+	})
+
+	tests := []struct {
+		name       string
+		repository string
+		namespace  string
+		wantURL    string
+		wantFound  bool
+	}{
+		{
+			name:       "finds an inline repository of the namespace",
+			repository: "@private",
+			namespace:  "tenant-a",
+			wantURL:    "https://charts.example.com",
+			wantFound:  true,
+		},
+		{
+			name:       "hides an inline repository from other namespaces",
+			repository: "https://charts.example.com",
+			namespace:  "tenant-b",
+		},
+		{
+			name:       "prefers a chart repository over an inline repository",
+			repository: "alias:shared",
+			namespace:  "tenant-b",
+			wantURL:    "https://charts.example.org",
+			wantFound:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, url, _, found := r.lookup(tt.repository, tt.namespace)
+			if found != tt.wantFound || url != tt.wantURL {
+				t.Errorf("lookup = %q, %t, want %q, %t", url, found, tt.wantURL, tt.wantFound)
+			}
+		})
+	}
+}
@@ -2,27 +2,42 @@ package lmhelm
 
 import (
 	"fmt"
+	"strings"
 
 	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
-	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/constants"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/redact"
 	log "github.com/sirupsen/logrus"
+	"k8s.io/helm/pkg/proto/hapi/chart"
 	rspb "k8s.io/helm/pkg/proto/hapi/release"
 )
 
 // Release represents the LM helm release wrapper
 type Release struct {
-	Client   *Client
-	Chartmgr *crv1alpha1.ChartManager
-	rls      *rspb.Release
+	Client          *Client
+	Chartmgr        *crv1alpha1.ChartManager
+	Profiles        []crv1alpha1.ChartValuesProfile
+	Repository      *crv1alpha1.ChartRepository
+	rls             *rspb.Release
+	invalidValues   []string
+	appliedProfiles []string
+	gitCommit       string
+	chartDigest     string
+	provenance      *crv1alpha1.ChartMgrProvenance
+	dependencies    []crv1alpha1.ChartMgrDependency
+	name            string
 }
 
 // Install the release
 func (r *Release) Install() error {
-	chart, err := getChart(r.Chartmgr, r.Client.HelmSettings())
+	chart, err := getChart(r)
 	if err != nil {
 		return err
 	}
-	vals, err := parseValues(r.Chartmgr)
+	vals, err := parseValues(r)
+	if err != nil {
+		return err
+	}
+	err = r.checkValues(chart, vals)
 	if err != nil {
 		return err
 	}
@@ -41,11 +56,15 @@ func (r *Release) Update() error {
 	}
 
 	log.Infof("Updating release %s", r.Name())
-	chart, err := getChart(r.Chartmgr, r.Client.HelmSettings())
+	chart, err := getChart(r)
 	if err != nil {
 		return err
 	}
-	vals, err := parseValues(r.Chartmgr)
+	vals, err := parseValues(r)
+	if err != nil {
+		return err
+	}
+	err = r.checkValues(chart, vals)
 	if err != nil {
 		return err
 	}
@@ -63,7 +82,15 @@ func (r *Release) Delete() error {
 		return nil
 	}
 	// if the release doesn't exist, our job here is done
-	if r.Name() == "" || !r.Exists() {
+	exists := false
+	if r.Name() != "" {
+		var err error
+		exists, err = r.Exists()
+		if err != nil {
+			return err
+		}
+	}
+	if !exists {
 		log.Infof("Can't delete release %s because it doesn't exist", r.Name())
 		return nil
 	}
@@ -74,6 +101,39 @@ func (r *Release) Delete() error {
 	return err
 }
 
+// History returns up to max revisions of the release, newest first
+func (r *Release) History(max int32) ([]*rspb.Release, error) {
+	backend, err := r.backend()
+	if err != nil {
+		return nil, err
+	}
+	return backend.History(r, max)
+}
+
+// Rollback rolls the release back to the revision
+func (r *Release) Rollback(revision int32) error {
+	log.Infof("Rolling back release %s to revision %d", r.Name(), revision)
+	backend, err := r.backend()
+	if err != nil {
+		return err
+	}
+	rls, err := backend.Rollback(r, revision)
+	if rls != nil {
+		r.rls = rls
+	}
+	return err
+}
+
+// Test runs the tests of the release
+func (r *Release) Test() ([]string, error) {
+	log.Infof("Testing release %s", r.Name())
+	backend, err := r.backend()
+	if err != nil {
+		return nil, err
+	}
+	return backend.Test(r)
+}
+
 // Status returns the name of the release status
 func (r *Release) Status() crv1alpha1.ChartMgrState {
 	if r.rls == nil || r.rls.Info == nil || r.rls.Info.Status == nil {
@@ -82,6 +142,32 @@ func (r *Release) Status() crv1alpha1.ChartMgrState {
 	return statusCodeToName(r.rls.Info.Status.Code)
 }
 
+// InvalidValues returns the value overrides that failed validation against the chart
+func (r *Release) InvalidValues() []string {
+	return r.invalidValues
+}
+
+// GitCommit returns the commit the chart was loaded from if it has a git source
+func (r *Release) GitCommit() string {
+	return r.gitCommit
+}
+
+// ChartDigest returns the digest of the chart content if it has a ConfigMap
+// or path source
+func (r *Release) ChartDigest() string {
+	return r.chartDigest
+}
+
+// Provenance returns the result of the provenance verification of the chart
+func (r *Release) Provenance() *crv1alpha1.ChartMgrProvenance {
+	return r.provenance
+}
+
+// Dependencies returns the dependencies of the chart and their versions
+func (r *Release) Dependencies() []crv1alpha1.ChartMgrDependency {
+	return r.dependencies
+}
+
 // CreateOnly returns true of the chart manager CreateOnly option is set
 func CreateOnly(chartmgr *crv1alpha1.ChartManager) bool {
 	if chartmgr.Spec.Options != nil && chartmgr.Spec.Options.CreateOnly {
@@ -90,47 +176,102 @@ func CreateOnly(chartmgr *crv1alpha1.ChartManager) bool {
 	return false
 }
 
-// Deployed indicates whether or not the release is successfully deployed
-func (r *Release) Deployed() bool {
+// StrictValues returns true if the chart manager StrictValues option is set
+func StrictValues(chartmgr *crv1alpha1.ChartManager) bool {
+	if chartmgr.Spec.Options != nil && chartmgr.Spec.Options.StrictValues {
+		return true
+	}
+	return false
+}
+
+// Deployed indicates whether or not the release is successfully deployed. a
+// *ReleaseNotFoundError is returned if the release doesn't exist.
+func (r *Release) Deployed() (bool, error) {
 	rls, err := getInstalledRelease(r)
 	if err != nil {
-		log.Errorf("%v", err)
-		return false
-	}
-	if rls == nil || rls.Info == nil || rls.Info.Status == nil {
-		return false
+		return false, err
 	}
 	r.rls = rls
-	return rls.Info.Status.Code == rspb.Status_DEPLOYED
+	if rls.Info == nil || rls.Info.Status == nil {
+		return false, nil
+	}
+	return rls.Info.Status.Code == rspb.Status_DEPLOYED, nil
+}
+
+// WithName returns the release of the chart manager with the given name
+// instead of the calculated one, e.g. to manage a release the chart manager
+// used before
+func (r *Release) WithName(name string) *Release {
+	return &Release{
+		Client:     r.Client,
+		Chartmgr:   r.Chartmgr,
+		Profiles:   r.Profiles,
+		Repository: r.Repository,
+		name:       name,
+	}
 }
 
 // Name returns the name of this release
 func (r *Release) Name() string {
+	if r.name != "" {
+		return r.name
+	}
+
 	// if the release name is explicitly set, return that
 	if r.Chartmgr.Spec.Release != nil {
 		// log.Debugf("Release name %s specified in resource definition", r.Chartmgr.Spec.Release.Name)
 		return r.Chartmgr.Spec.Release.Name
 	}
 
-	// releases created by the controller are formatted:
-	// chartmgr-rls-[chartmgr uid]
-	uid := r.Chartmgr.ObjectMeta.UID
-
-	return fmt.Sprintf("%s-%s", constants.ReleaseNamePrefix, uid)
+	// otherwise the name is generated with the configured naming
+	return generatedReleaseName(r.Client.chartmgrconfig.ReleaseNaming, r.Chartmgr)
 }
 
-// Exists indicates whether or not the release exists in-cluster
-func (r *Release) Exists() bool {
+// Exists indicates whether or not the release exists in-cluster. an error is
+// returned if that can't be determined.
+func (r *Release) Exists() (bool, error) {
 	rls, err := getInstalledRelease(r)
-	if err != nil {
-		log.Errorf("%v", err)
-		return false
+	if IsReleaseNotFound(err) {
+		return false, nil
 	}
-	if rls == nil {
-		return false
+	if err != nil {
+		return false, err
 	}
 	r.rls = rls
-	return true
+	return true, nil
+}
+
+// backend returns the release backend managing the release
+func (r *Release) backend() (ReleaseBackend, error) {
+	return r.Client.releaseBackend(r.Chartmgr)
+}
+
+// ForgetSecrets stops masking the secret material of the release values
+func (r *Release) ForgetSecrets() {
+	redact.Remove(r.redactionKey())
+}
+
+func (r *Release) redactionKey() string {
+	return fmt.Sprintf("%s/%s", r.Chartmgr.ObjectMeta.Namespace, r.Chartmgr.ObjectMeta.Name)
+}
+
+func (r *Release) checkValues(chart *chart.Chart, vals []byte) error {
+	invalid, err := validateValues(chart, vals)
+	if err != nil {
+		return err
+	}
+	r.invalidValues = invalid
+	if len(invalid) < 1 {
+		return nil
+	}
+
+	for _, v := range invalid {
+		log.Warnf("Invalid value override for release %s: %s", r.Name(), v)
+	}
+	if StrictValues(r.Chartmgr) {
+		return fmt.Errorf("Invalid value overrides: %s", strings.Join(invalid, "; "))
+	}
+	return nil
 }
 
 func statusCodeToName(code rspb.Status_Code) crv1alpha1.ChartMgrState {
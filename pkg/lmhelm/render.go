@@ -0,0 +1,280 @@
+package lmhelm
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/engine"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/version"
+)
+
+// annotations of hook manifests
+const (
+	hookAnnotation             = "helm.sh/hook"
+	hookWeightAnnotation       = "helm.sh/hook-weight"
+	hookDeletePolicyAnnotation = "helm.sh/hook-delete-policy"
+	resourcePolicyAnnotation   = "helm.sh/resource-policy"
+)
+
+const notesFile = "NOTES.txt"
+
+var hookEvents = map[string]rspb.Hook_Event{
+	"pre-install":   rspb.Hook_PRE_INSTALL,
+	"post-install":  rspb.Hook_POST_INSTALL,
+	"pre-delete":    rspb.Hook_PRE_DELETE,
+	"post-delete":   rspb.Hook_POST_DELETE,
+	"pre-upgrade":   rspb.Hook_PRE_UPGRADE,
+	"post-upgrade":  rspb.Hook_POST_UPGRADE,
+	"pre-rollback":  rspb.Hook_PRE_ROLLBACK,
+	"post-rollback": rspb.Hook_POST_ROLLBACK,
+	"test-success":  rspb.Hook_RELEASE_TEST_SUCCESS,
+	"test-failure":  rspb.Hook_RELEASE_TEST_FAILURE,
+}
+
+var hookDeletePolicies = map[string]rspb.Hook_DeletePolicy{
+	"hook-succeeded": rspb.Hook_SUCCEEDED,
+	"hook-failed":    rspb.Hook_FAILED,
+}
+
+// installOrder is the order in which tiller installs resources by kind.
+// resources of other kinds are installed last.
+var installOrder = []string{
+	"Namespace",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"ServiceAccount",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+var manifestSeparator = regexp.MustCompile(`(?:^|\s*\n)---\s*`)
+
+// manifest is a rendered resource that isn't a hook
+type manifest struct {
+	name    string
+	content string
+	kind    string
+	// keep is true if the resource is kept when the release is deleted
+	keep bool
+}
+
+// renderedRelease is the output of rendering a chart for a release
+type renderedRelease struct {
+	// manifest is the resources of the release as a single document
+	manifest  string
+	manifests []manifest
+	hooks     []*rspb.Hook
+	notes     string
+}
+
+// manifestHead is the part of a resource the renderer reads
+type manifestHead struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   *struct {
+		Name        string            `json:"name"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+// capabilities returns the API versions and version of the cluster, as
+// tiller reports them to charts
+func capabilities(client discovery.DiscoveryInterface) (*chartutil.Capabilities, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	versions := chartutil.DefaultVersionSet
+	if groups.Size() > 0 {
+		versions = chartutil.NewVersionSet(metav1.ExtractGroupVersions(groups)...)
+	}
+	kubeVersion, err := client.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	return &chartutil.Capabilities{
+		APIVersions:   versions,
+		KubeVersion:   kubeVersion,
+		TillerVersion: version.GetVersionProto(),
+	}, nil
+}
+
+// renderRelease renders the chart with the values the way tiller does:
+// requirements are applied, the templates are rendered with the values of
+// their chart, and the resources are split from the hooks and sorted in
+// install order
+func renderRelease(ch *chart.Chart, vals []byte, options chartutil.ReleaseOptions, caps *chartutil.Capabilities) (*renderedRelease, error) {
+	config := &chart.Config{Raw: string(vals)}
+	err := chartutil.ProcessRequirementsEnabled(ch, config)
+	if err != nil {
+		return nil, err
+	}
+	err = chartutil.ProcessRequirementsImportValues(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := chartutil.ToRenderValuesCaps(ch, config, options, caps)
+	if err != nil {
+		return nil, err
+	}
+	files, err := renderTemplates(ch, values)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &renderedRelease{}
+	for name, content := range files {
+		if !strings.HasSuffix(name, notesFile) {
+			continue
+		}
+		// only the notes of the top chart are shown
+		if name == path.Join(ch.Metadata.Name, "templates", notesFile) {
+			out.notes = content
+		}
+		delete(files, name)
+	}
+
+	out.hooks, out.manifests, err = sortManifests(files, caps.APIVersions)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, m := range out.manifests {
+		b.WriteString("\n---\n# Source: " + m.name + "\n")
+		b.WriteString(m.content)
+	}
+	out.manifest = b.String()
+	return out, nil
+}
+
+// renderTemplates renders the templates of the chart and its dependencies
+// with the engine of tiller, by path, e.g.
+// parent/charts/child/templates/service.yaml
+func renderTemplates(ch *chart.Chart, values chartutil.Values) (rendered map[string]string, err error) {
+	// text/template panics on some malformed input, e.g. calling a nil
+	// function, which would crash the controller instead of tiller
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Failed to render templates: %v", r)
+		}
+	}()
+	return engine.New().Render(ch, values)
+}
+
+// sortManifests splits the rendered files into resources, sorted in install
+// order, and hooks. the API versions of the resources are checked against
+// the versions unless they are nil.
+func sortManifests(files map[string]string, versions chartutil.VersionSet) ([]*rspb.Hook, []manifest, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hooks := []*rspb.Hook{}
+	manifests := []manifest{}
+	for _, name := range names {
+		for _, content := range manifestSeparator.Split(files[name], -1) {
+			if strings.TrimSpace(content) == "" {
+				continue
+			}
+			var head manifestHead
+			err := yaml.Unmarshal([]byte(content), &head)
+			if err != nil {
+				return nil, nil, fmt.Errorf("YAML parse error on %s: %s", name, err)
+			}
+			if versions != nil && head.APIVersion != "" && !versions.Has(head.APIVersion) {
+				return nil, nil, fmt.Errorf("apiVersion %q in %s is not available", head.APIVersion, name)
+			}
+
+			var annotations map[string]string
+			if head.Metadata != nil {
+				annotations = head.Metadata.Annotations
+			}
+			if _, ok := annotations[hookAnnotation]; !ok {
+				manifests = append(manifests, manifest{
+					name:    name,
+					content: content,
+					kind:    head.Kind,
+					keep:    annotations[resourcePolicyAnnotation] == "keep",
+				})
+				continue
+			}
+
+			hook := newHook(name, content, head)
+			// hooks of unknown events are dropped, like tiller does
+			if len(hook.Events) > 0 {
+				hooks = append(hooks, hook)
+			}
+		}
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return kindOrder(manifests[i].kind) < kindOrder(manifests[j].kind)
+	})
+	return hooks, manifests, nil
+}
+
+func newHook(name string, content string, head manifestHead) *rspb.Hook {
+	annotations := head.Metadata.Annotations
+	hook := &rspb.Hook{
+		Name:     head.Metadata.Name,
+		Kind:     head.Kind,
+		Path:     name,
+		Manifest: content,
+	}
+	for _, event := range strings.Split(annotations[hookAnnotation], ",") {
+		if e, ok := hookEvents[strings.TrimSpace(event)]; ok {
+			hook.Events = append(hook.Events, e)
+		}
+	}
+	weight, _ := strconv.Atoi(strings.TrimSpace(annotations[hookWeightAnnotation]))
+	hook.Weight = int32(weight)
+	for _, policy := range strings.Split(annotations[hookDeletePolicyAnnotation], ",") {
+		if p, ok := hookDeletePolicies[strings.TrimSpace(policy)]; ok {
+			hook.DeletePolicies = append(hook.DeletePolicies, p)
+		}
+	}
+	return hook
+}
+
+func kindOrder(kind string) int {
+	for i, k := range installOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(installOrder)
+}
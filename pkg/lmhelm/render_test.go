@@ -0,0 +1,239 @@
+package lmhelm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// newTestChart returns a chart with a deployment, a service, hooks, notes
+// and a subchart with a config map
+func newTestChart() *chart.Chart {
+	sub := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "sub", Version: "0.1.0"},
+		Templates: []*chart.Template{
+			{Name: "templates/configmap.yaml", Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-sub
+data:
+  color: {{ .Values.color | quote }}
+  global: {{ .Values.global.region | quote }}
+`)},
+		},
+		Values: &chart.Config{Raw: "color: red\n"},
+	}
+	return &chart.Chart{
+		Metadata: &chart.Metadata{Name: "app", Version: "1.0.0"},
+		Templates: []*chart.Template{
+			{Name: "templates/_helpers.tpl", Data: []byte(`{{- define "app.fullname" -}}
+{{ .Release.Name }}-{{ .Chart.Name | trunc 63 }}
+{{- end -}}`)},
+			{Name: "templates/deployment.yaml", Data: []byte(`apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: {{ include "app.fullname" . }}
+spec:
+  replicas: {{ .Values.replicas | default 1 }}
+  template:
+    metadata:
+      annotations:
+        message: {{ tpl .Values.message . | quote }}
+`)},
+			{Name: "templates/service.yaml", Data: []byte(`apiVersion: v1
+kind: Service
+metadata:
+  name: {{ include "app.fullname" . }}
+  annotations:
+    helm.sh/resource-policy: keep
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: {{ include "app.fullname" . }}
+`)},
+			{Name: "templates/hooks.yaml", Data: []byte(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install,pre-upgrade
+    helm.sh/hook-weight: "5"
+    helm.sh/hook-delete-policy: hook-succeeded
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: smoke-test
+  annotations:
+    helm.sh/hook: test-success
+`)},
+			{Name: "templates/NOTES.txt", Data: []byte(`Installed {{ .Release.Name }} in {{ .Release.Namespace }}`)},
+		},
+		Values:       &chart.Config{Raw: "replicas: 2\nmessage: \"revision {{ .Release.Revision }}\"\nglobal:\n  region: us-east-1\n"},
+		Dependencies: []*chart.Chart{sub},
+	}
+}
+
+func TestRenderRelease(t *testing.T) {
+	caps := &chartutil.Capabilities{APIVersions: chartutil.NewVersionSet("v1", "extensions/v1beta1", "batch/v1")}
+	options := chartutil.ReleaseOptions{Name: "rls", Namespace: "tenant", IsInstall: true, Revision: 1}
+	rendered, err := renderRelease(newTestChart(), []byte("sub:\n  color: blue\n"), options, caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := []string{}
+	for _, m := range rendered.manifests {
+		kinds = append(kinds, m.kind)
+	}
+	if got, want := strings.Join(kinds, ","), "ConfigMap,ServiceAccount,Service,Deployment"; got != want {
+		t.Errorf("kinds = %s, want %s", got, want)
+	}
+	for _, m := range rendered.manifests {
+		if m.keep != (m.kind == "Service") {
+			t.Errorf("keep of %s = %t", m.kind, m.keep)
+		}
+	}
+
+	for _, want := range []string{
+		"# Source: app/charts/sub/templates/configmap.yaml",
+		`color: "blue"`,
+		`global: "us-east-1"`,
+		"name: rls-app\nspec:\n  replicas: 2",
+		`message: "revision 1"`,
+	} {
+		if !strings.Contains(rendered.manifest, want) {
+			t.Errorf("manifest doesn't contain %q:\n%s", want, rendered.manifest)
+		}
+	}
+	if strings.Contains(rendered.manifest, "define") || strings.Contains(rendered.manifest, "migrate") {
+		t.Errorf("manifest contains partials or hooks:\n%s", rendered.manifest)
+	}
+
+	if rendered.notes != "Installed rls in tenant" {
+		t.Errorf("notes = %q", rendered.notes)
+	}
+	if len(rendered.hooks) != 2 {
+		t.Fatalf("hooks = %v, want 2", rendered.hooks)
+	}
+	migrate := rendered.hooks[0]
+	if migrate.Name != "migrate" || migrate.Weight != 5 || migrate.Path != "app/templates/hooks.yaml" ||
+		len(migrate.Events) != 2 || migrate.Events[1] != rspb.Hook_PRE_UPGRADE ||
+		len(migrate.DeletePolicies) != 1 || migrate.DeletePolicies[0] != rspb.Hook_SUCCEEDED {
+		t.Errorf("hook = %v", migrate)
+	}
+}
+
+func TestRenderReleaseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  string
+	}{
+		{
+			name:     "parse error",
+			template: "{{ .Values.missing",
+			wantErr:  "parse error",
+		},
+		{
+			name:     "required value",
+			template: `{{ required "name is required" .Values.name }}`,
+			wantErr:  "name is required",
+		},
+		{
+			name:     "unavailable API version",
+			template: "apiVersion: apps/v1\nkind: Deployment\n",
+			wantErr:  `apiVersion "apps/v1" in app/templates/t.yaml is not available`,
+		},
+		{
+			name:     "invalid YAML",
+			template: "kind: [",
+			wantErr:  "YAML parse error",
+		},
+	}
+
+	caps := &chartutil.Capabilities{APIVersions: chartutil.DefaultVersionSet}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &chart.Chart{
+				Metadata:  &chart.Metadata{Name: "app"},
+				Templates: []*chart.Template{{Name: "templates/t.yaml", Data: []byte(tt.template)}},
+			}
+			_, err := renderRelease(ch, nil, chartutil.ReleaseOptions{Name: "rls"}, caps)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("renderRelease() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestTemplateFuncs renders templates the way tiller does, with sprig and
+// the functions and objects of the helm engine
+func TestTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+		wantErr  string
+	}{
+		{template: `{{ "a" | upper | repeat 3 }}`, want: "AAA"},
+		{template: `{{ list 1 2 3 | last }}`, want: "3"},
+		{template: `{{ "a,b" | splitList "," | join "-" }}`, want: "a-b"},
+		{template: `{{ dict "a" 1 | toJson }}`, want: `{"a":1}`},
+		{template: `{{ .missing | default "x" }}`, want: "x"},
+		{template: `{{ coalesce "" 0 "y" }}`, want: "y"},
+		{template: `{{ add 1 2 3 | mul 2 }}`, want: "12"},
+		{template: `{{ "abc" | b64enc | b64dec }}`, want: "abc"},
+		{template: `{{ semverCompare ">=1.8" "1.9.2" }}`, want: "true"},
+		{template: `{{ "hello" | sha256sum | trunc 8 }}`, want: "2cf24dba"},
+		{template: `{{ "line1\nline2" | indent 2 }}`, want: "  line1\n  line2"},
+		{template: `{{ regexReplaceAll "[0-9]+" "a1b22" "#" }}`, want: "a#b#"},
+		{template: `{{ until 3 | toJson }}`, want: "[0,1,2]"},
+		{template: `{{ "hello world" | title | nospace }}`, want: "HelloWorld"},
+		{template: `{{ .Values.ports | toYaml }}`, want: "- 80\n- 443\n"},
+		{template: `{{ include "app.name" . }}`, want: "app-rls"},
+		{template: `{{ tpl "{{ .Release.Name }}-{{ .Values.color }}" . }}`, want: "rls-red"},
+		{template: `{{ required "color is required" .Values.color }}`, want: "red"},
+		{template: `{{ .Capabilities.APIVersions.Has "batch/v1" }}`, want: "true"},
+		{template: `{{ .Files.Get "config/app.conf" }}`, want: "debug = true"},
+		{template: `{{ range $path, $_ := .Files.Glob "config/*" }}{{ $path }}{{ end }}`, want: "config/app.conf"},
+		{template: `{{ (.Files.Glob "config/*").AsConfig | trim }}`, want: "app.conf: debug = true"},
+		{template: `{{ .Template.Name }}`, want: "app/templates/NOTES.txt"},
+		// tiller doesn't give templates access to its environment
+		{template: `{{ env "HOME" }}`, wantErr: `function "env" not defined`},
+		{template: `{{ required "size is required" .Values.size }}`, wantErr: "size is required"},
+		{template: `{{ include "missing" . }}`, wantErr: `no template "missing"`},
+	}
+
+	caps := &chartutil.Capabilities{APIVersions: chartutil.NewVersionSet("v1", "batch/v1")}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			ch := &chart.Chart{
+				Metadata: &chart.Metadata{Name: "app"},
+				Templates: []*chart.Template{
+					{Name: "templates/_helpers.tpl", Data: []byte(`{{ define "app.name" }}{{ .Chart.Name }}-{{ .Release.Name }}{{ end }}`)},
+					{Name: "templates/NOTES.txt", Data: []byte(tt.template)},
+				},
+				Values: &chart.Config{Raw: "color: red\nports: [80, 443]\n"},
+				Files:  []*any.Any{{TypeUrl: "config/app.conf", Value: []byte("debug = true")}},
+			}
+			rendered, err := renderRelease(ch, nil, chartutil.ReleaseOptions{Name: "rls"}, caps)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("renderRelease() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered.notes != tt.want {
+				t.Errorf("rendered %q, want %q", rendered.notes, tt.want)
+			}
+		})
+	}
+}
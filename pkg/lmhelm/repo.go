@@ -1,97 +1,67 @@
 package lmhelm
 
 import (
-	"os"
+	"fmt"
+	"strings"
 
 	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
-	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/constants"
 	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/utilities"
 	log "github.com/sirupsen/logrus"
-	"k8s.io/helm/pkg/getter"
-	helm_env "k8s.io/helm/pkg/helm/environment"
 	"k8s.io/helm/pkg/helm/helmpath"
-	"k8s.io/helm/pkg/repo"
 )
 
-func ensureDefaultRepos(settings helm_env.EnvSettings) error {
-	log.Debugf("Initializing stable repo %s", constants.HelmStableRepo)
-	err := initStableRepo(settings)
-	if err != nil {
-		return err
-	}
-	log.Debugf("Initialized %s repo", constants.HelmStableRepo)
-	return nil
+// defaultRepo is a repository charts without a repository are looked up in
+type defaultRepo struct {
+	name string
+	url  string
 }
 
-func initStableRepo(settings helm_env.EnvSettings) error {
-	return addRepo(constants.HelmStableRepo, constants.HelmStableRepoURL, settings)
-}
-
-func addRepo(name string, url string, settings helm_env.EnvSettings) error {
-	if url == "" {
-		return nil
-	}
-
-	c := repoEntry(name, settings.Home.CacheIndex(name), url)
-	r, err := createRepo(c, url, settings)
+// ensureDefaultRepos registers the default repositories and downloads their
+// indexes. an unreachable repository is not fatal, since its index is
+// downloaded again when a chart is looked up in it.
+func (c *Client) ensureDefaultRepos() error {
+	repos, err := parseDefaultRepos(c.chartmgrconfig.DefaultRepositories)
 	if err != nil {
 		return err
 	}
-	return initRepo(r, c, settings)
-}
-
-func repoEntry(name string, cache string, url string) repo.Entry {
-	return repo.Entry{
-		Name:  name,
-		Cache: cache,
-		URL:   url,
-	}
-}
-
-func createRepo(c repo.Entry, url string, settings helm_env.EnvSettings) (*repo.ChartRepository, error) {
-	log.Debugf("Creating chart repository from %s", url)
-	return repo.NewChartRepository(&c, getter.All(settings))
-}
-
-func initRepo(r *repo.ChartRepository, c repo.Entry, settings helm_env.EnvSettings) error {
-	log.Debugf("Downloading index file to %s", settings.Home.Cache())
-	err := r.DownloadIndexFile("")
-	if err != nil {
-		return err
+	c.defaultRepos = repos
+	if len(repos) < 1 {
+		log.Infof("No default repositories configured")
 	}
-	return initRepoFile(c, settings.Home.RepositoryFile())
-}
 
-func initRepoFile(c repo.Entry, repoFile string) error {
-	// check if repo files have already been created
-	_, err := os.Stat(repoFile)
-	if err != nil {
-		return addRepoFile(c, repoFile)
+	for _, dr := range repos {
+		log.Debugf("Initializing default repo %s", dr.name)
+		_, err = c.repos.refresh(dr.name, dr.url, c.defaultCredentials())
+		if err != nil {
+			log.Warnf("Failed to initialize default repo %s %s: %v", dr.name, dr.url, err)
+			continue
+		}
+		log.Debugf("Initialized %s repo", dr.name)
 	}
-	return updateRepoFile(c, repoFile)
-}
-
-func addRepoFile(c repo.Entry, repoFile string) error {
-	log.Debugf("Adding repository %s", repoFile)
-	f := repo.NewRepoFile()
-	f.Add(&c)
-
-	log.Debugf("Writing repository file %s", repoFile)
-	return f.WriteFile(repoFile, 0644)
+	return nil
 }
 
-func updateRepoFile(c repo.Entry, repoFile string) error {
-	log.Debugf("Loading repositories from %s", repoFile)
-	f, err := repo.LoadRepositoriesFile(repoFile)
-	if err != nil {
-		return err
+// parseDefaultRepos parses the name=url entries of the default repositories
+// setting. empty entries are ignored so the list can be set empty.
+func parseDefaultRepos(entries []string) ([]defaultRepo, error) {
+	repos := []defaultRepo{}
+	names := map[string]bool{}
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid default repository %q: expected name=url", e)
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("Duplicate default repository %q", parts[0])
+		}
+		names[parts[0]] = true
+		repos = append(repos, defaultRepo{name: parts[0], url: parts[1]})
 	}
-
-	log.Debugf("Updating repository %s", repoFile)
-	f.Update(&c)
-
-	log.Debugf("Writing repository file %s", repoFile)
-	return f.WriteFile(repoFile, 0644)
+	return repos, nil
 }
 
 func ensureDirectories(home helmpath.Home) error {
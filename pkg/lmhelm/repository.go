@@ -0,0 +1,50 @@
+package lmhelm
+
+import (
+	"net/url"
+	"strings"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/helm/pkg/repo"
+)
+
+// RefreshRepository downloads the index of the chart repository and returns
+// the number of charts in the index
+func (c *Client) RefreshRepository(repository *crv1alpha1.ChartRepository) (int, error) {
+	creds, err := c.loadRepositoryCredentials(repository)
+	if err != nil {
+		return 0, err
+	}
+	defer creds.cleanup()
+
+	log.Debugf("Refreshing index of chart repository %s", repository.ObjectMeta.Name)
+	index, err := c.repos.refresh(repository.ObjectMeta.Name, repository.Spec.URL, creds)
+	if err != nil {
+		return 0, err
+	}
+	return len(index.Entries), nil
+}
+
+// findChartInRepository looks up the chart version and its URL in the index
+// of the chart repository. the index is kept fresh by the repository's
+// refresher, so it is only downloaded here if it has not been yet.
+func (c *Client) findChartInRepository(repository *crv1alpha1.ChartRepository, creds *repoCredentials, name string, version string) (*repo.ChartVersion, string, error) {
+	index, err := c.repos.index(repository.ObjectMeta.Name, repository.Spec.URL, creds, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	return findChartInIndex(index, repository.ObjectMeta.Name, repository.Spec.URL, name, version)
+}
+
+func resolveChartURL(repoURL string, chartURL string) (string, error) {
+	base, err := url.Parse(strings.TrimSuffix(repoURL, "/") + "/")
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(chartURL)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,542 @@
+package lmhelm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	yaml "github.com/ghodss/yaml"
+	"github.com/golang/protobuf/ptypes"
+	google_protobuf "github.com/golang/protobuf/ptypes/any"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// the Secrets storage driver of helm 3 stores each revision of a release in
+// a Secret of this type in the release namespace, named
+// sh.helm.release.v1.[name].v[revision]
+const (
+	releaseSecretType   = "helm.sh/release.v1"
+	releaseSecretPrefix = "sh.helm.release.v1."
+	releaseSecretKey    = "release"
+	releaseSecretOwner  = "helm"
+	// releaseSecretManagedLabel marks the Secrets stored by the controller,
+	// so that listing releases skips those of other helm clients
+	releaseSecretManagedLabel = "chartmgr.logicmonitor.com/managed"
+)
+
+var releaseStatuses = map[rspb.Status_Code]string{
+	rspb.Status_UNKNOWN:          "unknown",
+	rspb.Status_DEPLOYED:         "deployed",
+	rspb.Status_DELETED:          "uninstalled",
+	rspb.Status_SUPERSEDED:       "superseded",
+	rspb.Status_FAILED:           "failed",
+	rspb.Status_DELETING:         "uninstalling",
+	rspb.Status_PENDING_INSTALL:  "pending-install",
+	rspb.Status_PENDING_UPGRADE:  "pending-upgrade",
+	rspb.Status_PENDING_ROLLBACK: "pending-rollback",
+}
+
+// storedRelease is a release in the JSON format of helm 3
+type storedRelease struct {
+	Name      string        `json:"name,omitempty"`
+	Info      *storedInfo   `json:"info,omitempty"`
+	Chart     *storedChart  `json:"chart,omitempty"`
+	Config    chartValues   `json:"config,omitempty"`
+	Manifest  string        `json:"manifest,omitempty"`
+	Hooks     []*storedHook `json:"hooks,omitempty"`
+	Version   int32         `json:"version,omitempty"`
+	Namespace string        `json:"namespace,omitempty"`
+}
+
+type storedInfo struct {
+	FirstDeployed time.Time `json:"first_deployed,omitempty"`
+	LastDeployed  time.Time `json:"last_deployed,omitempty"`
+	Deleted       time.Time `json:"deleted"`
+	Description   string    `json:"description,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	Notes         string    `json:"notes,omitempty"`
+}
+
+type storedChart struct {
+	Metadata  *storedMetadata `json:"metadata"`
+	Templates []*storedFile   `json:"templates"`
+	Values    chartValues     `json:"values"`
+	Files     []*storedFile   `json:"files"`
+}
+
+type storedMetadata struct {
+	Name        string              `json:"name,omitempty"`
+	Home        string              `json:"home,omitempty"`
+	Sources     []string            `json:"sources,omitempty"`
+	Version     string              `json:"version,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Keywords    []string            `json:"keywords,omitempty"`
+	Maintainers []*chart.Maintainer `json:"maintainers,omitempty"`
+	Icon        string              `json:"icon,omitempty"`
+	APIVersion  string              `json:"apiVersion,omitempty"`
+	Condition   string              `json:"condition,omitempty"`
+	Tags        string              `json:"tags,omitempty"`
+	AppVersion  string              `json:"appVersion,omitempty"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+	Annotations map[string]string   `json:"annotations,omitempty"`
+	Engine      string              `json:"engine,omitempty"`
+}
+
+type storedFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+type storedHook struct {
+	Name           string              `json:"name,omitempty"`
+	Kind           string              `json:"kind,omitempty"`
+	Path           string              `json:"path,omitempty"`
+	Manifest       string              `json:"manifest,omitempty"`
+	Events         []string            `json:"events,omitempty"`
+	LastRun        storedHookExecution `json:"last_run,omitempty"`
+	Weight         int32               `json:"weight,omitempty"`
+	DeletePolicies []string            `json:"delete_policies,omitempty"`
+}
+
+type storedHookExecution struct {
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+	Phase       string    `json:"phase"`
+}
+
+// chartValues are values stored as a JSON object, which are YAML in hapi
+// releases
+type chartValues map[string]interface{}
+
+// releaseStore stores releases in Secrets the way the Secrets storage driver
+// of helm 3 does, so that the helm 3 client can read them
+type releaseStore struct {
+	client kubernetes.Interface
+}
+
+// create stores a new revision of a release
+func (s *releaseStore) create(rls *rspb.Release) error {
+	secret, err := newReleaseSecret(rls)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.CoreV1().Secrets(rls.Namespace).Create(secret)
+	if apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("Revision %d of release %s already exists", rls.Version, rls.Name)
+	}
+	return err
+}
+
+// update stores the revision of a release again, e.g. after its status
+// changed
+func (s *releaseStore) update(rls *rspb.Release) error {
+	secret, err := newReleaseSecret(rls)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.CoreV1().Secrets(rls.Namespace).Update(secret)
+	return err
+}
+
+// history returns the revisions of the release in the namespace, newest
+// first
+func (s *releaseStore) history(namespace string, name string) ([]*rspb.Release, error) {
+	return s.list(namespace, map[string]string{"name": name})
+}
+
+// list returns the revisions of all releases in the namespace, or in all
+// namespaces if it is empty, with the labels, newest first
+func (s *releaseStore) list(namespace string, selector map[string]string) ([]*rspb.Release, error) {
+	set := labels.Set{"owner": releaseSecretOwner}
+	for k, v := range selector {
+		set[k] = v
+	}
+	secrets, err := s.client.CoreV1().Secrets(namespace).List(metav1.ListOptions{
+		LabelSelector: set.AsSelector().String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []*rspb.Release{}
+	for _, secret := range secrets.Items {
+		if secret.Type != releaseSecretType {
+			continue
+		}
+		rls, err := decodeRelease(secret.Data[releaseSecretKey])
+		if err != nil {
+			// one unreadable revision mustn't hide the other releases
+			log.Warnf("Skipping release secret %s/%s: %v", secret.ObjectMeta.Namespace, secret.ObjectMeta.Name, err)
+			continue
+		}
+		releases = append(releases, rls)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Version > releases[j].Version
+	})
+	return releases, nil
+}
+
+// purge deletes the revisions of the release in the namespace
+func (s *releaseStore) purge(namespace string, name string) error {
+	revisions, err := s.history(namespace, name)
+	if err != nil {
+		return err
+	}
+	for _, rls := range revisions {
+		err = s.client.CoreV1().Secrets(namespace).Delete(releaseSecretName(rls), &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func releaseSecretName(rls *rspb.Release) string {
+	return fmt.Sprintf("%s%s.v%d", releaseSecretPrefix, rls.Name, rls.Version)
+}
+
+func newReleaseSecret(rls *rspb.Release) (*v1.Secret, error) {
+	data, err := encodeRelease(rls)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      releaseSecretName(rls),
+			Namespace: rls.Namespace,
+			Labels: map[string]string{
+				"name":    rls.Name,
+				"owner":   releaseSecretOwner,
+				"status":  releaseStatuses[statusCode(rls)],
+				"version": strconv.Itoa(int(rls.Version)),
+
+				releaseSecretManagedLabel: "true",
+			},
+		},
+		Type: releaseSecretType,
+		Data: map[string][]byte{releaseSecretKey: data},
+	}, nil
+}
+
+// encodeRelease encodes the release like helm 3: gzipped JSON, base64
+// encoded once more since the Secret data is base64 encoded too
+func encodeRelease(rls *rspb.Release) ([]byte, error) {
+	stored, err := toStoredRelease(rls)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func decodeRelease(data []byte) (*rspb.Release, error) {
+	b, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	// helm 3 reads uncompressed releases too
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b, 0x08}) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close() // nolint: errcheck
+		b, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var stored storedRelease
+	err = json.Unmarshal(b, &stored)
+	if err != nil {
+		return nil, err
+	}
+	return fromStoredRelease(&stored)
+}
+
+func toStoredRelease(rls *rspb.Release) (*storedRelease, error) {
+	stored := &storedRelease{
+		Name:      rls.Name,
+		Manifest:  rls.Manifest,
+		Version:   rls.Version,
+		Namespace: rls.Namespace,
+		Info:      &storedInfo{Status: releaseStatuses[statusCode(rls)]},
+	}
+	if info := rls.Info; info != nil {
+		stored.Info.FirstDeployed = protoTime(info.FirstDeployed)
+		stored.Info.LastDeployed = protoTime(info.LastDeployed)
+		stored.Info.Deleted = protoTime(info.Deleted)
+		stored.Info.Description = info.Description
+		if info.Status != nil {
+			stored.Info.Notes = info.Status.Notes
+		}
+	}
+
+	var err error
+	if rls.Config != nil {
+		stored.Config, err = toChartValues(rls.Config.Raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rls.Chart != nil {
+		stored.Chart, err = toStoredChart(rls.Chart)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, hook := range rls.Hooks {
+		stored.Hooks = append(stored.Hooks, toStoredHook(hook))
+	}
+	return stored, nil
+}
+
+func fromStoredRelease(stored *storedRelease) (*rspb.Release, error) {
+	rls := &rspb.Release{
+		Name:      stored.Name,
+		Manifest:  stored.Manifest,
+		Version:   stored.Version,
+		Namespace: stored.Namespace,
+		Info:      &rspb.Info{Status: &rspb.Status{}},
+	}
+	if info := stored.Info; info != nil {
+		rls.Info.FirstDeployed = timeProto(info.FirstDeployed)
+		rls.Info.LastDeployed = timeProto(info.LastDeployed)
+		rls.Info.Deleted = timeProto(info.Deleted)
+		rls.Info.Description = info.Description
+		rls.Info.Status.Code = statusFromString(info.Status)
+		rls.Info.Status.Notes = info.Notes
+	}
+
+	raw, err := fromChartValues(stored.Config)
+	if err != nil {
+		return nil, err
+	}
+	rls.Config = &chart.Config{Raw: raw}
+	if stored.Chart != nil {
+		rls.Chart, err = fromStoredChart(stored.Chart)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, hook := range stored.Hooks {
+		rls.Hooks = append(rls.Hooks, fromStoredHook(hook))
+	}
+	return rls, nil
+}
+
+// toStoredChart converts the chart without its dependencies, which helm 3
+// doesn't store either
+func toStoredChart(ch *chart.Chart) (*storedChart, error) {
+	stored := &storedChart{
+		Metadata:  &storedMetadata{},
+		Templates: []*storedFile{},
+		Files:     []*storedFile{},
+	}
+	if m := ch.Metadata; m != nil {
+		stored.Metadata = &storedMetadata{
+			Name:        m.Name,
+			Home:        m.Home,
+			Sources:     m.Sources,
+			Version:     m.Version,
+			Description: m.Description,
+			Keywords:    m.Keywords,
+			Maintainers: m.Maintainers,
+			Icon:        m.Icon,
+			APIVersion:  m.ApiVersion,
+			Condition:   m.Condition,
+			Tags:        m.Tags,
+			AppVersion:  m.AppVersion,
+			Deprecated:  m.Deprecated,
+			Annotations: m.Annotations,
+			Engine:      m.Engine,
+		}
+	}
+	// helm 3 requires the chart API version
+	if stored.Metadata.APIVersion == "" {
+		stored.Metadata.APIVersion = "v1"
+	}
+	for _, t := range ch.Templates {
+		stored.Templates = append(stored.Templates, &storedFile{Name: t.Name, Data: t.Data})
+	}
+	for _, f := range ch.Files {
+		stored.Files = append(stored.Files, &storedFile{Name: f.TypeUrl, Data: f.Value})
+	}
+
+	var err error
+	if ch.Values != nil {
+		stored.Values, err = toChartValues(ch.Values.Raw)
+	}
+	return stored, err
+}
+
+func fromStoredChart(stored *storedChart) (*chart.Chart, error) {
+	ch := &chart.Chart{Metadata: &chart.Metadata{}}
+	if m := stored.Metadata; m != nil {
+		ch.Metadata = &chart.Metadata{
+			Name:        m.Name,
+			Home:        m.Home,
+			Sources:     m.Sources,
+			Version:     m.Version,
+			Description: m.Description,
+			Keywords:    m.Keywords,
+			Maintainers: m.Maintainers,
+			Icon:        m.Icon,
+			ApiVersion:  m.APIVersion,
+			Condition:   m.Condition,
+			Tags:        m.Tags,
+			AppVersion:  m.AppVersion,
+			Deprecated:  m.Deprecated,
+			Annotations: m.Annotations,
+			Engine:      m.Engine,
+		}
+	}
+	for _, t := range stored.Templates {
+		ch.Templates = append(ch.Templates, &chart.Template{Name: t.Name, Data: t.Data})
+	}
+	for _, f := range stored.Files {
+		ch.Files = append(ch.Files, &google_protobuf.Any{TypeUrl: f.Name, Value: f.Data})
+	}
+	raw, err := fromChartValues(stored.Values)
+	if err != nil {
+		return nil, err
+	}
+	ch.Values = &chart.Config{Raw: raw}
+	return ch, nil
+}
+
+func toStoredHook(hook *rspb.Hook) *storedHook {
+	stored := &storedHook{
+		Name:     hook.Name,
+		Kind:     hook.Kind,
+		Path:     hook.Path,
+		Manifest: hook.Manifest,
+		Weight:   hook.Weight,
+		LastRun:  storedHookExecution{StartedAt: protoTime(hook.LastRun), Phase: "Unknown"},
+	}
+	for _, event := range hook.Events {
+		stored.Events = append(stored.Events, hookEventName(event))
+	}
+	for _, policy := range hook.DeletePolicies {
+		for name, p := range hookDeletePolicies {
+			if p == policy {
+				stored.DeletePolicies = append(stored.DeletePolicies, name)
+			}
+		}
+	}
+	return stored
+}
+
+func fromStoredHook(stored *storedHook) *rspb.Hook {
+	hook := &rspb.Hook{
+		Name:     stored.Name,
+		Kind:     stored.Kind,
+		Path:     stored.Path,
+		Manifest: stored.Manifest,
+		Weight:   stored.Weight,
+		LastRun:  timeProto(stored.LastRun.StartedAt),
+	}
+	for _, event := range stored.Events {
+		// helm 3 names the test-success event test
+		if event == "test" {
+			event = "test-success"
+		}
+		if e, ok := hookEvents[event]; ok {
+			hook.Events = append(hook.Events, e)
+		}
+	}
+	for _, policy := range stored.DeletePolicies {
+		if p, ok := hookDeletePolicies[policy]; ok {
+			hook.DeletePolicies = append(hook.DeletePolicies, p)
+		}
+	}
+	return hook
+}
+
+func hookEventName(event rspb.Hook_Event) string {
+	if event == rspb.Hook_RELEASE_TEST_SUCCESS {
+		return "test"
+	}
+	for name, e := range hookEvents {
+		if e == event {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+func statusFromString(status string) rspb.Status_Code {
+	for code, s := range releaseStatuses {
+		if s == status {
+			return code
+		}
+	}
+	return rspb.Status_UNKNOWN
+}
+
+func toChartValues(raw string) (chartValues, error) {
+	values := chartValues{}
+	err := yaml.Unmarshal([]byte(raw), &values)
+	return values, err
+}
+
+func fromChartValues(values chartValues) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	b, err := yaml.Marshal(values)
+	return string(b), err
+}
+
+func protoTime(ts *timestamp.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	t, err := ptypes.Timestamp(ts)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func timeProto(t time.Time) *timestamp.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	ts, err := ptypes.TimestampProto(t)
+	if err != nil {
+		return nil
+	}
+	return ts
+}
@@ -0,0 +1,530 @@
+package lmhelm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/kube"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// resourceClient creates, updates and deletes the resources of releases. it
+// is implemented by the Kubernetes client of helm, which tiller uses too.
+type resourceClient interface {
+	Create(namespace string, reader io.Reader, timeout int64, shouldWait bool) error
+	Update(namespace string, originalReader, targetReader io.Reader, force bool, recreate bool, timeout int64, shouldWait bool) error
+	Delete(namespace string, reader io.Reader) error
+	WatchUntilReady(namespace string, reader io.Reader, timeout int64, shouldWait bool) error
+	WaitAndGetCompletedPodPhase(namespace string, reader io.Reader, timeout time.Duration) (api.PodPhase, error)
+}
+
+// storageBackend manages releases without tiller: charts are rendered and
+// their resources applied by the controller, and releases are stored in
+// Secrets in the format of helm 3, in the namespace of the release. the
+// install, upgrade, rollback, delete and test semantics, including hooks,
+// are tiller's.
+type storageBackend struct {
+	store     *releaseStore
+	resources resourceClient
+	discovery discovery.DiscoveryInterface
+}
+
+// newStorageBackend returns the backend storing releases in Secrets
+func (c *Client) newStorageBackend() (*storageBackend, error) {
+	return &storageBackend{
+		store:     &releaseStore{client: c.kubeClient},
+		resources: kube.New(&restClientConfig{config: c.restConfig}),
+		discovery: c.kubeClient.Discovery(),
+	}, nil
+}
+
+// restClientConfig is the client config of the helm Kubernetes client, which
+// is loaded from a kubeconfig file, with the rest config of the controller
+type restClientConfig struct {
+	config *rest.Config
+}
+
+func (c *restClientConfig) RawConfig() (clientcmdapi.Config, error) {
+	return clientcmdapi.Config{}, nil
+}
+
+func (c *restClientConfig) ClientConfig() (*rest.Config, error) {
+	config := *c.config
+	return &config, nil
+}
+
+func (c *restClientConfig) Namespace() (string, bool, error) {
+	return "default", false, nil
+}
+
+func (c *restClientConfig) ConfigAccess() clientcmd.ConfigAccess {
+	return clientcmd.NewDefaultClientConfigLoadingRules()
+}
+
+func (b *storageBackend) Install(r *Release, ch *chart.Chart, vals []byte) (*rspb.Release, error) {
+	name, namespace := r.Name(), r.Chartmgr.ObjectMeta.Namespace
+	history, err := b.store.history(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	// like tiller, the name of a deleted or failed release is reused
+	if len(history) > 0 {
+		switch statusCode(history[0]) {
+		case rspb.Status_DELETED, rspb.Status_FAILED:
+			log.Infof("Reusing name of release %s", name)
+			err = b.store.purge(namespace, name)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("A release named %s already exists", name)
+		}
+	}
+
+	now := ptypes.TimestampNow()
+	rendered, err := b.render(ch, vals, chartutil.ReleaseOptions{
+		Name:      name,
+		Time:      now,
+		Namespace: namespace,
+		IsInstall: true,
+		Revision:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rls := newStoredRelease(name, namespace, 1, ch, vals, rendered)
+	rls.Info.FirstDeployed = now
+	rls.Info.LastDeployed = now
+	rls.Info.Status.Code = rspb.Status_PENDING_INSTALL
+	rls.Info.Description = "Initial install underway"
+	err = b.store.create(rls)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := r.Client.Config().ReleaseTimeoutSec
+	err = b.execHooks(rls, rspb.Hook_PRE_INSTALL, timeout)
+	if err == nil {
+		err = b.resources.Create(namespace, strings.NewReader(rls.Manifest), timeout, true)
+	}
+	if err == nil {
+		err = b.execHooks(rls, rspb.Hook_POST_INSTALL, timeout)
+	}
+	if err != nil {
+		return b.failed(rls, err)
+	}
+	return b.deployed(rls, "Install complete")
+}
+
+func (b *storageBackend) Upgrade(r *Release, ch *chart.Chart, vals []byte) (*rspb.Release, error) {
+	name, namespace := r.Name(), r.Chartmgr.ObjectMeta.Namespace
+	current, err := b.last(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || statusCode(current) == rspb.Status_DELETED {
+		return nil, fmt.Errorf("%q has no deployed releases", name)
+	}
+
+	now := ptypes.TimestampNow()
+	revision := current.Version + 1
+	rendered, err := b.render(ch, vals, chartutil.ReleaseOptions{
+		Name:      name,
+		Time:      now,
+		Namespace: namespace,
+		IsUpgrade: true,
+		Revision:  int(revision),
+	})
+	if err != nil {
+		return nil, err
+	}
+	rls := newStoredRelease(name, namespace, revision, ch, vals, rendered)
+	rls.Info.FirstDeployed = current.Info.FirstDeployed
+	rls.Info.LastDeployed = now
+	rls.Info.Status.Code = rspb.Status_PENDING_UPGRADE
+	rls.Info.Description = "Preparing upgrade"
+	err = b.store.create(rls)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.replace(current, rls, rspb.Hook_PRE_UPGRADE, rspb.Hook_POST_UPGRADE, r.Client.Config().ReleaseTimeoutSec, "Upgrade complete")
+}
+
+func (b *storageBackend) Rollback(r *Release, revision int32) (*rspb.Release, error) {
+	name, namespace := r.Name(), r.Chartmgr.ObjectMeta.Namespace
+	history, err := b.store.history(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) < 1 || statusCode(history[0]) == rspb.Status_DELETED {
+		return nil, &ReleaseNotFoundError{Name: name}
+	}
+	current := history[0]
+	if revision <= 0 {
+		revision = current.Version - 1
+	}
+	var target *rspb.Release
+	for _, rls := range history {
+		if rls.Version == revision {
+			target = rls
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("Release %s has no revision %d", name, revision)
+	}
+
+	rls := &rspb.Release{
+		Name:      name,
+		Namespace: namespace,
+		Chart:     target.Chart,
+		Config:    target.Config,
+		Manifest:  target.Manifest,
+		Hooks:     target.Hooks,
+		Version:   current.Version + 1,
+		Info: &rspb.Info{
+			FirstDeployed: current.Info.FirstDeployed,
+			LastDeployed:  ptypes.TimestampNow(),
+			Status: &rspb.Status{
+				Code:  rspb.Status_PENDING_ROLLBACK,
+				Notes: target.Info.Status.Notes,
+			},
+			Description: fmt.Sprintf("Rollback to %d", revision),
+		},
+	}
+	err = b.store.create(rls)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.replace(current, rls, rspb.Hook_PRE_ROLLBACK, rspb.Hook_POST_ROLLBACK, r.Client.Config().ReleaseTimeoutSec, rls.Info.Description)
+}
+
+func (b *storageBackend) Delete(r *Release) (*rspb.Release, error) {
+	name, namespace := r.Name(), r.Chartmgr.ObjectMeta.Namespace
+	rls, err := b.last(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if rls == nil {
+		return nil, &ReleaseNotFoundError{Name: name}
+	}
+
+	timeout := r.Client.Config().ReleaseTimeoutSec
+	if statusCode(rls) != rspb.Status_DELETED {
+		rls.Info.Status.Code = rspb.Status_DELETING
+		rls.Info.Deleted = ptypes.TimestampNow()
+		rls.Info.Description = "Deletion in progress"
+		err = b.store.update(rls)
+		if err != nil {
+			return nil, err
+		}
+
+		err = b.execHooks(rls, rspb.Hook_PRE_DELETE, timeout)
+		if err != nil {
+			return rls, err
+		}
+		// resources are deleted in the reverse of the install order, and
+		// resources with the keep policy are left behind
+		_, manifests, err := sortManifests(map[string]string{name: rls.Manifest}, nil)
+		if err != nil {
+			return rls, err
+		}
+		failed := []string{}
+		for i := len(manifests) - 1; i >= 0; i-- {
+			if manifests[i].keep {
+				log.Infof("Keeping %s of release %s", manifests[i].kind, name)
+				continue
+			}
+			err = b.resources.Delete(namespace, strings.NewReader(manifests[i].content))
+			if err != nil {
+				failed = append(failed, err.Error())
+			}
+		}
+		if len(failed) > 0 {
+			return rls, fmt.Errorf("Failed to delete resources of release %s: %s", name, strings.Join(failed, "; "))
+		}
+		err = b.execHooks(rls, rspb.Hook_POST_DELETE, timeout)
+		if err != nil {
+			return rls, err
+		}
+	}
+
+	err = b.store.purge(namespace, name)
+	if err != nil {
+		return rls, err
+	}
+	rls.Info.Status.Code = rspb.Status_DELETED
+	rls.Info.Description = "Deletion complete"
+	return rls, nil
+}
+
+func (b *storageBackend) Get(r *Release) (*rspb.Release, error) {
+	rls, err := b.last(r.Chartmgr.ObjectMeta.Namespace, r.Name())
+	if err != nil {
+		return nil, err
+	}
+	if rls == nil || statusCode(rls) == rspb.Status_DELETED {
+		log.Debugf("Helm release %s not found", r.Name())
+		return nil, &ReleaseNotFoundError{Name: r.Name()}
+	}
+	return rls, nil
+}
+
+func (b *storageBackend) History(r *Release, max int32) ([]*rspb.Release, error) {
+	history, err := b.store.history(r.Chartmgr.ObjectMeta.Namespace, r.Name())
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && len(history) > int(max) {
+		history = history[:max]
+	}
+	return history, nil
+}
+
+func (b *storageBackend) Test(r *Release) ([]string, error) {
+	rls, err := b.Get(r)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := r.Client.Config().ReleaseTimeoutSec
+	suite := &rspb.TestSuite{StartedAt: ptypes.TimestampNow()}
+	msgs := []string{}
+	failed := []string{}
+	for _, hook := range sortedHooks(rls, rspb.Hook_RELEASE_TEST_SUCCESS, rspb.Hook_RELEASE_TEST_FAILURE) {
+		run := &rspb.TestRun{Name: hook.Name, StartedAt: ptypes.TimestampNow()}
+		msgs = append(msgs, fmt.Sprintf("RUNNING: %s", hook.Name))
+		err = b.resources.Create(rls.Namespace, strings.NewReader(hook.Manifest), timeout, false)
+		var phase api.PodPhase
+		if err == nil {
+			phase, err = b.resources.WaitAndGetCompletedPodPhase(rls.Namespace, strings.NewReader(hook.Manifest), time.Duration(timeout)*time.Second)
+		}
+
+		expected := api.PodSucceeded
+		if hasEvent(hook, rspb.Hook_RELEASE_TEST_FAILURE) {
+			expected = api.PodFailed
+		}
+		switch {
+		case err != nil:
+			run.Status = rspb.TestRun_FAILURE
+			run.Info = err.Error()
+		case phase != expected:
+			run.Status = rspb.TestRun_FAILURE
+			run.Info = fmt.Sprintf("pod completed with phase %s", phase)
+		default:
+			run.Status = rspb.TestRun_SUCCESS
+		}
+		run.CompletedAt = ptypes.TimestampNow()
+		suite.Results = append(suite.Results, run)
+
+		if run.Status == rspb.TestRun_SUCCESS {
+			msgs = append(msgs, fmt.Sprintf("PASSED: %s", hook.Name))
+		} else {
+			msg := fmt.Sprintf("FAILED: %s, run `kubectl logs %s --namespace %s` for more info", hook.Name, hook.Name, rls.Namespace)
+			msgs = append(msgs, msg)
+			failed = append(failed, msg)
+		}
+
+		// test pods are cleaned up, like with helm test --cleanup
+		err = b.resources.Delete(rls.Namespace, strings.NewReader(hook.Manifest))
+		if err != nil {
+			log.Warnf("Failed to delete test %s of release %s: %v", hook.Name, rls.Name, err)
+		}
+	}
+	suite.CompletedAt = ptypes.TimestampNow()
+
+	rls.Info.Status.LastTestSuiteRun = suite
+	err = b.store.update(rls)
+	if err != nil {
+		return msgs, err
+	}
+	if len(failed) > 0 {
+		return msgs, fmt.Errorf("Tests of release %s failed: %s", rls.Name, strings.Join(failed, "; "))
+	}
+	return msgs, nil
+}
+
+func (b *storageBackend) List(prefix string) ([]*rspb.Release, error) {
+	revisions, err := b.store.list("", map[string]string{releaseSecretManagedLabel: "true"})
+	if err != nil {
+		return nil, err
+	}
+	// revisions are listed newest first, so the first revision of a release
+	// is its current one
+	seen := map[string]bool{}
+	releases := []*rspb.Release{}
+	for _, rls := range revisions {
+		key := rls.Namespace + "/" + rls.Name
+		if seen[key] || !strings.HasPrefix(rls.Name, prefix) {
+			continue
+		}
+		seen[key] = true
+		releases = append(releases, rls)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Name < releases[j].Name
+	})
+	return releases, nil
+}
+
+// render renders the chart with the capabilities of the cluster
+func (b *storageBackend) render(ch *chart.Chart, vals []byte, options chartutil.ReleaseOptions) (*renderedRelease, error) {
+	caps, err := capabilities(b.discovery)
+	if err != nil {
+		return nil, err
+	}
+	return renderRelease(ch, vals, options, caps)
+}
+
+// last returns the newest revision of the release, or nil if it doesn't
+// exist
+func (b *storageBackend) last(namespace string, name string) (*rspb.Release, error) {
+	history, err := b.store.history(namespace, name)
+	if err != nil || len(history) < 1 {
+		return nil, err
+	}
+	return history[0], nil
+}
+
+// replace updates the resources of the current revision of the release to
+// those of the new revision, running the hooks of the events before and
+// after, and supersedes the current revision
+func (b *storageBackend) replace(current *rspb.Release, rls *rspb.Release, pre rspb.Hook_Event, post rspb.Hook_Event, timeout int64, description string) (*rspb.Release, error) {
+	err := b.execHooks(rls, pre, timeout)
+	if err == nil {
+		err = b.resources.Update(rls.Namespace, strings.NewReader(current.Manifest), strings.NewReader(rls.Manifest), false, false, timeout, true)
+	}
+	if err == nil {
+		err = b.execHooks(rls, post, timeout)
+	}
+
+	// like tiller, the current revision is superseded even if the new
+	// revision failed
+	current.Info.Status.Code = rspb.Status_SUPERSEDED
+	serr := b.store.update(current)
+	if err != nil {
+		return b.failed(rls, err)
+	}
+	if serr != nil {
+		return rls, serr
+	}
+	return b.deployed(rls, description)
+}
+
+func (b *storageBackend) deployed(rls *rspb.Release, description string) (*rspb.Release, error) {
+	rls.Info.Status.Code = rspb.Status_DEPLOYED
+	rls.Info.Description = description
+	return rls, b.store.update(rls)
+}
+
+// failed records the release as failed and returns the error that failed it
+func (b *storageBackend) failed(rls *rspb.Release, err error) (*rspb.Release, error) {
+	log.Warnf("Release %s failed: %v", rls.Name, err)
+	rls.Info.Status.Code = rspb.Status_FAILED
+	rls.Info.Description = fmt.Sprintf("Release %q failed: %s", rls.Name, err)
+	serr := b.store.update(rls)
+	if serr != nil {
+		log.Errorf("Failed to record failure of release %s: %v", rls.Name, serr)
+	}
+	return rls, err
+}
+
+// execHooks runs the hooks of the event in order of weight, waiting until
+// each is ready, and deletes them as their delete policies require
+func (b *storageBackend) execHooks(rls *rspb.Release, event rspb.Hook_Event, timeout int64) error {
+	hooks := sortedHooks(rls, event)
+	for _, hook := range hooks {
+		hook.LastRun = ptypes.TimestampNow()
+		err := b.resources.Create(rls.Namespace, strings.NewReader(hook.Manifest), timeout, false)
+		if err != nil {
+			return fmt.Errorf("Failed to create %s hook %s of release %s: %v", event, hook.Name, rls.Name, err)
+		}
+		err = b.resources.WatchUntilReady(rls.Namespace, strings.NewReader(hook.Manifest), timeout, false)
+		if err != nil {
+			if hasDeletePolicy(hook, rspb.Hook_FAILED) {
+				b.deleteHook(rls, hook)
+			}
+			return fmt.Errorf("%s hook %s of release %s failed: %v", event, hook.Name, rls.Name, err)
+		}
+	}
+	for _, hook := range hooks {
+		if hasDeletePolicy(hook, rspb.Hook_SUCCEEDED) {
+			b.deleteHook(rls, hook)
+		}
+	}
+	return nil
+}
+
+func (b *storageBackend) deleteHook(rls *rspb.Release, hook *rspb.Hook) {
+	err := b.resources.Delete(rls.Namespace, strings.NewReader(hook.Manifest))
+	if err != nil {
+		log.Warnf("Failed to delete hook %s of release %s: %v", hook.Name, rls.Name, err)
+	}
+}
+
+// sortedHooks returns the hooks of the release for any of the events,
+// sorted by weight and name
+func sortedHooks(rls *rspb.Release, events ...rspb.Hook_Event) []*rspb.Hook {
+	hooks := []*rspb.Hook{}
+	for _, hook := range rls.Hooks {
+		for _, event := range events {
+			if hasEvent(hook, event) {
+				hooks = append(hooks, hook)
+				break
+			}
+		}
+	}
+	sort.SliceStable(hooks, func(i, j int) bool {
+		if hooks[i].Weight != hooks[j].Weight {
+			return hooks[i].Weight < hooks[j].Weight
+		}
+		return hooks[i].Name < hooks[j].Name
+	})
+	return hooks
+}
+
+func hasEvent(hook *rspb.Hook, event rspb.Hook_Event) bool {
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDeletePolicy(hook *rspb.Hook, policy rspb.Hook_DeletePolicy) bool {
+	for _, p := range hook.DeletePolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+func newStoredRelease(name string, namespace string, revision int32, ch *chart.Chart, vals []byte, rendered *renderedRelease) *rspb.Release {
+	return &rspb.Release{
+		Name:      name,
+		Namespace: namespace,
+		Chart:     ch,
+		Config:    &chart.Config{Raw: string(vals)},
+		Manifest:  rendered.manifest,
+		Hooks:     rendered.hooks,
+		Version:   revision,
+		Info: &rspb.Info{
+			Status: &rspb.Status{Notes: rendered.notes},
+		},
+	}
+}
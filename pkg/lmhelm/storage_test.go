@@ -0,0 +1,420 @@
+package lmhelm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	yaml "github.com/ghodss/yaml"
+	clientfake "github.com/logicmonitor/k8s-chart-manager-controller/pkg/client/fake"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// fakeResources records the resources created, updated and deleted by kind
+// and name, e.g. "create Deployment rls-app"
+type fakeResources struct {
+	calls []string
+	// fail fails the calls starting with the prefix
+	fail string
+	// phase is the phase test pods complete with
+	phase api.PodPhase
+}
+
+func (f *fakeResources) record(op string, readers ...io.Reader) error {
+	heads := []string{}
+	for _, r := range readers {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		for _, content := range manifestSeparator.Split(string(b), -1) {
+			var head manifestHead
+			err = yaml.Unmarshal([]byte(content), &head)
+			if err != nil {
+				return err
+			}
+			if head.Metadata == nil {
+				continue
+			}
+			// the API server requires services to have DNS-1035 names
+			if errs := validation.IsDNS1035Label(head.Metadata.Name); head.Kind == "Service" && len(errs) > 0 {
+				return fmt.Errorf("Service %q is invalid: %s", head.Metadata.Name, strings.Join(errs, ", "))
+			}
+			heads = append(heads, head.Kind+" "+head.Metadata.Name)
+		}
+	}
+	call := op + " " + strings.Join(heads, ",")
+	f.calls = append(f.calls, call)
+	if f.fail != "" && strings.HasPrefix(call, f.fail) {
+		return fmt.Errorf("%s failed", call)
+	}
+	return nil
+}
+
+func (f *fakeResources) Create(namespace string, reader io.Reader, timeout int64, shouldWait bool) error {
+	return f.record("create", reader)
+}
+
+func (f *fakeResources) Update(namespace string, originalReader, targetReader io.Reader, force bool, recreate bool, timeout int64, shouldWait bool) error {
+	return f.record("update", targetReader)
+}
+
+func (f *fakeResources) Delete(namespace string, reader io.Reader) error {
+	return f.record("delete", reader)
+}
+
+func (f *fakeResources) WatchUntilReady(namespace string, reader io.Reader, timeout int64, shouldWait bool) error {
+	return f.record("watch", reader)
+}
+
+func (f *fakeResources) WaitAndGetCompletedPodPhase(namespace string, reader io.Reader, timeout time.Duration) (api.PodPhase, error) {
+	return f.phase, f.record("wait", reader)
+}
+
+func newTestStorageBackend(t *testing.T) (*Release, *storageBackend, *fakeResources, *clientfake.KubeAPI, func()) {
+	r, kubeAPI, cleanup := newTestRelease(t, &config.Config{ReleaseTimeoutSec: 60})
+	r.name = "rls"
+	resources := &fakeResources{phase: api.PodSucceeded}
+	b := &storageBackend{
+		store:     &releaseStore{client: kubeAPI.Clientset},
+		resources: resources,
+		discovery: kubeAPI.Clientset.Discovery(),
+	}
+	return r, b, resources, kubeAPI, cleanup
+}
+
+// newTestStorageChart returns the test chart with API versions the fake API
+// server serves
+func newTestStorageChart() *chart.Chart {
+	ch := newTestChart()
+	for _, t := range ch.Templates {
+		t.Data = bytes.Replace(t.Data, []byte("extensions/v1beta1"), []byte("v1"), -1)
+		t.Data = bytes.Replace(t.Data, []byte("batch/v1"), []byte("v1"), -1)
+	}
+	return ch
+}
+
+func revisions(t *testing.T, b *storageBackend, r *Release) string {
+	history, err := b.History(r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{}
+	for _, rls := range history {
+		statuses = append(statuses, fmt.Sprintf("%d:%s", rls.Version, releaseStatuses[statusCode(rls)]))
+	}
+	return strings.Join(statuses, " ")
+}
+
+func TestStorageBackendLifecycle(t *testing.T) {
+	r, b, resources, _, cleanup := newTestStorageBackend(t)
+	defer cleanup()
+
+	rls, err := b.Install(r, newTestStorageChart(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rls.Info.Status.Notes != "Installed rls in tenant" {
+		t.Errorf("notes = %q", rls.Info.Status.Notes)
+	}
+	wantCalls := []string{
+		"create Job migrate",
+		"watch Job migrate",
+		"delete Job migrate",
+		"create ConfigMap rls-sub,ServiceAccount rls-app,Service rls-app,Deployment rls-app",
+	}
+	if !reflect.DeepEqual(resources.calls, wantCalls) {
+		t.Errorf("install calls = %q, want %q", resources.calls, wantCalls)
+	}
+	if got := revisions(t, b, r); got != "1:deployed" {
+		t.Errorf("revisions after install = %s", got)
+	}
+	_, err = b.Install(r, newTestStorageChart(), nil)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second Install() error = %v", err)
+	}
+
+	resources.calls = nil
+	_, err = b.Upgrade(r, newTestStorageChart(), []byte("replicas: 3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := revisions(t, b, r); got != "2:deployed 1:superseded" {
+		t.Errorf("revisions after upgrade = %s", got)
+	}
+	current, err := b.Get(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(current.Manifest, "replicas: 3") || current.Config.Raw != "replicas: 3\n" {
+		t.Errorf("upgraded release = %v", current)
+	}
+
+	resources.fail = "update"
+	_, err = b.Upgrade(r, newTestStorageChart(), []byte("replicas: 4\n"))
+	if err == nil {
+		t.Fatal("Upgrade() succeeded despite the failed update")
+	}
+	if got := revisions(t, b, r); got != "3:failed 2:superseded 1:superseded" {
+		t.Errorf("revisions after failed upgrade = %s", got)
+	}
+
+	resources.fail = ""
+	rls, err = b.Rollback(r, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rls.Info.Description != "Rollback to 2" || !strings.Contains(rls.Manifest, "replicas: 3") {
+		t.Errorf("rolled back release = %v", rls)
+	}
+	if got := revisions(t, b, r); got != "4:deployed 3:superseded 2:superseded 1:superseded" {
+		t.Errorf("revisions after rollback = %s", got)
+	}
+
+	resources.calls = nil
+	msgs, err := b.Test(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"RUNNING: smoke-test", "PASSED: smoke-test"}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("test messages = %q, want %q", msgs, want)
+	}
+	resources.phase = api.PodFailed
+	_, err = b.Test(r)
+	if err == nil || !strings.Contains(err.Error(), "FAILED: smoke-test") {
+		t.Errorf("Test() error = %v", err)
+	}
+
+	resources.calls = nil
+	_, err = b.Delete(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the service has the keep resource policy
+	wantCalls = []string{
+		"delete Deployment rls-app",
+		"delete ServiceAccount rls-app",
+		"delete ConfigMap rls-sub",
+	}
+	if !reflect.DeepEqual(resources.calls, wantCalls) {
+		t.Errorf("delete calls = %q, want %q", resources.calls, wantCalls)
+	}
+	_, err = b.Get(r)
+	if !IsReleaseNotFound(err) {
+		t.Errorf("Get() after delete error = %v", err)
+	}
+	if got := revisions(t, b, r); got != "" {
+		t.Errorf("revisions after delete = %s", got)
+	}
+}
+
+func TestStorageBackendFailedInstall(t *testing.T) {
+	r, b, resources, _, cleanup := newTestStorageBackend(t)
+	defer cleanup()
+
+	resources.fail = "watch Job migrate"
+	_, err := b.Install(r, newTestStorageChart(), nil)
+	if err == nil {
+		t.Fatal("Install() succeeded despite the failed hook")
+	}
+	if got := revisions(t, b, r); got != "1:failed" {
+		t.Errorf("revisions after failed install = %s", got)
+	}
+	// a failed release can be upgraded
+	resources.fail = ""
+	_, err = b.Upgrade(r, newTestStorageChart(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := revisions(t, b, r); got != "2:deployed 1:superseded" {
+		t.Errorf("revisions after upgrade = %s", got)
+	}
+
+	// the name of a failed release is reused
+	r = r.WithName("other")
+	resources.fail = "create ConfigMap"
+	_, err = b.Install(r, newTestStorageChart(), nil)
+	if err == nil {
+		t.Fatal("Install() succeeded despite the failed create")
+	}
+	resources.fail = ""
+	_, err = b.Install(r, newTestStorageChart(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := revisions(t, b, r); got != "1:deployed" {
+		t.Errorf("revisions after reinstall = %s", got)
+	}
+}
+
+func TestStorageBackendHelm3Format(t *testing.T) {
+	r, b, _, kubeAPI, cleanup := newTestStorageBackend(t)
+	defer cleanup()
+
+	_, err := b.Install(r, newTestStorageChart(), []byte("replicas: 3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := kubeAPI.Objects("secrets", "tenant")
+	if len(objects) != 1 {
+		t.Fatalf("secrets = %v, want 1", objects)
+	}
+	secret := objects[0].(*v1.Secret)
+	if secret.ObjectMeta.Name != "sh.helm.release.v1.rls.v1" || secret.Type != "helm.sh/release.v1" {
+		t.Errorf("secret %s of type %s", secret.ObjectMeta.Name, secret.Type)
+	}
+	wantLabels := map[string]string{"name": "rls", "owner": "helm", "status": "deployed", "version": "1", releaseSecretManagedLabel: "true"}
+	if !reflect.DeepEqual(secret.ObjectMeta.Labels, wantLabels) {
+		t.Errorf("labels = %v, want %v", secret.ObjectMeta.Labels, wantLabels)
+	}
+
+	// the release is gzipped JSON, base64 encoded
+	gz, err := base64.StdEncoding.DecodeString(string(secret.Data["release"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored struct {
+		Name      string                 `json:"name"`
+		Namespace string                 `json:"namespace"`
+		Version   int                    `json:"version"`
+		Config    map[string]interface{} `json:"config"`
+		Info      struct {
+			Status string `json:"status"`
+		} `json:"info"`
+		Chart struct {
+			Metadata struct {
+				Name       string `json:"name"`
+				APIVersion string `json:"apiVersion"`
+			} `json:"metadata"`
+			Values map[string]interface{} `json:"values"`
+		} `json:"chart"`
+		Hooks []struct {
+			Events         []string `json:"events"`
+			DeletePolicies []string `json:"delete_policies"`
+		} `json:"hooks"`
+	}
+	err = json.NewDecoder(zr).Decode(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "rls" || stored.Namespace != "tenant" || stored.Version != 1 || stored.Info.Status != "deployed" {
+		t.Errorf("stored release = %+v", stored)
+	}
+	if stored.Config["replicas"] != float64(3) || stored.Chart.Values["replicas"] != float64(2) {
+		t.Errorf("stored values = %v, chart values = %v", stored.Config, stored.Chart.Values)
+	}
+	if stored.Chart.Metadata.Name != "app" || stored.Chart.Metadata.APIVersion != "v1" {
+		t.Errorf("stored chart metadata = %+v", stored.Chart.Metadata)
+	}
+	events := [][]string{}
+	for _, hook := range stored.Hooks {
+		events = append(events, hook.Events)
+	}
+	if want := [][]string{{"pre-install", "pre-upgrade"}, {"test"}}; !reflect.DeepEqual(events, want) {
+		t.Errorf("hook events = %q, want %q", events, want)
+	}
+	if want := []string{"hook-succeeded"}; !reflect.DeepEqual(stored.Hooks[0].DeletePolicies, want) {
+		t.Errorf("hook delete policies = %q, want %q", stored.Hooks[0].DeletePolicies, want)
+	}
+}
+
+func TestStorageBackendList(t *testing.T) {
+	r, b, _, kubeAPI, cleanup := newTestStorageBackend(t)
+	defer cleanup()
+
+	// a revision this controller can't decode doesn't hide the others, and
+	// the releases of other helm clients aren't listed at all
+	foreign := []struct {
+		name   string
+		labels map[string]string
+	}{
+		{"sh.helm.release.v1.chartmgr-rls-corrupt.v1", map[string]string{"owner": releaseSecretOwner, releaseSecretManagedLabel: "true"}},
+		{"sh.helm.release.v1.chartmgr-rls-helm.v1", map[string]string{"owner": releaseSecretOwner}},
+	}
+	for _, f := range foreign {
+		kubeAPI.Set("secrets", "tenant", f.name, &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: f.name, Namespace: "tenant", Labels: f.labels},
+			Type:       releaseSecretType,
+			Data:       map[string][]byte{releaseSecretKey: []byte("%%%")},
+		})
+	}
+
+	installs := []struct {
+		namespace string
+		name      string
+	}{
+		{"tenant", "chartmgr-rls-a"},
+		{"other", "chartmgr-rls-b"},
+		{"tenant", "explicit"},
+	}
+	for _, i := range installs {
+		rls := r.WithName(i.name)
+		rls.Chartmgr = rls.Chartmgr.DeepCopy()
+		rls.Chartmgr.ObjectMeta.Namespace = i.namespace
+		_, err := b.Install(rls, newTestStorageChart(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := b.Upgrade(r.WithName("chartmgr-rls-a"), newTestStorageChart(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	releases, err := b.List("chartmgr-rls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, rls := range releases {
+		got = append(got, fmt.Sprintf("%s/%s.v%d", rls.Namespace, rls.Name, rls.Version))
+	}
+	if want := []string{"tenant/chartmgr-rls-a.v2", "other/chartmgr-rls-b.v1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeReleaseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not base64", []byte("%%%")},
+		{"not JSON", []byte(base64.StdEncoding.EncodeToString([]byte("{")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeRelease(tt.data)
+			if err == nil {
+				t.Error("decodeRelease() succeeded")
+			}
+		})
+	}
+
+	// releases helm 3 stored uncompressed are read too
+	rls, err := decodeRelease([]byte(base64.StdEncoding.EncodeToString([]byte(`{"name":"rls","version":2,"info":{"status":"superseded"}}`))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rls.Name != "rls" || rls.Version != 2 || statusCode(rls) != rspb.Status_SUPERSEDED {
+		t.Errorf("decoded release = %v", rls)
+	}
+}
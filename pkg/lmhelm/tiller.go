@@ -0,0 +1,220 @@
+package lmhelm
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// tillerBackend manages releases through Tiller
+type tillerBackend struct {
+	conn *tillerConnection
+}
+
+// newTillerBackend returns the backend of the configured tiller. chart
+// managers selecting another tiller use the backends of the pool.
+func (c *Client) newTillerBackend() (*tillerBackend, error) {
+	pool, err := c.newTillerPool()
+	if err != nil {
+		return nil, err
+	}
+	c.tillers = pool
+	return pool.get(pool.defaults), nil
+}
+
+// tillerHost returns the host of the tiller, or sets up a port forwarding
+// tunnel to the tiller pod and returns its local address
+func (c *Client) tillerHost(target tillerTarget) (string, *tillerTunnel, error) {
+	if target.host != "" {
+		return target.host, nil, nil
+	}
+
+	log.Debugf("Setting up port forwarding tunnel to tiller in namespace %s", target.namespace)
+	tunnel, err := c.openTillerTunnel(target.namespace)
+	if err != nil {
+		return "", nil, err
+	}
+	log.Debugf("Set up port forwarding tunnel to tiller in namespace %s", target.namespace)
+
+	return fmt.Sprintf("127.0.0.1:%d", tunnel.local), tunnel, nil
+}
+
+// Ready returns an error if Tiller isn't reachable
+func (b *tillerBackend) Ready() error {
+	return b.conn.Ready()
+}
+
+// Reconnected returns a channel that is closed once any tiller is reachable
+// again
+func (b *tillerBackend) Reconnected() <-chan struct{} {
+	return b.conn.reconnected.wait()
+}
+
+func (b *tillerBackend) Install(r *Release, chart *chart.Chart, vals []byte) (*rspb.Release, error) {
+	h, err := b.conn.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := h.InstallReleaseFromChart(chart, r.Chartmgr.ObjectMeta.Namespace, installOpts(r, vals)...)
+	if rsp != nil && rsp.Release != nil {
+		return rsp.Release, nil
+	}
+	return b.current(r, b.conn.observe(err))
+}
+
+func (b *tillerBackend) Upgrade(r *Release, chart *chart.Chart, vals []byte) (*rspb.Release, error) {
+	h, err := b.conn.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := h.UpdateReleaseFromChart(r.Name(), chart, updateOpts(r, vals)...)
+	if rsp != nil && rsp.Release != nil {
+		return rsp.Release, nil
+	}
+	return b.current(r, b.conn.observe(err))
+}
+
+func (b *tillerBackend) Delete(r *Release) (*rspb.Release, error) {
+	h, err := b.conn.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := h.DeleteRelease(r.Name(), deleteOpts(r)...)
+	if rsp != nil && rsp.Release != nil {
+		return rsp.Release, nil
+	}
+	return b.current(r, b.conn.observe(err))
+}
+
+func (b *tillerBackend) Get(r *Release) (*rspb.Release, error) {
+	h, err := b.conn.helmClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// the history is looked up by exact name, unlike the release list filter
+	// which is a regular expression matching other releases too
+	rsp, err := h.ReleaseHistory(r.Name(), helm.WithMaxHistory(1))
+	if err != nil {
+		if tillerNotFound(err, r.Name()) {
+			log.Debugf("Helm release %s not found", r.Name())
+			return nil, &ReleaseNotFoundError{Name: r.Name()}
+		}
+		return nil, b.conn.observe(err)
+	}
+
+	// a release deleted without purging keeps its history
+	if len(rsp.Releases) < 1 || statusCode(rsp.Releases[0]) == rspb.Status_DELETED {
+		log.Debugf("Helm release %s not found", r.Name())
+		return nil, &ReleaseNotFoundError{Name: r.Name()}
+	}
+	return rsp.Releases[0], nil
+}
+
+func (b *tillerBackend) History(r *Release, max int32) ([]*rspb.Release, error) {
+	h, err := b.conn.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := h.ReleaseHistory(r.Name(), helm.WithMaxHistory(max))
+	if err != nil {
+		return nil, b.conn.observe(err)
+	}
+	return rsp.Releases, nil
+}
+
+func (b *tillerBackend) Rollback(r *Release, revision int32) (*rspb.Release, error) {
+	h, err := b.conn.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := h.RollbackRelease(r.Name(), rollbackOpts(r, revision)...)
+	if rsp != nil && rsp.Release != nil {
+		return rsp.Release, nil
+	}
+	return b.current(r, b.conn.observe(err))
+}
+
+func (b *tillerBackend) Test(r *Release) ([]string, error) {
+	h, err := b.conn.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	results, errc := h.RunReleaseTest(r.Name(), testOpts(r)...)
+	msgs := []string{}
+	failed := []string{}
+	for {
+		select {
+		case err, ok := <-errc:
+			if !ok {
+				// closed along with results
+				errc = nil
+				continue
+			}
+			return msgs, b.conn.observe(err)
+		case res, ok := <-results:
+			if !ok {
+				if len(failed) > 0 {
+					return msgs, fmt.Errorf("Tests of release %s failed: %s", r.Name(), strings.Join(failed, "; "))
+				}
+				return msgs, nil
+			}
+			msgs = append(msgs, res.Msg)
+			if res.Status == rspb.TestRun_FAILURE {
+				failed = append(failed, res.Msg)
+			}
+		}
+	}
+}
+
+func (b *tillerBackend) List(prefix string) ([]*rspb.Release, error) {
+	h, err := b.conn.helmClient()
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []*rspb.Release{}
+	offset := ""
+	for {
+		rsp, err := h.ListReleases(listOpts(prefix, offset)...)
+		if err != nil {
+			return nil, b.conn.observe(err)
+		}
+		releases = append(releases, rsp.Releases...)
+		if rsp.Next == "" {
+			return releases, nil
+		}
+		offset = rsp.Next
+	}
+}
+
+// tillerNotFound returns true if the error is tiller's storage error for a
+// missing release, which only reaches the client as a message
+func tillerNotFound(err error, name string) bool {
+	return strings.Contains(grpc.ErrorDesc(err), fmt.Sprintf("release: %q not found", name))
+}
+
+func statusCode(rls *rspb.Release) rspb.Status_Code {
+	if rls.Info == nil || rls.Info.Status == nil {
+		return rspb.Status_UNKNOWN
+	}
+	return rls.Info.Status.Code
+}
+
+// current returns the release as it is after a failed operation, since
+// tiller may have recorded a release even if it returned an error
+func (b *tillerBackend) current(r *Release, err error) (*rspb.Release, error) {
+	if IsConnectionError(err) {
+		return nil, err
+	}
+	rls, _ := b.Get(r)
+	if rls != nil {
+		return rls, nil
+	}
+	return nil, err
+}
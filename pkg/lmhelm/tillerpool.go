@@ -0,0 +1,200 @@
+package lmhelm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+// tillerTarget identifies a Tiller by its host or, for a port forwarding
+// tunnel, by the namespace it runs in
+type tillerTarget struct {
+	namespace string
+	host      string
+}
+
+func (t tillerTarget) String() string {
+	if t.host != "" {
+		return t.host
+	}
+	return "in namespace " + t.namespace
+}
+
+// tillerRule allows the chart managers of a namespace to select a Tiller. "*"
+// matches any namespace or Tiller.
+type tillerRule struct {
+	namespace string
+	tiller    string
+}
+
+// tillerPool lazily creates and caches the backend of each Tiller that chart
+// managers select, and checks the selections against the Tiller policy.
+// backends of Tillers no chart manager selected for the idle timeout are
+// closed, except the default Tiller's.
+type tillerPool struct {
+	mu          sync.Mutex
+	client      *Client
+	tls         *tillerTLS
+	defaults    tillerTarget
+	rules       []tillerRule
+	reconnected *broadcast
+	idleTimeout time.Duration
+	backends    map[tillerTarget]*tillerBackend
+	lastUsed    map[tillerTarget]time.Time
+}
+
+func (c *Client) newTillerPool() (*tillerPool, error) {
+	if c.chartmgrconfig.TillerHealthCheckIntervalSec <= 0 {
+		return nil, fmt.Errorf("Tiller health check interval must be positive, got %d", c.chartmgrconfig.TillerHealthCheckIntervalSec)
+	}
+	tillerTLS, err := c.newTillerTLS()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := parseTillerRules(c.chartmgrconfig.AllowedTillers)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &tillerPool{
+		client:      c,
+		tls:         tillerTLS,
+		defaults:    newTillerTarget(c.settings.TillerNamespace, c.settings.TillerHost),
+		rules:       rules,
+		reconnected: newBroadcast(),
+		idleTimeout: time.Duration(c.chartmgrconfig.TillerIdleTimeoutSec) * time.Second,
+		backends:    map[tillerTarget]*tillerBackend{},
+		lastUsed:    map[tillerTarget]time.Time{},
+	}
+	if p.idleTimeout > 0 {
+		go p.runEviction()
+	}
+	return p, nil
+}
+
+func newTillerTarget(namespace string, host string) tillerTarget {
+	if host != "" {
+		// the namespace doesn't matter without a tunnel
+		return tillerTarget{host: host}
+	}
+	return tillerTarget{namespace: namespace}
+}
+
+// get returns the backend of the tiller, connecting to it the first time
+func (p *tillerPool) get(target tillerTarget) *tillerBackend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b, ok := p.backends[target]
+	if !ok {
+		b = &tillerBackend{conn: newTillerConnection(p.client, target, p.tls, p.reconnected)}
+		p.backends[target] = b
+	}
+	p.lastUsed[target] = time.Now()
+	return b
+}
+
+func (p *tillerPool) runEviction() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		p.evictIdle(now)
+	}
+}
+
+// evictIdle closes the backends of the tillers no chart manager selected for
+// the idle timeout
+func (p *tillerPool) evictIdle(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for target, b := range p.backends {
+		if target == p.defaults || now.Sub(p.lastUsed[target]) < p.idleTimeout {
+			continue
+		}
+		log.Infof("Closing the connection to tiller %s, unused since %s", target, p.lastUsed[target].Format(time.RFC3339))
+		b.conn.close()
+		delete(p.backends, target)
+		delete(p.lastUsed, target)
+	}
+}
+
+// all returns the backends of the tillers connected to so far by the tiller
+// they connect to, the default tiller by the empty string
+func (p *tillerPool) all() map[string]ReleaseBackend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	backends := map[string]ReleaseBackend{}
+	for target, b := range p.backends {
+		if target == p.defaults {
+			backends[""] = b
+		} else {
+			backends[target.String()] = b
+		}
+	}
+	return backends
+}
+
+// selected returns the backend of the tiller the chart manager selects, if
+// the tiller policy allows its namespace to use it
+func (p *tillerPool) selected(chartmgr *crv1alpha1.ChartManager) (*tillerBackend, error) {
+	spec := chartmgr.Spec.Tiller
+	if spec.Namespace != "" && spec.Host != "" {
+		return nil, errors.New("Tiller namespace and host are mutually exclusive")
+	}
+	if spec.Namespace == "" && spec.Host == "" {
+		return nil, errors.New("Tiller namespace or host is required")
+	}
+
+	target := newTillerTarget(spec.Namespace, spec.Host)
+	if !p.allowed(chartmgr.ObjectMeta.Namespace, target) {
+		return nil, fmt.Errorf("Chart managers in namespace %s are not allowed to use tiller %s", chartmgr.ObjectMeta.Namespace, target)
+	}
+	return p.get(target), nil
+}
+
+// allowed returns true if chart managers in the namespace may use the
+// tiller. the default tiller and a tiller in the same namespace are always
+// allowed.
+func (p *tillerPool) allowed(namespace string, target tillerTarget) bool {
+	if target == p.defaults || target.namespace == namespace {
+		return true
+	}
+	for _, rule := range p.rules {
+		if rule.namespace != "*" && rule.namespace != namespace {
+			continue
+		}
+		if rule.tiller == "*" || rule.tiller == target.namespace || rule.tiller == target.host {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTillerRules parses "namespace=tiller" entries, where tiller is the
+// namespace a tiller runs in or its host
+func parseTillerRules(entries []string) ([]tillerRule, error) {
+	rules := []tillerRule{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid allowed tiller %q. Expected namespace=tiller", entry)
+		}
+		rule := tillerRule{namespace: strings.TrimSpace(parts[0]), tiller: strings.TrimSpace(parts[1])}
+		if rule.namespace == "" || rule.tiller == "" {
+			return nil, fmt.Errorf("Invalid allowed tiller %q. Expected namespace=tiller", entry)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
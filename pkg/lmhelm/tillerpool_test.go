@@ -0,0 +1,315 @@
+package lmhelm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTillerPoolEvictIdle(t *testing.T) {
+	defaults := tillerTarget{namespace: "kube-system"}
+	idle := tillerTarget{namespace: "idle"}
+	used := tillerTarget{host: "tiller.used:44134"}
+	p := &tillerPool{
+		defaults:    defaults,
+		idleTimeout: time.Hour,
+		backends:    map[tillerTarget]*tillerBackend{},
+		lastUsed:    map[tillerTarget]time.Time{},
+	}
+
+	now := time.Now()
+	stopped := map[tillerTarget]chan struct{}{}
+	for target, lastUsed := range map[tillerTarget]time.Time{
+		defaults: now.Add(-2 * time.Hour),
+		idle:     now.Add(-2 * time.Hour),
+		used:     now.Add(-time.Minute),
+	} {
+		conn := &tillerConnection{
+			target:      target,
+			check:       make(chan struct{}, 1),
+			stop:        make(chan struct{}),
+			reconnected: newBroadcast(),
+		}
+		done := make(chan struct{})
+		go func() {
+			conn.run(time.Hour)
+			close(done)
+		}()
+		stopped[target] = done
+		p.backends[target] = &tillerBackend{conn: conn}
+		p.lastUsed[target] = lastUsed
+	}
+
+	closed := p.backends[idle].conn
+	p.evictIdle(now)
+
+	if _, ok := p.backends[idle]; ok {
+		t.Error("the idle tiller wasn't evicted")
+	}
+	for _, target := range []tillerTarget{defaults, used} {
+		if _, ok := p.backends[target]; !ok {
+			t.Errorf("tiller %s was evicted", target)
+		}
+	}
+	select {
+	case <-stopped[idle]:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the health checks of the idle tiller didn't stop")
+	}
+	if _, err := closed.helmClient(); err == nil {
+		t.Error("the closed connection still has a helm client")
+	}
+	closed.setErr(nil)
+	if closed.Ready() == nil {
+		t.Error("the closed connection became ready")
+	}
+
+	if backends := p.all(); len(backends) != 2 || backends[""] == nil || backends[used.String()] == nil {
+		t.Errorf("all() = %v", backends)
+	}
+}
+
+func TestNewTillerPoolInvalidHealthCheckInterval(t *testing.T) {
+	for _, interval := range []int64{0, -30} {
+		c := &Client{chartmgrconfig: &config.Config{TillerHealthCheckIntervalSec: interval}}
+		_, err := c.newTillerPool()
+		want := fmt.Sprintf("Tiller health check interval must be positive, got %d", interval)
+		if err == nil || err.Error() != want {
+			t.Errorf("newTillerPool() error = %v, want %s", err, want)
+		}
+	}
+}
+
+func TestParseTillerRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []tillerRule
+		wantErr string
+	}{
+		{
+			name: "none",
+			want: []tillerRule{},
+		},
+		{
+			name:    "namespace and tiller",
+			entries: []string{"ops=tiller", " ci = shared ", "*=tiller.shared:44134", "dev=*"},
+			want: []tillerRule{
+				{namespace: "ops", tiller: "tiller"},
+				{namespace: "ci", tiller: "shared"},
+				{namespace: "*", tiller: "tiller.shared:44134"},
+				{namespace: "dev", tiller: "*"},
+			},
+		},
+		{
+			name:    "empty entries",
+			entries: []string{"", "  ", "ops=tiller"},
+			want:    []tillerRule{{namespace: "ops", tiller: "tiller"}},
+		},
+		{
+			name:    "host with an equal sign",
+			entries: []string{"ops=tiller=1"},
+			want:    []tillerRule{{namespace: "ops", tiller: "tiller=1"}},
+		},
+		{
+			name:    "no tiller",
+			entries: []string{"ops"},
+			wantErr: `Invalid allowed tiller "ops". Expected namespace=tiller`,
+		},
+		{
+			name:    "empty namespace",
+			entries: []string{"=tiller"},
+			wantErr: `Invalid allowed tiller "=tiller". Expected namespace=tiller`,
+		},
+		{
+			name:    "empty tiller",
+			entries: []string{"ops=tiller", "ops="},
+			wantErr: `Invalid allowed tiller "ops=". Expected namespace=tiller`,
+		},
+		{
+			name:    "blank tiller",
+			entries: []string{"ops= "},
+			wantErr: `Invalid allowed tiller "ops=". Expected namespace=tiller`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTillerRules(tt.entries)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("parseTillerRules() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTillerRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTillerPoolAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     []string
+		namespace string
+		target    tillerTarget
+		want      bool
+	}{
+		{
+			name:      "default tiller",
+			namespace: "web",
+			target:    tillerTarget{namespace: "kube-system"},
+			want:      true,
+		},
+		{
+			name:      "tiller in the same namespace",
+			namespace: "web",
+			target:    tillerTarget{namespace: "web"},
+			want:      true,
+		},
+		{
+			name:      "other tiller without rules",
+			namespace: "web",
+			target:    tillerTarget{namespace: "ops"},
+			want:      false,
+		},
+		{
+			name:      "tiller namespace rule",
+			rules:     []string{"web=ops"},
+			namespace: "web",
+			target:    tillerTarget{namespace: "ops"},
+			want:      true,
+		},
+		{
+			name:      "tiller namespace rule of another namespace",
+			rules:     []string{"db=ops"},
+			namespace: "web",
+			target:    tillerTarget{namespace: "ops"},
+			want:      false,
+		},
+		{
+			name:      "tiller namespace rule for another tiller",
+			rules:     []string{"web=ops"},
+			namespace: "web",
+			target:    tillerTarget{namespace: "ci"},
+			want:      false,
+		},
+		{
+			name:      "tiller host rule",
+			rules:     []string{"web=tiller.shared:44134"},
+			namespace: "web",
+			target:    tillerTarget{host: "tiller.shared:44134"},
+			want:      true,
+		},
+		{
+			name:      "tiller namespace rule for a host",
+			rules:     []string{"web=ops"},
+			namespace: "web",
+			target:    tillerTarget{host: "tiller.ops:44134"},
+			want:      false,
+		},
+		{
+			name:      "any namespace",
+			rules:     []string{"*=ops"},
+			namespace: "web",
+			target:    tillerTarget{namespace: "ops"},
+			want:      true,
+		},
+		{
+			name:      "any tiller",
+			rules:     []string{"web=*"},
+			namespace: "web",
+			target:    tillerTarget{host: "tiller.shared:44134"},
+			want:      true,
+		},
+		{
+			name:      "any tiller of another namespace",
+			rules:     []string{"db=*"},
+			namespace: "web",
+			target:    tillerTarget{namespace: "ops"},
+			want:      false,
+		},
+		{
+			name:      "one of several rules",
+			rules:     []string{"db=*", "web=ci", "*=ops"},
+			namespace: "web",
+			target:    tillerTarget{namespace: "ops"},
+			want:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseTillerRules(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &tillerPool{defaults: tillerTarget{namespace: "kube-system"}, rules: rules}
+			if got := p.allowed(tt.namespace, tt.target); got != tt.want {
+				t.Errorf("allowed(%s, %s) = %v, want %v", tt.namespace, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTillerPoolSelectedRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		tiller  crv1alpha1.ChartMgrTiller
+		wantErr string
+	}{
+		{
+			name:    "tiller in another namespace",
+			tiller:  crv1alpha1.ChartMgrTiller{Namespace: "ops"},
+			wantErr: "Chart managers in namespace web are not allowed to use tiller in namespace ops",
+		},
+		{
+			name:    "tiller host",
+			tiller:  crv1alpha1.ChartMgrTiller{Host: "tiller.ops:44134"},
+			wantErr: "Chart managers in namespace web are not allowed to use tiller tiller.ops:44134",
+		},
+		{
+			name:    "namespace and host",
+			tiller:  crv1alpha1.ChartMgrTiller{Namespace: "web", Host: "tiller.web:44134"},
+			wantErr: "Tiller namespace and host are mutually exclusive",
+		},
+		{
+			name:    "neither namespace nor host",
+			wantErr: "Tiller namespace or host is required",
+		},
+	}
+	rules, err := parseTillerRules([]string{"web=ci", "db=ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &tillerPool{
+		defaults: tillerTarget{namespace: "kube-system"},
+		rules:    rules,
+		backends: map[tillerTarget]*tillerBackend{},
+		lastUsed: map[tillerTarget]time.Time{},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiller := tt.tiller
+			chartmgr := &crv1alpha1.ChartManager{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "web"},
+				Spec:       crv1alpha1.ChartMgrSpec{Tiller: &tiller},
+			}
+			_, err := p.selected(chartmgr)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("selected() error = %v, want %s", err, tt.wantErr)
+			}
+			if len(p.backends) != 0 {
+				t.Errorf("connected to %v", p.backends)
+			}
+		})
+	}
+}
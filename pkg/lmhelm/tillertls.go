@@ -0,0 +1,238 @@
+package lmhelm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/redact"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/helm/pkg/tlsutil"
+)
+
+// tillerTLSSecretCheckInterval is how often the Tiller TLS Secret is read
+// again to pick up rotated certificates
+const tillerTLSSecretCheckInterval = time.Minute
+
+// tillerTLS is the client TLS material for Tillers secured with
+// --tiller-tls-verify. it is loaded from files or a Secret and reloaded when
+// they change, so that the connection after a certificate rotation uses the
+// new certificates.
+type tillerTLS struct {
+	mu        sync.Mutex
+	client    *Client
+	certFile  string
+	keyFile   string
+	caFile    string
+	namespace string
+	secret    string
+	cert      *tls.Certificate
+	roots     *x509.CertPool
+	version   string
+	checked   time.Time
+}
+
+// newTillerTLS returns the Tiller TLS material of the config, or nil if
+// Tiller TLS is not configured
+func (c *Client) newTillerTLS() (*tillerTLS, error) {
+	conf := c.chartmgrconfig
+	if conf.TillerTLSSecret == "" && conf.TillerTLSCertFile == "" && conf.TillerTLSKeyFile == "" && conf.TillerTLSCAFile == "" {
+		return nil, nil
+	}
+
+	t := &tillerTLS{
+		client:   c,
+		certFile: conf.TillerTLSCertFile,
+		keyFile:  conf.TillerTLSKeyFile,
+		caFile:   conf.TillerTLSCAFile,
+	}
+
+	if conf.TillerTLSSecret != "" {
+		if t.certFile != "" || t.keyFile != "" || t.caFile != "" {
+			return nil, errors.New("TillerTLSSecret and the TillerTLS files are mutually exclusive")
+		}
+		namespace, name, err := cache.SplitMetaNamespaceKey(conf.TillerTLSSecret)
+		if err != nil {
+			return nil, err
+		}
+		if namespace == "" {
+			namespace = conf.TillerNamespace
+		}
+		t.namespace, t.secret = namespace, name
+	} else if t.certFile == "" || t.keyFile == "" {
+		return nil, errors.New("TillerTLSCertFile and TillerTLSKeyFile are required for Tiller TLS")
+	}
+
+	// fail at startup rather than on the first connection
+	_, _, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Using mutual TLS to tiller")
+	return t, nil
+}
+
+// config returns the TLS config for the helm client of the tiller host. the
+// server certificate is verified by verifyPeer against the current CA, since
+// the roots of a tls.Config can't change.
+func (t *tillerTLS) config(tillerHost string) (*tls.Config, error) {
+	serverName := t.client.chartmgrconfig.TillerTLSServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(tillerHost)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+	return &tls.Config{
+		InsecureSkipVerify:   true, // nolint: gas
+		GetClientCertificate: t.getClientCertificate,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return t.verifyPeer(rawCerts, serverName)
+		},
+	}, nil
+}
+
+func (t *tillerTLS) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _, err := t.current()
+	return cert, err
+}
+
+func (t *tillerTLS) verifyPeer(rawCerts [][]byte, serverName string) error {
+	_, roots, err := t.current()
+	if err != nil {
+		return err
+	}
+	if len(rawCerts) < 1 {
+		return errors.New("Tiller presented no certificate")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		certs[i], err = x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = certs[0].Verify(opts)
+	return err
+}
+
+// current returns the client certificate and the CA, reloading them if they
+// changed. a nil CA verifies against the system roots.
+func (t *tillerTLS) current() (*tls.Certificate, *x509.CertPool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var err error
+	if t.secret != "" {
+		err = t.checkSecret()
+	} else {
+		err = t.checkFiles()
+	}
+	if err != nil {
+		if t.cert == nil {
+			return nil, nil, err
+		}
+		// keep using the certificates loaded before, which may still be valid
+		log.Warnf("Failed to reload tiller TLS certificates: %v", err)
+	}
+	return t.cert, t.roots, nil
+}
+
+// checkFiles reloads the files if their modification times or sizes changed.
+// t.mu must be held.
+func (t *tillerTLS) checkFiles() error {
+	versions := []string{}
+	for _, f := range []string{t.certFile, t.keyFile, t.caFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size()))
+	}
+	version := strings.Join(versions, " ")
+	if t.cert != nil && version == t.version {
+		return nil
+	}
+
+	key, err := ioutil.ReadFile(t.keyFile)
+	if err != nil {
+		return err
+	}
+	redact.Set("tiller key file", []string{string(key)})
+	cert, err := tlsutil.CertFromFilePair(t.certFile, t.keyFile)
+	if err != nil {
+		return err
+	}
+	var roots *x509.CertPool
+	if t.caFile != "" {
+		roots, err = tlsutil.CertPoolFromFile(t.caFile)
+		if err != nil {
+			return err
+		}
+	}
+	t.update(cert, roots, version)
+	return nil
+}
+
+// checkSecret reloads the secret if it changed, reading it at most every
+// tillerTLSSecretCheckInterval. t.mu must be held.
+func (t *tillerTLS) checkSecret() error {
+	if t.cert != nil && time.Since(t.checked) < tillerTLSSecretCheckInterval {
+		return nil
+	}
+	t.checked = time.Now()
+
+	secret, err := t.client.kubeClient.CoreV1().Secrets(t.namespace).Get(t.secret, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	redactSecret(secret)
+	if t.cert != nil && secret.ObjectMeta.ResourceVersion == t.version {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(secret.Data[RepoSecretCertKey], secret.Data[RepoSecretKeyKey])
+	if err != nil {
+		return fmt.Errorf("Failed to load tiller client certificate from secret %s/%s: %v", t.namespace, t.secret, err)
+	}
+	var roots *x509.CertPool
+	if ca := secret.Data[RepoSecretCAKey]; len(ca) > 0 {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("No certificates found in key %s of secret %s/%s", RepoSecretCAKey, t.namespace, t.secret)
+		}
+	}
+	t.update(&cert, roots, secret.ObjectMeta.ResourceVersion)
+	return nil
+}
+
+func (t *tillerTLS) update(cert *tls.Certificate, roots *x509.CertPool, version string) {
+	if t.cert != nil {
+		log.Infof("Reloaded tiller TLS certificates")
+	}
+	t.cert = cert
+	t.roots = roots
+	t.version = version
+}
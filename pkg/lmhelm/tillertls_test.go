@@ -0,0 +1,373 @@
+package lmhelm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	clientfake "github.com/logicmonitor/k8s-chart-manager-controller/pkg/client/fake"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testCA issues certificates for the tiller TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tiller CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate for the DNS name, and its PEM key
+func (ca *testCA) issue(t *testing.T, dnsName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeTillerTLSFiles writes the client certificate, key and CA to dir and
+// returns the config using them
+func writeTillerTLSFiles(t *testing.T, dir string, cert []byte, key []byte, ca []byte) *config.Config {
+	conf := &config.Config{
+		TillerTLSCertFile: filepath.Join(dir, "tls.crt"),
+		TillerTLSKeyFile:  filepath.Join(dir, "tls.key"),
+		TillerTLSCAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	for f, data := range map[string][]byte{
+		conf.TillerTLSCertFile: cert,
+		conf.TillerTLSKeyFile:  key,
+		conf.TillerTLSCAFile:   ca,
+	} {
+		err := ioutil.WriteFile(f, data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conf
+}
+
+// leafPEM returns the PEM of the leaf of the loaded certificate
+func leafPEM(cert *tls.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+}
+
+func TestNewTillerTLSErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *config.Config
+		wantErr string
+	}{
+		{
+			name:    "secret and files",
+			config:  &config.Config{TillerTLSSecret: "tiller-tls", TillerTLSCertFile: "tls.crt"},
+			wantErr: "TillerTLSSecret and the TillerTLS files are mutually exclusive",
+		},
+		{
+			name:    "no key",
+			config:  &config.Config{TillerTLSCertFile: "tls.crt"},
+			wantErr: "TillerTLSCertFile and TillerTLSKeyFile are required for Tiller TLS",
+		},
+		{
+			name:    "missing files",
+			config:  &config.Config{TillerTLSCertFile: "/nonexistent/tls.crt", TillerTLSKeyFile: "/nonexistent/tls.key"},
+			wantErr: "stat /nonexistent/tls.crt: no such file or directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{chartmgrconfig: tt.config}
+			_, err := c.newTillerTLS()
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("newTillerTLS() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+
+	c := &Client{chartmgrconfig: &config.Config{}}
+	tillerTLS, err := c.newTillerTLS()
+	if tillerTLS != nil || err != nil {
+		t.Errorf("newTillerTLS() = %v, %v without TLS config", tillerTLS, err)
+	}
+}
+
+func TestTillerTLSFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chartmgr-tiller-tls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "chartmgr")
+	conf := writeTillerTLSFiles(t, dir, cert, key, ca.pem)
+	c := &Client{chartmgrconfig: conf}
+	tillerTLS, err := c.newTillerTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, roots, err := tillerTLS.current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(leafPEM(loaded), cert) || roots == nil {
+		t.Fatal("the client certificate and CA weren't loaded from the files")
+	}
+	if again, _, _ := tillerTLS.current(); again != loaded {
+		t.Error("the unchanged files were reloaded")
+	}
+
+	// rotating the certificate changes the modification times
+	rotated, rotatedKey := ca.issue(t, "chartmgr")
+	writeTillerTLSFiles(t, dir, rotated, rotatedKey, ca.pem)
+	later := time.Now().Add(time.Minute)
+	for _, f := range []string{conf.TillerTLSCertFile, conf.TillerTLSKeyFile} {
+		err = os.Chtimes(f, later, later)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	loaded, _, err = tillerTLS.current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(leafPEM(loaded), rotated) {
+		t.Error("the rotated certificate wasn't loaded")
+	}
+
+	// a broken rotation keeps the certificate loaded before
+	err = ioutil.WriteFile(conf.TillerTLSKeyFile, []byte("not a key"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, _, err := tillerTLS.current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept != loaded {
+		t.Error("the certificate wasn't kept after a failed reload")
+	}
+}
+
+func TestTillerTLSSecret(t *testing.T) {
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "chartmgr")
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tiller-tls", Namespace: "kube-system", ResourceVersion: "1"},
+		Data: map[string][]byte{
+			RepoSecretCertKey: cert,
+			RepoSecretKeyKey:  key,
+			RepoSecretCAKey:   ca.pem,
+		},
+	}
+	secretPath := "/api/v1/namespaces/kube-system/secrets/tiller-tls"
+	api, err := clientfake.NewKubeAPI(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer api.Close()
+
+	c := &Client{
+		chartmgrconfig: &config.Config{TillerTLSSecret: "tiller-tls", TillerNamespace: "kube-system"},
+		kubeClient:     api.Clientset,
+	}
+	tillerTLS, err := c.newTillerTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, roots, err := tillerTLS.current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(leafPEM(loaded), cert) || roots == nil {
+		t.Fatal("the client certificate and CA weren't loaded from the secret")
+	}
+	if got := api.Requests(secretPath); got != 1 {
+		t.Errorf("%d requests for the secret within the check interval, want 1", got)
+	}
+
+	// an unchanged resource version isn't loaded again
+	tillerTLS.checked = time.Time{}
+	if again, _, _ := tillerTLS.current(); again != loaded {
+		t.Error("the unchanged secret was reloaded")
+	}
+
+	rotated, rotatedKey := ca.issue(t, "chartmgr")
+	secret = secret.DeepCopy()
+	secret.ObjectMeta.ResourceVersion = "2"
+	secret.Data[RepoSecretCertKey] = rotated
+	secret.Data[RepoSecretKeyKey] = rotatedKey
+	api.Set("secrets", "kube-system", "tiller-tls", secret)
+	tillerTLS.checked = time.Time{}
+	loaded, _, err = tillerTLS.current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(leafPEM(loaded), rotated) {
+		t.Error("the rotated certificate wasn't loaded")
+	}
+}
+
+func TestTillerTLSVerifyPeer(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	cert, key := ca.issue(t, "chartmgr")
+	dir, err := ioutil.TempDir("", "chartmgr-tiller-tls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	tests := []struct {
+		name       string
+		serverCA   *testCA
+		serverName string
+		host       string
+		wantErr    string
+	}{
+		{
+			name:     "valid",
+			serverCA: ca,
+			host:     "tiller.kube-system:44134",
+		},
+		{
+			name:       "configured server name",
+			serverCA:   ca,
+			serverName: "tiller.kube-system",
+			host:       "127.0.0.1:44134",
+		},
+		{
+			name:     "wrong server name",
+			serverCA: ca,
+			host:     "tiller.other:44134",
+			wantErr:  "not tiller.other",
+		},
+		{
+			name:     "wrong CA",
+			serverCA: otherCA,
+			host:     "tiller.kube-system:44134",
+			wantErr:  "x509: certificate signed by unknown authority",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := writeTillerTLSFiles(t, dir, cert, key, ca.pem)
+			conf.TillerTLSServerName = tt.serverName
+			tillerTLS, err := (&Client{chartmgrconfig: conf}).newTillerTLS()
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientConfig, err := tillerTLS.config(tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serverCert, serverKey := tt.serverCA.issue(t, "tiller.kube-system")
+			serverPair, err := tls.X509KeyPair(serverCert, serverKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientCAs := x509.NewCertPool()
+			clientCAs.AddCert(ca.cert)
+
+			// tiller requires the client certificate like --tiller-tls-verify
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close() // nolint: errcheck
+			defer serverConn.Close() // nolint: errcheck
+			server := tls.Server(serverConn, &tls.Config{
+				Certificates: []tls.Certificate{serverPair},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    clientCAs,
+			})
+			serverErr := make(chan error, 1)
+			go func() {
+				serverErr <- server.Handshake()
+			}()
+
+			err = tls.Client(clientConn, clientConfig).Handshake()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("handshake error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = <-serverErr; err != nil {
+				t.Errorf("tiller rejected the client certificate: %v", err)
+			}
+		})
+	}
+
+	// verifyPeer also refuses a handshake without certificates
+	tillerTLS, err := (&Client{chartmgrconfig: writeTillerTLSFiles(t, dir, cert, key, ca.pem)}).newTillerTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tillerTLS.verifyPeer(nil, "tiller.kube-system"); err == nil || err.Error() != "Tiller presented no certificate" {
+		t.Errorf("verifyPeer() error = %v", err)
+	}
+}
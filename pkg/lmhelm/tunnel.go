@@ -0,0 +1,128 @@
+package lmhelm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// tillerPort is the gRPC port of the tiller pod
+const tillerPort = 44134
+
+// tillerPodLabels select the tiller pods
+var tillerPodLabels = labels.Set{"app": "helm", "name": "tiller"}
+
+// tillerTunnel forwards a local port to a tiller pod. unlike kube.Tunnel it
+// only closes the stop channel it owns, since the port forwarder closes the
+// ready channel itself.
+type tillerTunnel struct {
+	mu     sync.Mutex
+	local  int
+	stop   chan struct{}
+	closed bool
+}
+
+// openTillerTunnel forwards a local port to a ready tiller pod in the
+// namespace
+func (c *Client) openTillerTunnel(namespace string) (*tillerTunnel, error) {
+	pod, err := c.tillerPod(namespace)
+	if err != nil {
+		return nil, err
+	}
+	local, err := availablePort()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find an available port: %v", err)
+	}
+
+	u := c.kubeClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward").
+		URL()
+	transport, upgrader, err := spdy.RoundTripperFor(c.restConfig)
+	if err != nil {
+		return nil, err
+	}
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, u)
+
+	t := &tillerTunnel{local: local, stop: make(chan struct{})}
+	ready := make(chan struct{})
+	ports := []string{fmt.Sprintf("%d:%d", local, tillerPort)}
+	pf, err := portforward.New(dialer, ports, t.stop, ready, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- pf.ForwardPorts()
+	}()
+	select {
+	case err = <-errc:
+		t.close()
+		return nil, fmt.Errorf("Failed to forward a port to tiller pod %s/%s: %v", namespace, pod, err)
+	case <-ready:
+		return t, nil
+	}
+}
+
+// tillerPod returns the name of a ready tiller pod in the namespace
+func (c *Client) tillerPod(namespace string) (string, error) {
+	pods, err := c.kubeClient.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: tillerPodLabels.AsSelector().String(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(pods.Items) < 1 {
+		return "", fmt.Errorf("Could not find tiller in namespace %s", namespace)
+	}
+	for _, pod := range pods.Items {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == v1.PodReady && cond.Status == v1.ConditionTrue {
+				return pod.ObjectMeta.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Could not find a ready tiller pod in namespace %s", namespace)
+}
+
+// close stops the port forwarding. it does nothing for a nil or already
+// closed tunnel.
+func (t *tillerTunnel) close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	close(t.stop)
+	t.closed = true
+}
+
+// availablePort returns a local port that is free to listen on
+func availablePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close() // nolint: errcheck
+
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
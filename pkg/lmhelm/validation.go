@@ -0,0 +1,207 @@
+package lmhelm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/go-openapi/spec"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+const (
+	valuesSchemaFile = "values.schema.json"
+	globalValuesKey  = "global"
+)
+
+// validateValues checks the value overrides against the chart and returns a
+// description of each offending key. if the chart ships a values schema the
+// overrides are checked against the schema, otherwise against the values of
+// the chart and its dependencies.
+func validateValues(c *chart.Chart, vals []byte) ([]string, error) {
+	overrides, err := chartutil.ReadValues(vals)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := chartSchema(c)
+	if err != nil {
+		return nil, err
+	}
+	if schema != nil {
+		log.Debugf("Validating values against chart %s", valuesSchemaFile)
+		return validateSchema("", overrides.AsMap(), schema), nil
+	}
+
+	defaults, err := chartutil.CoalesceValues(withAliases(c), &chart.Config{})
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Validating values against chart values")
+	return compareValues("", overrides.AsMap(), defaults.AsMap()), nil
+}
+
+// withAliases returns a copy of the chart that also has its dependencies
+// under the aliases of its requirements, so values of aliased dependencies
+// are known
+func withAliases(c *chart.Chart) *chart.Chart {
+	reqs, err := chartutil.LoadRequirements(c)
+	if err != nil {
+		return c
+	}
+	aliased := *c
+	aliased.Dependencies = append([]*chart.Chart{}, c.Dependencies...)
+	for _, req := range reqs.Dependencies {
+		if req.Alias == "" {
+			continue
+		}
+		for _, dep := range c.Dependencies {
+			if dep.GetMetadata().GetName() != req.Name {
+				continue
+			}
+			alias := *dep
+			metadata := *dep.Metadata
+			metadata.Name = req.Alias
+			alias.Metadata = &metadata
+			aliased.Dependencies = append(aliased.Dependencies, &alias)
+			break
+		}
+	}
+	return &aliased
+}
+
+func chartSchema(c *chart.Chart) (*spec.Schema, error) {
+	for _, f := range c.GetFiles() {
+		if f.GetTypeUrl() != valuesSchemaFile {
+			continue
+		}
+		schema := &spec.Schema{}
+		err := json.Unmarshal(f.GetValue(), schema)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse chart %s: %v", valuesSchemaFile, err)
+		}
+		return schema, nil
+	}
+	return nil, nil
+}
+
+// compareValues compares the overrides with the chart values. globals are
+// shared by the chart and its dependencies and needn't be declared by them.
+func compareValues(prefix string, overrides map[string]interface{}, defaults map[string]interface{}) []string {
+	invalid := []string{}
+	for _, k := range sortedKeys(overrides) {
+		if k == globalValuesKey {
+			continue
+		}
+		path := valuePath(prefix, k)
+		d, ok := defaults[k]
+		if !ok {
+			invalid = append(invalid, fmt.Sprintf("%s: not found in chart values", path))
+			continue
+		}
+
+		o := overrides[k]
+		if d == nil || o == nil {
+			continue
+		}
+		if valueKind(d) != valueKind(o) {
+			invalid = append(invalid, fmt.Sprintf("%s: expected %s but got %s", path, valueKind(d), valueKind(o)))
+			continue
+		}
+
+		// an empty map in the chart values is free-form
+		dm, ok := d.(map[string]interface{})
+		if ok && len(dm) > 0 {
+			invalid = append(invalid, compareValues(path, o.(map[string]interface{}), dm)...)
+		}
+	}
+	return invalid
+}
+
+func validateSchema(path string, v interface{}, s *spec.Schema) []string {
+	if len(s.Type) > 0 && !schemaTypeMatches(s.Type, v) {
+		return []string{fmt.Sprintf("%s: expected %s but got %s", path, strings.Join(s.Type, " or "), valueKind(v))}
+	}
+
+	invalid := []string{}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			p := valuePath(path, k)
+			if prop, ok := s.Properties[k]; ok {
+				invalid = append(invalid, validateSchema(p, t[k], &prop)...)
+				continue
+			}
+			if s.AdditionalProperties == nil {
+				continue
+			}
+			if s.AdditionalProperties.Schema != nil {
+				invalid = append(invalid, validateSchema(p, t[k], s.AdditionalProperties.Schema)...)
+			} else if !s.AdditionalProperties.Allows {
+				invalid = append(invalid, fmt.Sprintf("%s: not found in chart values schema", p))
+			}
+		}
+	case []interface{}:
+		if s.Items == nil || s.Items.Schema == nil {
+			return invalid
+		}
+		for i, item := range t {
+			invalid = append(invalid, validateSchema(fmt.Sprintf("%s[%d]", path, i), item, s.Items.Schema)...)
+		}
+	}
+	return invalid
+}
+
+func schemaTypeMatches(types spec.StringOrArray, v interface{}) bool {
+	kind := valueKind(v)
+	for _, t := range types {
+		switch {
+		case t == kind:
+			return true
+		case t == "integer" && kind == "number":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func valueKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, int, int64:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func valuePath(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
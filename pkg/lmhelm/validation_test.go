@@ -0,0 +1,100 @@
+package lmhelm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func newTestValidationChart(schema string) *chart.Chart {
+	sub := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "sub", Version: "0.1.0"},
+		Values:   &chart.Config{Raw: "color: red\nsize:\n  width: 1\n"},
+	}
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "app", Version: "1.0.0"},
+		Values:   &chart.Config{Raw: "replicas: 1\nimage:\n  tag: latest\nextra: {}\n"},
+		Files: []*any.Any{
+			{TypeUrl: "requirements.yaml", Value: []byte("dependencies:\n- name: sub\n  version: 0.1.0\n- name: sub\n  version: 0.1.0\n  alias: other\n")},
+		},
+		Dependencies: []*chart.Chart{sub},
+	}
+	if schema != "" {
+		c.Files = append(c.Files, &any.Any{TypeUrl: valuesSchemaFile, Value: []byte(schema)})
+	}
+	return c
+}
+
+func TestValidateValues(t *testing.T) {
+	schema := `{
+  "type": "object",
+  "properties": {
+    "replicas": {"type": "integer"},
+    "ports": {"type": "array", "items": {"type": "integer"}}
+  },
+  "additionalProperties": false
+}`
+
+	tests := []struct {
+		name   string
+		schema string
+		vals   string
+		want   []string
+	}{
+		{
+			name: "chart values",
+			vals: "replicas: 3\nimage:\n  tag: v1\nextra:\n  anything: true\n",
+			want: []string{},
+		},
+		{
+			name: "unknown and mistyped values",
+			vals: "replica: 3\nimage: v1\n",
+			want: []string{"image: expected object but got string", "replica: not found in chart values"},
+		},
+		{
+			name: "dependency values",
+			vals: "sub:\n  color: blue\n  size:\n    width: 2\nother:\n  color: green\n",
+			want: []string{},
+		},
+		{
+			name: "unknown dependency values",
+			vals: "sub:\n  colour: blue\n  size:\n    width: wide\nmissing:\n  color: blue\n",
+			want: []string{"missing: not found in chart values", "sub.colour: not found in chart values", "sub.size.width: expected number but got string"},
+		},
+		{
+			name: "globals",
+			vals: "global:\n  region: us-east-1\nsub:\n  global:\n    region: eu-west-1\n",
+			want: []string{},
+		},
+		{
+			name:   "schema",
+			schema: schema,
+			vals:   "replicas: 1.5\nports: [80, \"443\"]\nextra: true\n",
+			want:   []string{"extra: not found in chart values schema", "ports[1]: expected integer but got string", "replicas: expected integer but got number"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateValues(newTestValidationChart(tt.schema), []byte(tt.vals))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateValues() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateValuesKeepsChart(t *testing.T) {
+	c := newTestValidationChart("")
+	_, err := validateValues(c, []byte("other:\n  color: blue\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Dependencies) != 1 || c.Dependencies[0].Metadata.Name != "sub" {
+		t.Errorf("dependencies changed to %v", c.Dependencies)
+	}
+}
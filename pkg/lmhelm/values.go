@@ -1,20 +1,66 @@
 package lmhelm
 
 import (
+	"encoding/json"
 	"fmt"
-	"strings"
 
 	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/redact"
 	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/helm/pkg/strvals"
 )
 
-func parseValues(chartmgr *crv1alpha1.ChartManager) ([]byte, error) {
+// typedValuePlaceholder is set by strvals in place of a typed value so that
+// the value can be swapped in afterwards without being re-typed by the parser
+const typedValuePlaceholder = "\x00chartmgr-typed-value\x00"
+
+func parseValues(r *Release) ([]byte, error) {
 	log.Debugf("Parsing values")
 
-	s := valuesToString(chartmgr)
-	y, err := stringValuesToYaml(s)
+	profiles, err := r.matchingProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// profile values are applied first so the chartmgr values override them
+	values := []*crv1alpha1.ChartMgrValuePair{}
+	r.appliedProfiles = []string{}
+	for _, profile := range profiles {
+		log.Debugf("Applying values profile %s", profile.ObjectMeta.Name)
+		values = append(values, profile.Spec.Values...)
+		r.appliedProfiles = append(r.appliedProfiles, profile.ObjectMeta.Name)
+	}
+	values = append(values, r.Chartmgr.Spec.Values...)
+
+	base := map[string]interface{}{}
+	secrets := []string{}
+	if len(values) > 0 {
+		vars, err := r.variables()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range values {
+			log.Debugf("Parsing value %s", value.Name)
+			s, err := resolveValue(r, value, vars)
+			if err != nil {
+				return nil, err
+			}
+			if sensitive(value) {
+				secrets = append(secrets, s)
+			}
+
+			err = parseValue(value, s, base)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	redact.Set(r.redactionKey(), secrets)
+
+	y, err := yaml.Marshal(base)
 	if err != nil {
 		return nil, err
 	}
@@ -23,35 +69,116 @@ func parseValues(chartmgr *crv1alpha1.ChartManager) ([]byte, error) {
 	return y, nil
 }
 
-func valuesToString(chartmgr *crv1alpha1.ChartManager) string {
-	vals := []string{}
+// resolveValue returns the raw string of the value, read from its source or
+// with variables substituted. values of every type may be read from a
+// source, which values of type file require.
+func resolveValue(r *Release, value *crv1alpha1.ChartMgrValuePair, vars map[string]string) (string, error) {
+	if value.ValueFrom != nil {
+		if value.Value != "" {
+			return "", fmt.Errorf("Value %s sets both value and valueFrom", value.Name)
+		}
+		return sourceValue(r, value)
+	}
+	if value.Type == crv1alpha1.ChartMgrValueTypeFile {
+		return "", fmt.Errorf("Value %s of type %s requires valueFrom.configMapKeyRef or valueFrom.secretKeyRef", value.Name, value.Type)
+	}
+
+	s, err := substituteVariables(value.Value, vars)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse value %s: %v", value.Name, err)
+	}
+	return s, nil
+}
+
+func parseValue(value *crv1alpha1.ChartMgrValuePair, s string, base map[string]interface{}) error {
+	switch value.Type {
+	case crv1alpha1.ChartMgrValueTypeString, crv1alpha1.ChartMgrValueTypeFile:
+		return setTypedValue(value.Name, s, base)
+	case crv1alpha1.ChartMgrValueTypeJSON:
+		var v interface{}
+		err := json.Unmarshal([]byte(s), &v)
+		if err != nil {
+			return fmt.Errorf("Failed to parse JSON value %s: %v", value.Name, err)
+		}
+		return setTypedValue(value.Name, v, base)
+	default:
+		return strvals.ParseInto(fmt.Sprintf("%s=%s", value.Name, s), base)
+	}
+}
 
-	// iterate our name value pair and format as string
-	for _, value := range chartmgr.Spec.Values {
-		log.Debugf("Parsing value %s", value.Name)
-		if !validateValue(value) {
-			log.Errorf("Error parsing value %v. Continuing.", value)
-			continue
+func setTypedValue(name string, v interface{}, base map[string]interface{}) error {
+	// let strvals resolve the key path, then replace the placeholder it set
+	err := strvals.ParseInto(fmt.Sprintf("%s=%s", name, typedValuePlaceholder), base)
+	if err != nil {
+		return err
+	}
+	replacePlaceholder(base, v)
+	return nil
+}
+
+func replacePlaceholder(node interface{}, v interface{}) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, child := range n {
+			if child == typedValuePlaceholder {
+				n[k] = v
+				continue
+			}
+			replacePlaceholder(child, v)
+		}
+	case []interface{}:
+		for i, child := range n {
+			if child == typedValuePlaceholder {
+				n[i] = v
+				continue
+			}
+			replacePlaceholder(child, v)
 		}
-		vals = append(vals, fmt.Sprintf("%s=%s", value.Name, value.Value))
 	}
-	return strings.Join(vals[:], ",")
 }
 
-func stringValuesToYaml(s string) ([]byte, error) {
-	base := map[string]interface{}{}
-	// join k/v string and parse
-	err := strvals.ParseInto(s, base)
+func sourceValue(r *Release, value *crv1alpha1.ChartMgrValuePair) (string, error) {
+	switch {
+	case value.ValueFrom != nil && value.ValueFrom.ConfigMapKeyRef != nil:
+		return configMapValue(r, value.Name, value.ValueFrom.ConfigMapKeyRef)
+	case value.ValueFrom != nil && value.ValueFrom.SecretKeyRef != nil:
+		return secretValue(r, value.Name, value.ValueFrom.SecretKeyRef)
+	default:
+		return "", fmt.Errorf("Value %s requires valueFrom.configMapKeyRef or valueFrom.secretKeyRef", value.Name)
+	}
+}
+
+func configMapValue(r *Release, name string, ref *crv1alpha1.ChartMgrConfigMapKeyRef) (string, error) {
+	namespace := r.Chartmgr.ObjectMeta.Namespace
+	log.Debugf("Reading value %s from configmap %s/%s key %s", name, namespace, ref.Name, ref.Key)
+	cm, err := r.Client.kubeClient.CoreV1().ConfigMaps(namespace).Get(ref.Name, metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+
+	v, ok := cm.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("Key %s not found in configmap %s/%s", ref.Key, namespace, ref.Name)
 	}
+	return v, nil
+}
 
-	return yaml.Marshal(base)
+func secretValue(r *Release, name string, ref *crv1alpha1.ChartMgrSecretKeyRef) (string, error) {
+	namespace := r.Chartmgr.ObjectMeta.Namespace
+	log.Debugf("Reading value %s from secret %s/%s key %s", name, namespace, ref.Name, ref.Key)
+	secret, err := r.Client.kubeClient.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	v, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("Key %s not found in secret %s/%s", ref.Key, namespace, ref.Name)
+	}
+	return string(v), nil
 }
 
-func validateValue(value *crv1alpha1.ChartMgrValuePair) bool {
-	// placeholder.
-	// basic type and required field validation is done at the CRD level.
-	// no additional validation to be done at this time.
-	return true
+// sensitive returns true if the value must be masked in logs and status
+func sensitive(value *crv1alpha1.ChartMgrValuePair) bool {
+	return value.Sensitive || (value.ValueFrom != nil && value.ValueFrom.SecretKeyRef != nil)
 }
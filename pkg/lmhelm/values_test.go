@@ -0,0 +1,163 @@
+package lmhelm
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	crv1alpha1 "github.com/logicmonitor/k8s-chart-manager-controller/pkg/apis/v1alpha1"
+	clientfake "github.com/logicmonitor/k8s-chart-manager-controller/pkg/client/fake"
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newTestRelease returns a release of a chart manager in the tenant namespace
+// whose client reads from a fake API server serving the objects
+func newTestRelease(t *testing.T, chartmgrconfig *config.Config, objects ...runtime.Object) (*Release, *clientfake.KubeAPI, func()) {
+	api, err := clientfake.NewKubeAPI(objects...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, err := ioutil.TempDir("", "chartmgr-home-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(chartmgrconfig, api.Clientset, nil, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chartmgr := &crv1alpha1.ChartManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "tenant", UID: "1234"},
+		Spec: crv1alpha1.ChartMgrSpec{
+			Chart: &crv1alpha1.ChartMgrChart{Name: "app", Version: "1.0.0"},
+		},
+	}
+	cleanup := func() {
+		api.Close()
+		os.RemoveAll(home) // nolint: errcheck
+	}
+	return &Release{Client: client, Chartmgr: chartmgr}, api, cleanup
+}
+
+func TestParseValues(t *testing.T) {
+	objects := []runtime.Object{
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "tenant"},
+			Data: map[string]string{
+				"ports":   `[80, 443]`,
+				"replica": "3",
+			},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "tenant"},
+			Data:       map[string][]byte{"password": []byte("0123")},
+		},
+	}
+	fromConfigMap := func(key string) *crv1alpha1.ChartMgrValueSource {
+		return &crv1alpha1.ChartMgrValueSource{ConfigMapKeyRef: &crv1alpha1.ChartMgrConfigMapKeyRef{Name: "settings", Key: key}}
+	}
+	fromSecret := func(key string) *crv1alpha1.ChartMgrValueSource {
+		return &crv1alpha1.ChartMgrValueSource{SecretKeyRef: &crv1alpha1.ChartMgrSecretKeyRef{Name: "credentials", Key: key}}
+	}
+
+	tests := []struct {
+		name    string
+		value   crv1alpha1.ChartMgrValuePair
+		want    interface{}
+		wantErr string
+	}{
+		{
+			name:  "auto",
+			value: crv1alpha1.ChartMgrValuePair{Name: "v", Value: "3"},
+			want:  3,
+		},
+		{
+			name:  "string",
+			value: crv1alpha1.ChartMgrValuePair{Name: "v", Value: "3", Type: crv1alpha1.ChartMgrValueTypeString},
+			want:  "3",
+		},
+		{
+			name:  "json",
+			value: crv1alpha1.ChartMgrValuePair{Name: "v", Value: `{"a": [true]}`, Type: crv1alpha1.ChartMgrValueTypeJSON},
+			want:  map[interface{}]interface{}{"a": []interface{}{true}},
+		},
+		{
+			name:  "variable",
+			value: crv1alpha1.ChartMgrValuePair{Name: "v", Value: "${CHARTMGR_NAMESPACE}", Type: crv1alpha1.ChartMgrValueTypeString},
+			want:  "tenant",
+		},
+		{
+			name:  "file",
+			value: crv1alpha1.ChartMgrValuePair{Name: "v", Type: crv1alpha1.ChartMgrValueTypeFile, ValueFrom: fromConfigMap("ports")},
+			want:  "[80, 443]",
+		},
+		{
+			name:  "auto from a configmap",
+			value: crv1alpha1.ChartMgrValuePair{Name: "v", ValueFrom: fromConfigMap("replica")},
+			want:  3,
+		},
+		{
+			name:  "json from a configmap",
+			value: crv1alpha1.ChartMgrValuePair{Name: "v", Type: crv1alpha1.ChartMgrValueTypeJSON, ValueFrom: fromConfigMap("ports")},
+			want:  []interface{}{80, 443},
+		},
+		{
+			name:  "string from a secret",
+			value: crv1alpha1.ChartMgrValuePair{Name: "v", Type: crv1alpha1.ChartMgrValueTypeString, ValueFrom: fromSecret("password")},
+			want:  "0123",
+		},
+		{
+			name:    "file without a source",
+			value:   crv1alpha1.ChartMgrValuePair{Name: "v", Value: "a", Type: crv1alpha1.ChartMgrValueTypeFile},
+			wantErr: "requires valueFrom",
+		},
+		{
+			name:    "value and source",
+			value:   crv1alpha1.ChartMgrValuePair{Name: "v", Value: "a", ValueFrom: fromSecret("password")},
+			wantErr: "both value and valueFrom",
+		},
+		{
+			name:    "empty source",
+			value:   crv1alpha1.ChartMgrValuePair{Name: "v", ValueFrom: &crv1alpha1.ChartMgrValueSource{}},
+			wantErr: "requires valueFrom",
+		},
+		{
+			name:    "missing key",
+			value:   crv1alpha1.ChartMgrValuePair{Name: "v", Type: crv1alpha1.ChartMgrValueTypeJSON, ValueFrom: fromConfigMap("missing")},
+			wantErr: "Key missing not found",
+		},
+	}
+
+	r, _, cleanup := newTestRelease(t, &config.Config{}, objects...)
+	defer cleanup()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := tt.value
+			r.Chartmgr.Spec.Values = []*crv1alpha1.ChartMgrValuePair{&value}
+			y, err := parseValues(r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseValues() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := map[string]interface{}{}
+			err = yaml.Unmarshal(y, &values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values["v"], tt.want) {
+				t.Errorf("value = %#v, want %#v", values["v"], tt.want)
+			}
+		})
+	}
+}
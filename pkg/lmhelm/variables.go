@@ -0,0 +1,126 @@
+package lmhelm
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// VariableChartMgrName is the built-in variable holding the chart manager name
+	VariableChartMgrName = "CHARTMGR_NAME"
+	// VariableChartMgrNamespace is the built-in variable holding the chart manager namespace
+	VariableChartMgrNamespace = "CHARTMGR_NAMESPACE"
+	// VariableKubernetesVersion is the built-in variable holding the cluster's Kubernetes version
+	VariableKubernetesVersion = "KUBERNETES_VERSION"
+)
+
+// variablePattern matches ${VAR}. a leading $$ escapes the reference.
+var variablePattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// clusterVariables caches the variables read from the cluster, which every
+// parse of the values needs
+type clusterVariables struct {
+	mu      sync.Mutex
+	vars    map[string]string
+	fetched time.Time
+}
+
+func (r *Release) variables() (map[string]string, error) {
+	vars := map[string]string{}
+	for k, v := range r.Client.Config().Variables {
+		vars[k] = v
+	}
+
+	cluster, err := r.Client.clusterVariables()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range cluster {
+		vars[k] = v
+	}
+	vars[VariableChartMgrName] = r.Chartmgr.ObjectMeta.Name
+	vars[VariableChartMgrNamespace] = r.Chartmgr.ObjectMeta.Namespace
+	return vars, nil
+}
+
+// clusterVariables returns the variables of the variables ConfigMap and the
+// Kubernetes version, reading them again once they are older than the TTL.
+// the returned map must not be modified.
+func (c *Client) clusterVariables() (map[string]string, error) {
+	c.variables.mu.Lock()
+	defer c.variables.mu.Unlock()
+
+	ttl := time.Duration(c.Config().VariablesCacheTTLSec) * time.Second
+	if c.variables.vars != nil && time.Since(c.variables.fetched) < ttl {
+		return c.variables.vars, nil
+	}
+
+	vars := map[string]string{}
+	cmVars, err := c.configMapVariables()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range cmVars {
+		vars[k] = v
+	}
+
+	version, err := c.kubeClient.Discovery().ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	vars[VariableKubernetesVersion] = version.GitVersion
+
+	c.variables.vars = vars
+	c.variables.fetched = time.Now()
+	return vars, nil
+}
+
+func (c *Client) configMapVariables() (map[string]string, error) {
+	if c.Config().VariablesConfigMap == "" {
+		return nil, nil
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(c.Config().VariablesConfigMap)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Reading variables from configmap %s/%s", namespace, name)
+	cm, err := c.kubeClient.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return cm.Data, nil
+}
+
+func substituteVariables(s string, vars map[string]string) (string, error) {
+	undefined := map[string]bool{}
+	out := variablePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		name := variablePattern.FindStringSubmatch(ref)[1]
+		v, ok := vars[name]
+		if !ok {
+			undefined[name] = true
+		}
+		return v
+	})
+
+	if len(undefined) > 0 {
+		names := []string{}
+		for name := range undefined {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("Undefined variables: %s", strings.Join(names, ", "))
+	}
+	return out, nil
+}
@@ -0,0 +1,191 @@
+package lmhelm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/logicmonitor/k8s-chart-manager-controller/pkg/config"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSubstituteVariables(t *testing.T) {
+	vars := map[string]string{"REGION": "us-east-1", "CLUSTER": "prod", "EMPTY": ""}
+
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "no variables",
+			s:    "replicas: 3",
+			want: "replicas: 3",
+		},
+		{
+			name: "variables",
+			s:    "${CLUSTER}-${REGION}.example.com",
+			want: "prod-us-east-1.example.com",
+		},
+		{
+			name: "empty variable",
+			s:    "suffix${EMPTY}",
+			want: "suffix",
+		},
+		{
+			name: "escaped",
+			s:    "$${REGION} is ${REGION}",
+			want: "${REGION} is us-east-1",
+		},
+		{
+			name: "escaped undefined",
+			s:    "$${UNDEFINED}",
+			want: "${UNDEFINED}",
+		},
+		{
+			name: "not a reference",
+			s:    "$REGION ${1A} ${}",
+			want: "$REGION ${1A} ${}",
+		},
+		{
+			name:    "undefined",
+			s:       "${ZONE} ${REGION} ${ACCOUNT} ${ZONE}",
+			wantErr: "Undefined variables: ACCOUNT, ZONE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := substituteVariables(tt.s, vars)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("substituteVariables() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("substituteVariables() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReleaseVariables(t *testing.T) {
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "variables", Namespace: "chartmgr"},
+		Data:       map[string]string{"REGION": "eu-west-1", "ZONE": "b"},
+	}
+
+	tests := []struct {
+		name    string
+		config  *config.Config
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "built-in",
+			config: &config.Config{},
+			want:   map[string]string{},
+		},
+		{
+			name:   "configured",
+			config: &config.Config{Variables: map[string]string{"REGION": "us-east-1"}},
+			want:   map[string]string{"REGION": "us-east-1"},
+		},
+		{
+			name: "configmap",
+			config: &config.Config{
+				Variables:          map[string]string{"REGION": "us-east-1", "CLUSTER": "prod"},
+				VariablesConfigMap: "chartmgr/variables",
+			},
+			want: map[string]string{"REGION": "eu-west-1", "ZONE": "b", "CLUSTER": "prod"},
+		},
+		{
+			name:    "missing configmap",
+			config:  &config.Config{VariablesConfigMap: "chartmgr/missing"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid configmap",
+			config:  &config.Config{VariablesConfigMap: "chartmgr/variables/extra"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _, cleanup := newTestRelease(t, tt.config, cm)
+			defer cleanup()
+
+			got, err := r.variables()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("variables() succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got[VariableKubernetesVersion] == "" {
+				t.Errorf("%s isn't set", VariableKubernetesVersion)
+			}
+			delete(got, VariableKubernetesVersion)
+			tt.want[VariableChartMgrName] = "app"
+			tt.want[VariableChartMgrNamespace] = "tenant"
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("variables() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReleaseVariablesCache(t *testing.T) {
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "variables", Namespace: "chartmgr"},
+		Data:       map[string]string{"REGION": "eu-west-1"},
+	}
+	cmPath := "/api/v1/namespaces/chartmgr/configmaps/variables"
+
+	tests := []struct {
+		name         string
+		ttl          int64
+		wantRequests int
+	}{
+		{name: "cached", ttl: 60, wantRequests: 1},
+		{name: "disabled", ttl: 0, wantRequests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, api, cleanup := newTestRelease(t, &config.Config{
+				VariablesConfigMap:   "chartmgr/variables",
+				VariablesCacheTTLSec: tt.ttl,
+			}, cm)
+			defer cleanup()
+
+			for i := 0; i < 2; i++ {
+				vars, err := r.variables()
+				if err != nil {
+					t.Fatal(err)
+				}
+				// callers modifying the variables don't change the cache
+				vars["REGION"] = "changed"
+			}
+			if got := api.Requests("/version"); got != tt.wantRequests {
+				t.Errorf("%d requests for the version, want %d", got, tt.wantRequests)
+			}
+			if got := api.Requests(cmPath); got != tt.wantRequests {
+				t.Errorf("%d requests for the configmap, want %d", got, tt.wantRequests)
+			}
+			vars, err := r.variables()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if vars["REGION"] != "eu-west-1" {
+				t.Errorf("REGION = %s", vars["REGION"])
+			}
+		})
+	}
+}
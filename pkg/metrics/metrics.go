@@ -7,8 +7,11 @@ import (
 )
 
 var (
-	m    *expvar.Map
-	once sync.Once
+	m        *expvar.Map
+	cache    *expvar.Map
+	orphans  *expvar.Map
+	orphaned *expvar.Int
+	once     sync.Once
 )
 
 func init() {
@@ -16,6 +19,14 @@ func init() {
 		m = expvar.NewMap("errors")
 		m.Add("APIErrors", 0)
 		m.Add("RESTErrors", 0)
+		cache = expvar.NewMap("chartCache")
+		cache.Add("Hits", 0)
+		cache.Add("Misses", 0)
+		cache.Add("Evictions", 0)
+		orphans = expvar.NewMap("orphanedReleases")
+		orphaned = new(expvar.Int)
+		orphans.Set("Current", orphaned)
+		orphans.Add("Purged", 0)
 	})
 	expvar.Publish("goroutines", expvar.Func(goroutines))
 }
@@ -30,6 +41,32 @@ func RESTError() {
 	m.Add("RESTErrors", 1)
 }
 
+// ChartCacheHit increments the chart cache hit count by 1.
+func ChartCacheHit() {
+	cache.Add("Hits", 1)
+}
+
+// ChartCacheMiss increments the chart cache miss count by 1.
+func ChartCacheMiss() {
+	cache.Add("Misses", 1)
+}
+
+// ChartCacheEviction increments the chart cache eviction count by 1.
+func ChartCacheEviction() {
+	cache.Add("Evictions", 1)
+}
+
+// OrphanedReleases sets the number of orphaned releases found by the last
+// sweep.
+func OrphanedReleases(n int64) {
+	orphaned.Set(n)
+}
+
+// OrphanPurged increments the purged orphaned release count by 1.
+func OrphanPurged() {
+	orphans.Add("Purged", 1)
+}
+
 func goroutines() interface{} {
 	return runtime.NumGoroutine()
 }
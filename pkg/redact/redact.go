@@ -0,0 +1,87 @@
+package redact
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mask is the string that replaces secret material.
+const Mask = "******"
+
+var (
+	mu      sync.RWMutex
+	secrets = map[string][]string{}
+)
+
+// Set replaces the secret material registered by owner. all material is
+// masked whatever its length, except empty strings.
+func Set(owner string, s []string) {
+	material := []string{}
+	for _, secret := range s {
+		if secret != "" {
+			material = append(material, secret)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(material) < 1 {
+		delete(secrets, owner)
+		return
+	}
+	// mask the longest secrets first so that a secret containing another
+	// secret is not partially unmasked
+	sort.Slice(material, func(i, j int) bool {
+		return len(material[i]) > len(material[j])
+	})
+	secrets[owner] = material
+}
+
+// Remove forgets the secret material registered by owner.
+func Remove(owner string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(secrets, owner)
+}
+
+// String masks all registered secret material in s.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, material := range secrets {
+		for _, secret := range material {
+			s = strings.Replace(s, secret, Mask, -1)
+		}
+	}
+	return s
+}
+
+// Hook is a logrus hook that masks registered secret material in the message
+// and fields of every log entry.
+type Hook struct{}
+
+// Levels returns the log levels the hook fires for.
+func (h *Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire masks the secret material in the log entry. fields holding secret
+// material are replaced by their masked string form, in a copy of the
+// fields since they may be shared with other entries.
+func (h *Hook) Fire(entry *log.Entry) error {
+	entry.Message = String(entry.Message)
+
+	data := make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = v
+		if s := fmt.Sprint(v); String(s) != s {
+			data[k] = String(s)
+		}
+	}
+	entry.Data = data
+	return nil
+}
@@ -0,0 +1,46 @@
+package redact
+
+import (
+	"errors"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestHookFire(t *testing.T) {
+	Set("test", []string{"s3cr3t-t0ken", "pin1", ""})
+	defer Remove("test")
+
+	fields := log.Fields{
+		"token":  "s3cr3t-t0ken",
+		"error":  errors.New("auth with s3cr3t-t0ken failed"),
+		"count":  3,
+		"pin":    "pin1",
+		"public": "short",
+	}
+	entry := log.WithFields(fields)
+	entry.Message = "using s3cr3t-t0ken"
+	err := (&Hook{}).Fire(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := log.Fields{
+		"token":  Mask,
+		"error":  "auth with " + Mask + " failed",
+		"count":  3,
+		"pin":    Mask,
+		"public": "short",
+	}
+	for k, v := range want {
+		if entry.Data[k] != v {
+			t.Errorf("field %s = %v, want %v", k, entry.Data[k], v)
+		}
+	}
+	if entry.Message != "using "+Mask {
+		t.Errorf("message = %q", entry.Message)
+	}
+	if fields["token"] != "s3cr3t-t0ken" {
+		t.Error("the fields of the caller were modified")
+	}
+}
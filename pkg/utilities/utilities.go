@@ -23,3 +23,8 @@ func EnsureDirectory(dir string) error {
 func I64ToPI64(i int64) *int64 {
 	return &i
 }
+
+// F64ToPF64 returns the passed float as a pointer
+func F64ToPF64(f float64) *float64 {
+	return &f
+}